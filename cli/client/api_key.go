@@ -0,0 +1,57 @@
+package client
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// DefaultConfigPath is where the config of kode is, given by KODE_CONFIG or
+// ~/.kode/config.json
+func DefaultConfigPath() string {
+	if p := os.Getenv("KODE_CONFIG"); p != "" {
+		return p
+	}
+	return filepath.Join(os.Getenv("HOME"), ".kode", "config.json")
+}
+
+// LoadAPIKey returns the API key given by KODE_API_KEY, or by the api_key of
+// the config. There is none if neither is given.
+func LoadAPIKey(configPath string) (string, error) {
+	if key := os.Getenv("KODE_API_KEY"); key != "" {
+		return key, nil
+	}
+
+	bts, err := ioutil.ReadFile(configPath)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	var config struct {
+		APIKey string `json:"api_key"`
+	}
+	err = json.Unmarshal(bts, &config)
+	return config.APIKey, err
+}
+
+// apiKeyTransport sends the API key along with every request
+type apiKeyTransport struct {
+	apiKey string
+	base   http.RoundTripper
+}
+
+func (t *apiKeyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.apiKey == "" {
+		return t.base.RoundTrip(req)
+	}
+
+	// A RoundTripper must not change the request it's given
+	req = req.Clone(req.Context())
+	req.Header.Set("X-API-Key", t.apiKey)
+	return t.base.RoundTrip(req)
+}
@@ -0,0 +1,55 @@
+package client
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadAPIKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kode")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	p := filepath.Join(dir, "config.json")
+	os.Setenv("KODE_API_KEY", "")
+
+	if key, err := LoadAPIKey(p); err != nil || key != "" {
+		t.Fatalf("Expected no API key without the config, got %q - %v", key, err)
+	}
+
+	ioutil.WriteFile(p, []byte(`{"api_key": "abc.secret"}`), 0600)
+	if key, err := LoadAPIKey(p); err != nil || key != "abc.secret" {
+		t.Fatalf("Expected the API key of the config, got %q - %v", key, err)
+	}
+
+	os.Setenv("KODE_API_KEY", "def.secret")
+	defer os.Unsetenv("KODE_API_KEY")
+	if key, _ := LoadAPIKey(p); key != "def.secret" {
+		t.Fatalf("Expected the API key of the environment, got %q", key)
+	}
+}
+
+func TestHTTPClientAPIKey(t *testing.T) {
+	var given string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		given = r.Header.Get("X-API-Key")
+	}))
+	defer ts.Close()
+
+	httpClient := NewHTTPClient(5, 5, "abc.secret")
+	resp, err := httpClient.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if given != "abc.secret" {
+		t.Fatalf("Expected the API key to be sent, got %q", given)
+	}
+}
@@ -0,0 +1,45 @@
+package client
+
+// Diagnostic is a message of the compiler about a position in the code
+type Diagnostic struct {
+	File     string `json:"file"`
+	Line     int    `json:"line"`
+	Column   int    `json:"column,omitempty"`
+	Severity string `json:"severity"`
+	Code     string `json:"code,omitempty"`
+	Message  string `json:"message"`
+}
+
+// CheckResult tells whether the code compiles and the diagnostics of the
+// compiler
+type CheckResult struct {
+	OK          bool         `json:"ok"`
+	Diagnostics []Diagnostic `json:"diagnostics"`
+	Output      string       `json:"output"`
+}
+
+// checkRequest is the JSON body to check the code
+type checkRequest struct {
+	Lang    string            `json:"lang"`
+	Version string            `json:"version,omitempty"`
+	Source  string            `json:"source,omitempty"`
+	Files   map[string]string `json:"files,omitempty"`
+	Entry   string            `json:"entry,omitempty"`
+}
+
+// Check only compiles the code and returns the diagnostics
+func (r *Runner) Check() (*CheckResult, error) {
+	req := checkRequest{
+		Lang:    r.lang,
+		Version: r.version,
+		Source:  r.source,
+		Files:   r.files,
+		Entry:   r.entry,
+	}
+
+	result := &CheckResult{}
+	if err := r.postJSON("/api/v2/check", req, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
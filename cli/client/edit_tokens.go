@@ -0,0 +1,52 @@
+package client
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// EditTokens are the edit tokens of the snippets shared by the user, by the
+// URLs of the snippets
+type EditTokens map[string]string
+
+// DefaultEditTokensPath is where the edit tokens are kept, given by
+// KODE_EDIT_TOKENS or ~/.kode/edit_tokens.json
+func DefaultEditTokensPath() string {
+	if p := os.Getenv("KODE_EDIT_TOKENS"); p != "" {
+		return p
+	}
+	return filepath.Join(os.Getenv("HOME"), ".kode", "edit_tokens.json")
+}
+
+// LoadEditTokens reads the edit tokens, there are none if the file doesn't
+// exist yet
+func LoadEditTokens(p string) (EditTokens, error) {
+	tokens := EditTokens{}
+
+	bts, err := ioutil.ReadFile(p)
+	if os.IsNotExist(err) {
+		return tokens, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(bts, &tokens)
+	return tokens, err
+}
+
+// Save writes the edit tokens so only the user can read them
+func (t EditTokens) Save(p string) error {
+	if err := os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+		return err
+	}
+
+	bts, err := json.MarshalIndent(t, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(p, bts, 0600)
+}
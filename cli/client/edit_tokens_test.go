@@ -0,0 +1,33 @@
+package client
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEditTokens(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kode")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	p := filepath.Join(dir, "kode", "edit_tokens.json")
+
+	tokens, err := LoadEditTokens(p)
+	if err != nil || len(tokens) != 0 {
+		t.Fatalf("Expected no edit tokens yet, got %v - %v", tokens, err)
+	}
+
+	tokens["https://koderunr.tech#abc"] = "secret"
+	if err := tokens.Save(p); err != nil {
+		t.Fatal(err)
+	}
+
+	tokens, err = LoadEditTokens(p)
+	if err != nil || tokens["https://koderunr.tech#abc"] != "secret" {
+		t.Fatalf("Expected the saved edit token, got %v - %v", tokens, err)
+	}
+}
@@ -7,8 +7,8 @@ import (
 )
 
 // NewHTTPClient creates a http client that takes dial and read timeout
-// into account
-func NewHTTPClient(openTime, readTime int) http.Client {
+// into account, and sends the API key if there is one
+func NewHTTPClient(openTime, readTime int, apiKey string) http.Client {
 
 	transport := http.Transport{
 		Dial: func(network, addr string) (net.Conn, error) {
@@ -17,7 +17,7 @@ func NewHTTPClient(openTime, readTime int) http.Client {
 	}
 
 	return http.Client{
-		Transport: &transport,
+		Transport: &apiKeyTransport{apiKey: apiKey, base: &transport},
 	}
 }
 
@@ -0,0 +1,119 @@
+package client
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// JudgeCase is an input and the output it is expected to produce
+type JudgeCase struct {
+	Name     string `json:"name"`
+	Stdin    string `json:"stdin"`
+	Expected string `json:"expected"`
+}
+
+// CaseResult is the verdict of a single case
+type CaseResult struct {
+	Name     string `json:"name"`
+	Verdict  string `json:"verdict"`
+	ExitCode int    `json:"exit_code"`
+	WallTime int64  `json:"wall_time_ms"`
+	RunTime  int64  `json:"run_time_ms"`
+	Stdout   string `json:"stdout"`
+	Stderr   string `json:"stderr"`
+}
+
+// JudgeReport is the verdicts of all the cases
+type JudgeReport struct {
+	Verdict string       `json:"verdict"`
+	Passed  int          `json:"passed"`
+	Total   int          `json:"total"`
+	Cases   []CaseResult `json:"cases"`
+}
+
+// VerdictAccepted is the verdict of a case whose output is the expected one
+const VerdictAccepted = "AC"
+
+// judgeRequest is the JSON body to judge the code
+type judgeRequest struct {
+	Lang       string            `json:"lang"`
+	Version    string            `json:"version,omitempty"`
+	Source     string            `json:"source,omitempty"`
+	Files      map[string]string `json:"files,omitempty"`
+	Entry      string            `json:"entry,omitempty"`
+	Args       []string          `json:"args,omitempty"`
+	Env        map[string]string `json:"env,omitempty"`
+	Cases      []JudgeCase       `json:"cases"`
+	Comparator string            `json:"comparator,omitempty"`
+	Tolerance  float64           `json:"tolerance,omitempty"`
+}
+
+// ReadCases reads the cases of a directory, where every NAME.in file is the
+// input of a case and NAME.out is its expected output.
+func ReadCases(dir string) ([]JudgeCase, error) {
+	inputs, err := filepath.Glob(filepath.Join(dir, "*.in"))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(inputs) == 0 {
+		return nil, fmt.Errorf("no *.in file is found in %s", dir)
+	}
+	sort.Strings(inputs)
+
+	cases := make([]JudgeCase, 0, len(inputs))
+	for _, input := range inputs {
+		name := strings.TrimSuffix(input, ".in")
+
+		stdin, err := ioutil.ReadFile(input)
+		if err != nil {
+			return nil, err
+		}
+
+		expected, err := ioutil.ReadFile(name + ".out")
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("the expected output %s.out of %s is missing", name, input)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		cases = append(cases, JudgeCase{
+			Name:     filepath.Base(name),
+			Stdin:    string(stdin),
+			Expected: string(expected),
+		})
+	}
+
+	return cases, nil
+}
+
+// Judge runs the code once per case, and compares the output with the
+// expected one by the comparator (exact, whitespace or float)
+func (r *Runner) Judge(cases []JudgeCase, comparator string, tolerance float64) (*JudgeReport, error) {
+	req := judgeRequest{
+		Lang:       r.lang,
+		Version:    r.version,
+		Source:     r.source,
+		Files:      r.files,
+		Entry:      r.entry,
+		Args:       r.args,
+		Env:        r.env,
+		Cases:      cases,
+		Comparator: comparator,
+		Tolerance:  tolerance,
+	}
+
+	// The cases are run one by one, each of them can take up to 15 seconds
+	r.httpClient = NewHTTPClient(60, 60+15*len(cases), r.apiKey)
+
+	report := &JudgeReport{}
+	if err := r.postJSON("/api/v2/judge", req, report); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
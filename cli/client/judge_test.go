@@ -0,0 +1,42 @@
+package client
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadCases(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kode-cases")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"2.in":  "3 4\n",
+		"2.out": "7\n",
+		"1.in":  "1 2\n",
+		"1.out": "3\n",
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cases, err := ReadCases(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(cases) != 2 || cases[0].Name != "1" || cases[0].Stdin != "1 2\n" || cases[1].Expected != "7\n" {
+		t.Fatalf("Unexpected cases %+v", cases)
+	}
+
+	os.Remove(filepath.Join(dir, "2.out"))
+	if _, err := ReadCases(dir); err == nil {
+		t.Fatal("A case without the expected output should not be read")
+	}
+}
@@ -0,0 +1,55 @@
+package client
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Limits are the resources the program asks for, the zero ones are taken
+// from the profile or the defaults of the language
+type Limits struct {
+	Profile   string `json:"profile,omitempty"`
+	Timeout   int    `json:"timeout,omitempty"`   // In seconds
+	CPUQuota  int64  `json:"cpu_quota,omitempty"` // In microseconds of every 100ms
+	Memory    int64  `json:"memory,omitempty"`    // In bytes
+	PidsLimit int64  `json:"pids_limit,omitempty"`
+}
+
+// ParseMemory parses a size such as 256m, 1g or 1048576 into bytes
+func ParseMemory(size string) (int64, error) {
+	units := map[string]int64{"k": 1 << 10, "m": 1 << 20, "g": 1 << 30}
+
+	s := strings.TrimSuffix(strings.ToLower(size), "b")
+	unit := int64(1)
+	if n := len(s); n > 0 && units[s[n-1:]] != 0 {
+		unit = units[s[n-1:]]
+		s = s[:n-1]
+	}
+
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%q is not a size such as 256m", size)
+	}
+	return n * unit, nil
+}
+
+// addTo adds the limits to the form values
+func (l Limits) addTo(params url.Values) {
+	if l.Profile != "" {
+		params.Set("profile", l.Profile)
+	}
+
+	ints := map[string]int64{
+		"timeout":    int64(l.Timeout),
+		"cpu_quota":  l.CPUQuota,
+		"memory":     l.Memory,
+		"pids_limit": l.PidsLimit,
+	}
+	for name, value := range ints {
+		if value != 0 {
+			params.Set(name, strconv.FormatInt(value, 10))
+		}
+	}
+}
@@ -0,0 +1,25 @@
+package client
+
+import "testing"
+
+func TestParseMemory(t *testing.T) {
+	sizes := map[string]int64{
+		"1048576": 1048576,
+		"256m":    256 * 1024 * 1024,
+		"1G":      1024 * 1024 * 1024,
+		"512kb":   512 * 1024,
+	}
+
+	for size, expected := range sizes {
+		n, err := ParseMemory(size)
+		if err != nil || n != expected {
+			t.Fatalf("Expected %s to be %d bytes, got %d - %v", size, expected, n, err)
+		}
+	}
+
+	for _, size := range []string{"", "m", "-1m", "1t"} {
+		if _, err := ParseMemory(size); err == nil {
+			t.Fatalf("%q should not be a valid size", size)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package client
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// Stream types in the frame header of the multiplexed output
+const (
+	muxStdout  byte = 1
+	muxStderr  byte = 2
+	muxExit    byte = 3
+	muxQueued  byte = 4
+	muxStarted byte = 5
+)
+
+// Result tells how the program has finished on the server
+type Result struct {
+	ExitCode int    `json:"exit_code"`
+	Reason   string `json:"reason"`
+	WallTime int64  `json:"wall_time_ms"`
+	RunTime  int64  `json:"run_time_ms"`
+}
+
+// ReasonExited is the reason of a program that has finished by itself
+const ReasonExited = "exited"
+
+// QueuePosition tells where the run waits for a runner on the server
+type QueuePosition struct {
+	Position      int   `json:"position"`
+	EstimatedWait int64 `json:"estimated_wait_ms"`
+}
+
+// printQueued tells on stderr that the run is waiting for a runner
+func printQueued(stderr io.Writer, q QueuePosition) {
+	wait := (q.EstimatedWait + 999) / 1000
+	fmt.Fprintf(stderr, "Waiting for a runner, %d in the queue, about %ds\n", q.Position, wait)
+}
+
+// demux reads the multiplexed output of a run and copies every frame
+// to the writer of its stream. Where the run waits in the queue is told on
+// stderr. The result is nil if the server has not sent one.
+func demux(r io.Reader, stdout, stderr io.Writer) (*Result, error) {
+	header := make([]byte, 8)
+	var result *Result
+
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			if err == io.EOF {
+				return result, nil
+			}
+			return result, err
+		}
+
+		size := int64(binary.BigEndian.Uint32(header[4:8]))
+
+		var w io.Writer
+		switch header[0] {
+		case muxStdout:
+			w = stdout
+		case muxStderr:
+			w = stderr
+		case muxExit:
+			data := make([]byte, size)
+			if _, err := io.ReadFull(r, data); err != nil {
+				return result, err
+			}
+			result = &Result{}
+			if err := json.Unmarshal(data, result); err != nil {
+				return nil, err
+			}
+			continue
+		case muxQueued:
+			data := make([]byte, size)
+			if _, err := io.ReadFull(r, data); err != nil {
+				return result, err
+			}
+			var q QueuePosition
+			if err := json.Unmarshal(data, &q); err != nil {
+				return result, err
+			}
+			printQueued(stderr, q)
+			continue
+		case muxStarted:
+			if _, err := io.CopyN(ioutil.Discard, r, size); err != nil {
+				return result, err
+			}
+			continue
+		default:
+			return result, fmt.Errorf("unknown stream type %d", header[0])
+		}
+
+		if _, err := io.CopyN(w, r, size); err != nil {
+			return result, err
+		}
+	}
+}
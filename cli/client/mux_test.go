@@ -0,0 +1,59 @@
+package client
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestDemux(t *testing.T) {
+	stream := []byte{
+		1, 0, 0, 0, 0, 0, 0, 3, 'o', 'u', 't',
+		2, 0, 0, 0, 0, 0, 0, 3, 'e', 'r', 'r',
+		1, 0, 0, 0, 0, 0, 0, 1, '\n',
+	}
+	exit := []byte(`{"exit_code":3,"reason":"exited"}`)
+	stream = append(stream, 3, 0, 0, 0, 0, 0, 0, byte(len(exit)))
+	stream = append(stream, exit...)
+
+	var stdout, stderr bytes.Buffer
+	result, err := demux(bytes.NewReader(stream), &stdout, &stderr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result == nil || result.ExitCode != 3 || result.Reason != ReasonExited {
+		t.Fatalf("Unexpected result %+v", result)
+	}
+
+	if stdout.String() != "out\n" {
+		t.Fatalf("Unexpected stdout %q", stdout.String())
+	}
+
+	if stderr.String() != "err" {
+		t.Fatalf("Unexpected stderr %q", stderr.String())
+	}
+}
+
+func TestDemuxQueue(t *testing.T) {
+	queued := []byte(`{"position":2,"estimated_wait_ms":9500}`)
+	started := []byte(`{"waited_ms":9000}`)
+	exit := []byte(`{"exit_code":0,"reason":"exited"}`)
+
+	var stream []byte
+	stream = append(stream, 4, 0, 0, 0, 0, 0, 0, byte(len(queued)))
+	stream = append(stream, queued...)
+	stream = append(stream, 5, 0, 0, 0, 0, 0, 0, byte(len(started)))
+	stream = append(stream, started...)
+	stream = append(stream, 3, 0, 0, 0, 0, 0, 0, byte(len(exit)))
+	stream = append(stream, exit...)
+
+	var stdout, stderr bytes.Buffer
+	result, err := demux(bytes.NewReader(stream), &stdout, &stderr)
+	if err != nil || result == nil {
+		t.Fatalf("Unexpected result %+v - %v", result, err)
+	}
+
+	if stderr.String() != "Waiting for a runner, 2 in the queue, about 10s\n" {
+		t.Fatalf("Unexpected stderr %q", stderr.String())
+	}
+}
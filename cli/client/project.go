@@ -0,0 +1,178 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// projectRequest is the JSON body to register or share a project
+type projectRequest struct {
+	Lang    string            `json:"lang"`
+	Version string            `json:"version,omitempty"`
+	Files   map[string]string `json:"files"`
+	Entry   string            `json:"entry"`
+	Args    []string          `json:"args,omitempty"`
+	Env     map[string]string `json:"env,omitempty"`
+	Stdin   *string           `json:"stdin,omitempty"`
+	Limits  *Limits           `json:"limits,omitempty"`
+}
+
+// apiError is the error object returned by the v2 API
+type apiError struct {
+	Error struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// newProjectRunner creates a runner for a directory. The language is told
+// by the entry file, which is main.* if it's not given.
+func newProjectRunner(dir, entry string) (*Runner, error) {
+	files, err := readProject(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	if entry == "" {
+		entry = findEntry(files)
+		if entry == "" {
+			return nil, fmt.Errorf("cannot find the main file in %s, please give the entry file", dir)
+		}
+	}
+
+	if _, ok := files[entry]; !ok {
+		return nil, fmt.Errorf("%s is not in %s", entry, dir)
+	}
+
+	ext := path.Ext(entry)
+	lang := extToLang[ext]
+	if lang == "" {
+		return nil, fmt.Errorf("%s extension is not supported", ext)
+	}
+
+	return &Runner{
+		lang:  lang,
+		files: files,
+		entry: entry,
+	}, nil
+}
+
+// readProject reads every file in the directory by its slash separated
+// path, hidden files and directories are skipped.
+func readProject(dir string) (map[string]string, error) {
+	files := map[string]string{}
+
+	err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if p != dir && strings.HasPrefix(info.Name(), ".") {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if info.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+
+		content, err := ioutil.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		files[filepath.ToSlash(rel)] = string(content)
+		return nil
+	})
+
+	return files, err
+}
+
+func findEntry(files map[string]string) string {
+	for ext := range extToLang {
+		if _, ok := files["main"+ext]; ok {
+			return "main" + ext
+		}
+	}
+	return ""
+}
+
+func (r *Runner) isProject() bool {
+	return len(r.files) > 0
+}
+
+// postProject sends the project to the v2 API and returns the ID of the
+// created resource
+func (r *Runner) postProject(resource string) (string, error) {
+	req := projectRequest{
+		Lang:    r.lang,
+		Version: r.version,
+		Files:   r.files,
+		Entry:   r.entry,
+		Args:    r.args,
+		Env:     r.env,
+	}
+	if resource == "runs" {
+		req.Stdin = r.stdin
+		req.Limits = &r.limits
+	}
+
+	var created struct {
+		ID string `json:"id"`
+	}
+
+	err := r.postJSON("/api/v2/"+resource, req, &created)
+	return created.ID, err
+}
+
+// postJSON sends the body as JSON and decodes the response into out, the
+// error object is turned into an error
+func (r *Runner) postJSON(urlPath string, body, out interface{}) error {
+	return r.sendJSON(http.MethodPost, urlPath, nil, body, out)
+}
+
+// sendJSON is postJSON with any method and the extra headers of the request
+func (r *Runner) sendJSON(method, urlPath string, header http.Header, body, out interface{}) error {
+	bts, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(method, r.endpoint+urlPath, bytes.NewReader(bts))
+	if err != nil {
+		return err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := r.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		var apiErr apiError
+		if err := json.NewDecoder(resp.Body).Decode(&apiErr); err != nil {
+			return fmt.Errorf("the server responded with %s", resp.Status)
+		}
+		return fmt.Errorf("%s", apiErr.Error.Message)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -9,15 +9,37 @@ import (
 	"net/url"
 	"os"
 	"path"
+	"strconv"
+	"strings"
 )
 
+// Options tells how the code is going to be run
+type Options struct {
+	Version   string            // Version of the language
+	Entry     string            // Entry file of a project directory
+	Args      []string          // Arguments of the program
+	Env       map[string]string // Environment variables of the program
+	WebSocket bool              // Carry stdin and output over a WebSocket
+	Stdin     *string           // Whole stdin of the program, interactive if nil
+	Limits    Limits            // Resources the program asks for
+	APIKey    string            // Key the runs are counted against
+}
+
 // Runner contains the code to be run
 type Runner struct {
 	lang       string
 	source     string
+	files      map[string]string // files of a project, source is not used then
+	entry      string
 	version    string
+	args       []string
+	env        map[string]string
+	stdin      *string
+	limits     Limits
 	uuid       string
+	apiKey     string
 	endpoint   string
+	websocket  bool
 	httpClient http.Client
 }
 
@@ -28,42 +50,71 @@ var extToLang = map[string]string{
 	".c":     "c",
 	".cc":    "c",
 	".go":    "go",
+	".cs":    "dotnet",
+	".fs":    "fsharp",
+}
+
+// NewRunner create a new runner for a file, or for a project if fName
+// is a directory
+func NewRunner(fName, endpoint string, opts Options) (r *Runner, err error) {
+	if info, statErr := os.Stat(fName); statErr == nil && info.IsDir() {
+		r, err = newProjectRunner(fName, opts.Entry)
+	} else {
+		r, err = newFileRunner(fName)
+	}
+	if err != nil {
+		return
+	}
+
+	r.version = opts.Version
+	r.args = opts.Args
+	r.env = opts.Env
+	r.stdin = opts.Stdin
+	r.limits = opts.Limits
+	r.websocket = opts.WebSocket
+	r.endpoint = endpoint
+	r.apiKey = opts.APIKey
+	// The output is read until the program finishes
+	r.httpClient = NewHTTPClient(60, 60+opts.Limits.Timeout, r.apiKey)
+
+	return
 }
 
-// NewRunner create a new runner
-func NewRunner(version, fName, endpoint string) (r *Runner, err error) {
+func newFileRunner(fName string) (*Runner, error) {
 	ext := path.Ext(fName)
 	lang := extToLang[ext]
 
 	if lang == "" {
-		err = fmt.Errorf("%s extension is not supported", ext)
-		return
+		return nil, fmt.Errorf("%s extension is not supported", ext)
 	}
 
 	ctx, err := ioutil.ReadFile(fName)
 	if err != nil {
-		return
-	}
-
-	client := NewHTTPClient(60, 60)
-
-	r = &Runner{
-		lang:       lang,
-		source:     string(ctx),
-		version:    version,
-		endpoint:   endpoint,
-		httpClient: client,
+		return nil, err
 	}
 
-	return
+	return &Runner{lang: lang, source: string(ctx)}, nil
 }
 
 // FetchUUID fetch the UUID from the API endpoint
-func (r *Runner) FetchUUID() error {
+func (r *Runner) FetchUUID() (err error) {
+	if r.isProject() {
+		r.uuid, err = r.postProject("runs")
+		return
+	}
+
 	params := url.Values{"lang": {r.lang}, "source": {string(r.source)}}
 	if r.version != "" {
 		params["version"] = []string{r.version}
 	}
+	params["args"] = r.args
+	for name, value := range r.env {
+		params.Add("env", name+"="+value)
+	}
+	if r.stdin != nil {
+		params["stdin"] = []string{*r.stdin}
+	}
+	r.limits.addTo(params)
 
 	resp, err := r.httpClient.PostForm(r.endpoint+"/api/register/", params)
 	if err != nil {
@@ -77,51 +128,135 @@ func (r *Runner) FetchUUID() error {
 		return err
 	}
 
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s", strings.TrimSpace(string(body)))
+	}
+
 	r.uuid = string(body)
 	return nil
 }
 
-// Share the code
-func (r *Runner) Share() (string, error) {
+// Shared is the snippet the code is shared as
+type Shared struct {
+	ID        string
+	URL       string
+	EditToken string // Only given when a new snippet is created
+	Revision  int
+}
+
+// Share the code as a new snippet, or as a new revision of the snippet
+// codeID with its edit token. With fork, the code is shared as a new snippet
+// when the edit token is not the one of codeID.
+func (r *Runner) Share(codeID, editToken string, fork bool) (*Shared, error) {
+	if r.isProject() {
+		return r.shareProject(codeID, editToken, fork)
+	}
+
 	params := url.Values{"lang": {r.lang}, "source": {string(r.source)}}
 	if r.version != "" {
 		params["version"] = []string{r.version}
 	}
+	if codeID != "" {
+		params["codeID"] = []string{codeID}
+		params["editToken"] = []string{editToken}
+		params["fork"] = []string{fmt.Sprint(fork)}
+	}
 
 	resp, err := r.httpClient.PostForm(r.endpoint+"/api/save/", params)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	defer resp.Body.Close()
 
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s", strings.TrimSpace(string(body)))
 	}
 
-	shareURL := fmt.Sprintf("%s#%s", r.endpoint, string(body))
-	return shareURL, nil
+	shared := r.shared(string(body), resp.Header.Get("X-Edit-Token"))
+	shared.Revision, _ = strconv.Atoi(resp.Header.Get("X-Revision"))
+	return shared, nil
 }
 
-// Run execute the runner
-func (r *Runner) Run() error {
-	go r.fetchStdin()
+func (r *Runner) shareProject(codeID, editToken string, fork bool) (*Shared, error) {
+	req := projectRequest{
+		Lang:    r.lang,
+		Version: r.version,
+		Files:   r.files,
+		Entry:   r.entry,
+		Args:    r.args,
+		Env:     r.env,
+	}
+
+	var snippet struct {
+		ID        string `json:"id"`
+		EditToken string `json:"edit_token"`
+		Revision  int    `json:"revision"`
+	}
+
+	var err error
+	if codeID == "" {
+		err = r.postJSON("/api/v2/snippets", req, &snippet)
+	} else {
+		urlPath := "/api/v2/snippets/" + url.PathEscape(codeID)
+		if fork {
+			urlPath += "?fork=true"
+		}
+
+		header := http.Header{"X-Edit-Token": {editToken}}
+		err = r.sendJSON(http.MethodPut, urlPath, header, req, &snippet)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	shared := r.shared(snippet.ID, snippet.EditToken)
+	shared.Revision = snippet.Revision
+	return shared, nil
+}
+
+func (r *Runner) shared(codeID, editToken string) *Shared {
+	return &Shared{
+		ID:        codeID,
+		URL:       fmt.Sprintf("%s#%s", r.endpoint, codeID),
+		EditToken: editToken,
+	}
+}
+
+// Run execute the runner and returns how the program has finished
+func (r *Runner) Run() (*Result, error) {
+	if r.websocket {
+		return r.runWebSocket()
+	}
+
+	if r.stdin == nil {
+		go r.fetchStdin()
+	}
 
 	// TODO: Build the URI in a classy way
-	resp, err := r.httpClient.Get(r.endpoint + "/api/run/?uuid=" + r.uuid)
+	resp, err := r.httpClient.Get(r.endpoint + "/api/run/?mux=true&queue=true&uuid=" + r.uuid)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer resp.Body.Close()
 
-	if _, err := io.Copy(os.Stdout, resp.Body); err != nil {
-		if err != io.EOF {
-			return err
-		}
+	// The run can be refused, e.g. when the quotas of the API key are used up
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return nil, fmt.Errorf("%s", strings.TrimSpace(string(body)))
 	}
 
-	return nil
+	result, err := demux(resp.Body, os.Stdout, os.Stderr)
+	if err == nil && result == nil {
+		err = fmt.Errorf("the server did not tell how the program finished")
+	}
+
+	return result, err
 }
 
 func (r *Runner) fetchStdin() error {
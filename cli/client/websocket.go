@@ -0,0 +1,132 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/signal"
+
+	"golang.org/x/net/websocket"
+)
+
+// wsMessage is a message sent in either direction over the WebSocket
+type wsMessage struct {
+	Type   string          `json:"type"`
+	Data   string          `json:"data,omitempty"`
+	Signal string          `json:"signal,omitempty"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Queue  json.RawMessage `json:"queue,omitempty"`
+}
+
+// runWebSocket executes the runner with stdin, output and interrupts
+// carried over a single WebSocket
+func (r *Runner) runWebSocket() (*Result, error) {
+	socketURL, err := r.socketURL()
+	if err != nil {
+		return nil, err
+	}
+
+	config, err := websocket.NewConfig(socketURL, r.endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if r.apiKey != "" {
+		config.Header.Set("X-API-Key", r.apiKey)
+	}
+
+	ws, err := websocket.DialConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	defer ws.Close()
+
+	if r.stdin == nil {
+		go sendStdin(ws, os.Stdin)
+	}
+	go forwardInterrupts(ws)
+
+	return receiveWebSocket(ws, os.Stdout, os.Stderr)
+}
+
+// receiveWebSocket writes the output of the program out until the server
+// tells how it has finished
+func receiveWebSocket(ws *websocket.Conn, stdout, stderr io.Writer) (*Result, error) {
+	for {
+		var msg wsMessage
+		if err := websocket.JSON.Receive(ws, &msg); err != nil {
+			if err == io.EOF {
+				err = fmt.Errorf("the server did not tell how the program finished")
+			}
+			return nil, err
+		}
+
+		switch msg.Type {
+		case "stdout":
+			fmt.Fprint(stdout, msg.Data)
+		case "stderr":
+			fmt.Fprint(stderr, msg.Data)
+		case "error":
+			fmt.Fprintf(stderr, "Error: %s\n", msg.Data)
+		case "queued":
+			var q QueuePosition
+			if json.Unmarshal(msg.Queue, &q) == nil {
+				printQueued(stderr, q)
+			}
+		case "exit":
+			result := &Result{}
+			err := json.Unmarshal(msg.Result, result)
+			return result, err
+		}
+	}
+}
+
+func (r *Runner) socketURL() (string, error) {
+	u, err := url.Parse(r.endpoint)
+	if err != nil {
+		return "", err
+	}
+
+	if u.Scheme == "https" {
+		u.Scheme = "wss"
+	} else {
+		u.Scheme = "ws"
+	}
+	u.Path = "/api/v2/runs/" + r.uuid + "/ws"
+
+	return u.String(), nil
+}
+
+// sendStdin sends whatever comes from stdin, and closes the stdin of the
+// program once stdin reaches EOF
+func sendStdin(ws *websocket.Conn, stdin io.Reader) {
+	buffer := make([]byte, 1024)
+
+	for {
+		n, err := stdin.Read(buffer)
+		if n > 0 {
+			msg := wsMessage{Type: "stdin", Data: string(buffer[:n])}
+			if websocket.JSON.Send(ws, msg) != nil {
+				return
+			}
+		}
+
+		if err != nil {
+			websocket.JSON.Send(ws, wsMessage{Type: "close_stdin"})
+			return
+		}
+	}
+}
+
+// forwardInterrupts sends Ctrl-C to the program. A second Ctrl-C quits kode.
+func forwardInterrupts(ws *websocket.Conn) {
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+
+	<-interrupts
+	websocket.JSON.Send(ws, wsMessage{Type: "signal", Signal: "SIGINT"})
+
+	<-interrupts
+	os.Exit(130)
+}
@@ -0,0 +1,99 @@
+package client
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/websocket"
+)
+
+// echoServer plays the server of a run which echoes the stdin, and exits once
+// the stdin is closed
+func echoServer(t *testing.T) *httptest.Server {
+	return httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
+		defer ws.Close()
+		websocket.JSON.Send(ws, wsMessage{Type: "queued", Queue: []byte(`{"position":1,"estimated_wait_ms":2000}`)})
+
+		for {
+			var msg wsMessage
+			if err := websocket.JSON.Receive(ws, &msg); err != nil {
+				return
+			}
+
+			switch msg.Type {
+			case "stdin":
+				websocket.JSON.Send(ws, wsMessage{Type: "stdout", Data: msg.Data})
+			case "close_stdin":
+				websocket.JSON.Send(ws, wsMessage{Type: "stderr", Data: "bye"})
+				websocket.JSON.Send(ws, wsMessage{Type: "error", Data: "the program is not running with a TTY"})
+				websocket.JSON.Send(ws, wsMessage{Type: "exit", Result: []byte(`{"exit_code":3,"reason":"exited"}`)})
+				return
+			default:
+				t.Errorf("Unexpected message %+v", msg)
+			}
+		}
+	}))
+}
+
+func TestWebSocket(t *testing.T) {
+	server := echoServer(t)
+	defer server.Close()
+
+	ws, err := websocket.Dial("ws"+strings.TrimPrefix(server.URL, "http"), "", server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+
+	go sendStdin(ws, strings.NewReader("hello\n"))
+
+	var stdout, stderr bytes.Buffer
+	result, err := receiveWebSocket(ws, &stdout, &stderr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.ExitCode != 3 || result.Reason != ReasonExited {
+		t.Fatalf("Unexpected result %+v", result)
+	}
+	if stdout.String() != "hello\n" {
+		t.Fatalf("Unexpected stdout %q", stdout.String())
+	}
+	expected := "Waiting for a runner, 1 in the queue, about 2s\nbyeError: the program is not running with a TTY\n"
+	if stderr.String() != expected {
+		t.Fatalf("Unexpected stderr %q", stderr.String())
+	}
+}
+
+func TestWebSocketWithoutExit(t *testing.T) {
+	server := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
+		websocket.JSON.Send(ws, wsMessage{Type: "stdout", Data: "hi"})
+		ws.Close()
+	}))
+	defer server.Close()
+
+	ws, err := websocket.Dial("ws"+strings.TrimPrefix(server.URL, "http"), "", server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+
+	var stdout, stderr bytes.Buffer
+	if _, err := receiveWebSocket(ws, &stdout, &stderr); err == nil {
+		t.Fatal("Expected an error when the server doesn't tell how the program finished")
+	}
+}
+
+func TestSocketURL(t *testing.T) {
+	r := &Runner{endpoint: "https://koderunr.tech", uuid: "abc"}
+	if u, _ := r.socketURL(); u != "wss://koderunr.tech/api/v2/runs/abc/ws" {
+		t.Fatalf("Unexpected URL %s", u)
+	}
+
+	r.endpoint = "http://localhost:8080"
+	if u, _ := r.socketURL(); u != "ws://localhost:8080/api/v2/runs/abc/ws" {
+		t.Fatalf("Unexpected URL %s", u)
+	}
+}
@@ -0,0 +1,104 @@
+package commands
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/jaxi/koderunr/cli/client"
+)
+
+// Check is the command to compile the code without running it
+type Check struct{}
+
+// Help shows how to use the check command
+func (c Check) Help() string {
+	helpText := `
+Usage: kode check [filename|directory] [options]
+
+  Compile the code without running it, and print the diagnostics of the
+  compiler as file:line:column: severity: message, which can be read by
+  most editors. Only compiled languages (c, go, swift, dotnet and fsharp)
+  can be checked.
+
+options:
+
+  -json Print the diagnostics as JSON
+
+  -version=<version> Version of the programming language you want to use
+
+  -endpoint=<url> The endpoint that you want the code to be checked on
+
+  -entry=<file> The entry file of the project directory, main.* by default
+
+The exit status of kode is 0 when the code compiles, or 1 otherwise.
+
+Examples:
+
+  $ kode check main.go
+  $ kode check -json myproject/
+`
+	return strings.TrimSpace(helpText)
+}
+
+// ShortDescription for the check command
+func (c Check) ShortDescription() string {
+	return "kode check [filename] [options] - Compile the code without running it and print the diagnostics"
+}
+
+// Exec checks the code and prints the diagnostics
+func (c Check) Exec(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "Error: the file to check is not given")
+		return 1
+	}
+
+	checkFlagSet := flag.NewFlagSet("check", flag.ExitOnError)
+	jsonFlag := checkFlagSet.Bool("json", false, "Print the diagnostics as JSON")
+	endpointFlag := checkFlagSet.String("endpoint", Endpoint, "Endpoint of the API")
+	langVersionFlag := checkFlagSet.String("version", "", "Version of the language")
+	entryFlag := checkFlagSet.String("entry", "", "Entry file of the project directory")
+	checkFlagSet.Parse(args[1:])
+
+	apiKey, err := loadAPIKey()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: Failed to read the API key - %v\n", err)
+		return 1
+	}
+
+	runner, err := client.NewRunner(args[0], *endpointFlag, client.Options{
+		Version: *langVersionFlag,
+		Entry:   *entryFlag,
+		APIKey:  apiKey,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	result, err := runner.Check()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: Failed to check the code - %v\n", err)
+		return 1
+	}
+
+	if *jsonFlag {
+		json.NewEncoder(os.Stdout).Encode(result.Diagnostics)
+	} else {
+		for _, d := range result.Diagnostics {
+			fmt.Printf("%s:%d:%d: %s: %s\n", d.File, d.Line, d.Column, d.Severity, d.Message)
+		}
+
+		// Whatever the compiler said is shown when it cannot be parsed
+		if !result.OK && len(result.Diagnostics) == 0 {
+			fmt.Fprint(os.Stderr, result.Output)
+		}
+	}
+
+	if !result.OK {
+		return 1
+	}
+	return 0
+}
@@ -1,6 +1,10 @@
 package commands
 
-import "fmt"
+import (
+	"fmt"
+
+	"github.com/jaxi/koderunr/cli/client"
+)
 
 // Endpoint is the URL of the service
 var Endpoint string
@@ -12,6 +16,11 @@ type Command interface {
 	ShortDescription() string
 }
 
+// loadAPIKey reads the API key sent along with the requests, if any
+func loadAPIKey() (string, error) {
+	return client.LoadAPIKey(client.DefaultConfigPath())
+}
+
 // CLI is a list of command
 type CLI struct {
 	Cmds    map[string]Command
@@ -62,11 +71,11 @@ func (cli *CLI) GetVersion() {
 	fmt.Printf("%s - %s\n", cli.App, cli.Version)
 }
 
-// Exec execute the command
-func (cli *CLI) Exec(args []string) {
+// Exec execute the command and returns the exit status
+func (cli *CLI) Exec(args []string) int {
 	if len(args) == 0 {
 		cli.Brief()
-		return
+		return 0
 	}
 
 	cmdName := args[0]
@@ -84,17 +93,19 @@ func (cli *CLI) Exec(args []string) {
 			cli.Brief()
 		}
 	default:
-		cli.RunCmd(cmdName, args[1:])
+		return cli.RunCmd(cmdName, args[1:])
 	}
+
+	return 0
 }
 
-// RunCmd execute the given command
-func (cli *CLI) RunCmd(cmdName string, args []string) {
+// RunCmd execute the given command and returns its exit status
+func (cli *CLI) RunCmd(cmdName string, args []string) int {
 	cmd := cli.Cmds[cmdName]
 	if cmd == nil {
 		cli.Brief()
-		return
+		return 1
 	}
 
-	cmd.Exec(args)
+	return cmd.Exec(args)
 }
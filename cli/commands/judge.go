@@ -0,0 +1,122 @@
+package commands
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/jaxi/koderunr/cli/client"
+)
+
+// Judge is the command to run code against test cases
+type Judge struct{}
+
+// Help shows how to use the judge command
+func (j Judge) Help() string {
+	helpText := `
+Usage: kode judge [filename|directory] -cases=<dir> [options]
+
+  Run the code once per test case with the input of the case, and tell
+  whether its output is the expected one.
+
+cases:
+
+	The directory of the test cases, where every NAME.in file is the input
+	of a case and NAME.out is the output it is expected to produce
+
+options:
+
+  -comparator=<exact|whitespace|float> How the output is compared with the
+      expected output, exact by default. whitespace ignores the amount of
+      whitespace between the tokens, float also allows numbers to differ
+      within the tolerance
+
+  -tolerance=<number> Absolute or relative tolerance of the float comparator
+
+  -version=<version> Version of the programming language you want to use
+
+  -endpoint=<url> The endpoint that you want the code to be run on
+
+  -entry=<file> The entry file of the project directory, main.* by default
+
+The verdict of a case is one of AC (accepted), WA (wrong answer), TLE (time
+limit exceeded), MLE (memory limit exceeded), OLE (output limit exceeded) and
+RE (runtime error). The exit status of kode is 0 when all the cases are
+accepted, or 1 otherwise.
+
+Examples:
+
+  $ kode judge prog.c -cases=tests/
+  $ kode judge area.py -cases=tests/ -comparator=float -tolerance=0.001
+`
+	return strings.TrimSpace(helpText)
+}
+
+// ShortDescription for the judge command
+func (j Judge) ShortDescription() string {
+	return "kode judge [filename] -cases=<dir> - Run the code against the test cases and give the verdicts"
+}
+
+// Exec runs the code against the cases and prints the verdicts
+func (j Judge) Exec(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "Error: the file to judge is not given")
+		return 1
+	}
+
+	judgeFlagSet := flag.NewFlagSet("judge", flag.ExitOnError)
+	casesFlag := judgeFlagSet.String("cases", "", "Directory of the test cases")
+	comparatorFlag := judgeFlagSet.String("comparator", "", "How the output is compared")
+	toleranceFlag := judgeFlagSet.Float64("tolerance", 0, "Tolerance of the float comparator")
+	endpointFlag := judgeFlagSet.String("endpoint", Endpoint, "Endpoint of the API")
+	langVersionFlag := judgeFlagSet.String("version", "", "Version of the language")
+	entryFlag := judgeFlagSet.String("entry", "", "Entry file of the project directory")
+	judgeFlagSet.Parse(args[1:])
+
+	if *casesFlag == "" {
+		fmt.Fprintln(os.Stderr, "Error: the directory of the test cases is not given")
+		return 1
+	}
+
+	cases, err := client.ReadCases(*casesFlag)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	apiKey, err := loadAPIKey()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: Failed to read the API key - %v\n", err)
+		return 1
+	}
+
+	runner, err := client.NewRunner(args[0], *endpointFlag, client.Options{
+		Version: *langVersionFlag,
+		Entry:   *entryFlag,
+		APIKey:  apiKey,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	report, err := runner.Judge(cases, *comparatorFlag, *toleranceFlag)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: Failed to judge the code - %v\n", err)
+		return 1
+	}
+
+	for _, c := range report.Cases {
+		fmt.Printf("%-20s %-4s %6dms\n", c.Name, c.Verdict, c.WallTime)
+		if c.Verdict != client.VerdictAccepted && c.Stderr != "" {
+			fmt.Fprint(os.Stderr, c.Stderr)
+		}
+	}
+	fmt.Printf("\n%d/%d passed - %s\n", report.Passed, report.Total, report.Verdict)
+
+	if report.Verdict != client.VerdictAccepted {
+		return 1
+	}
+	return 0
+}
@@ -50,8 +50,14 @@ func (l Langs) Exec(args []string) int {
 
 	langsFlagSet.Parse(flagargs)
 
+	apiKey, err := loadAPIKey()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: Failed to read the API key - %v\n", err)
+		return 1
+	}
+
 	// TODO: Build the URI in a classy way
-	httpClient := client.NewHTTPClient(60, 60)
+	httpClient := client.NewHTTPClient(60, 60, apiKey)
 	resp, err := httpClient.Get(*endpointFlag + "/langs/")
 
 	if err != nil {
@@ -68,7 +74,7 @@ func (l Langs) Exec(args []string) int {
 		return 1
 	}
 
-	fmt.Fprintf(os.Stdout, string(body))
+	fmt.Fprint(os.Stdout, string(body))
 
 	return 0
 }
@@ -3,6 +3,7 @@ package commands
 import (
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"strings"
 
@@ -16,7 +17,7 @@ type Run struct {
 // Help shows how to use a certain commnad
 func (r Run) Help() string {
 	helpText := `
-Usage: kode run [filename] [options]
+Usage: kode run [filename|directory] [options] [-- arguments]
 
   Auto detect the programming language of the file and run it remoted.
   The result will be displayed onto the terminal asynchronously.
@@ -25,16 +26,47 @@ filename:
 
 	The file that contains the source code you want to run
 
+directory:
+
+	The directory of a project with several files, all of them are copied
+	over and the entry file is run
+
 options:
 
   -version=<version> Version of the programming language you want to use
 
   -endpoint=<url> The endpoint that you want the code to be run on
 
+  -entry=<file> The entry file of the project directory, main.* by default
+
+  -env=KEY=VAL Environment variable of the program, can be given many times
+
+  -ws Carry stdin and output over a WebSocket, which keeps keystrokes in
+      order and forwards Ctrl-C to the program
+
+  -profile=<small|medium|large> Named limits of the program
+
+  -timeout=<seconds> -memory=<size> -cpu-quota=<microseconds> -pids=<number>
+      Limits of the program overriding the profile, e.g. -memory=256m. The
+      server refuses limits larger than the language allows
+
+Whatever comes after -- is passed to the program as its arguments.
+
+When stdin is a pipe or a file rather than a terminal, it's read as a whole
+and sent along with the code, and the program gets EOF after it.
+
+The exit status of kode is the exit status of the program, or 1 when the
+program is terminated by the server (e.g. on timeout).
+
 Examples:
 
   $ kode run main.go
   $ kode run -version=2.3.0 foo.rb
+  $ kode run -ws foo.py
+  $ kode run -entry=app.py myproject/
+  $ kode run grep.rb -env=COLOR=1 -- -n pattern
+  $ kode run sum.py < numbers.txt
+  $ kode run -timeout=60 -memory=256m train.py
 `
 	return strings.TrimSpace(helpText)
 }
@@ -44,23 +76,108 @@ func (r Run) ShortDescription() string {
 	return "kode run [filename] [options] - Run the code remotely on runner and returns the result asynchronously"
 }
 
-func createRunnerFromArgs(args []string) (*client.Runner, error) {
+// envFlag collects the repeated -env KEY=VAL flags
+type envFlag map[string]string
+
+func (e envFlag) String() string {
+	pairs := make([]string, 0, len(e))
+	for name, value := range e {
+		pairs = append(pairs, name+"="+value)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (e envFlag) Set(pair string) error {
+	idx := strings.Index(pair, "=")
+	if idx <= 0 {
+		return fmt.Errorf("%q is not in the form of KEY=VAL", pair)
+	}
+	e[pair[:idx]] = pair[idx+1:]
+	return nil
+}
+
+// pipedStdin reads the whole stdin if it's a pipe or a file rather than a
+// terminal, so it can be sent along with the code. nil means interactive.
+func pipedStdin() (*string, error) {
+	info, err := os.Stdin.Stat()
+	if err != nil || info.Mode()&os.ModeCharDevice != 0 {
+		return nil, nil
+	}
+
+	bts, err := ioutil.ReadAll(os.Stdin)
+	if err != nil {
+		return nil, err
+	}
+
+	stdin := string(bts)
+	return &stdin, nil
+}
+
+func createRunnerFromArgs(args []string, stdin *string) (*client.Runner, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("the file to run is not given")
+	}
+
 	// Parse the version and endpoint from the arguments passed in
 	flagargs := args[1:]
+	env := envFlag{}
 
 	runFlagSet := flag.NewFlagSet("run", flag.ExitOnError)
 	endpointFlag := runFlagSet.String("endpoint", Endpoint, "Endpoint of the API")
 	langVersionFlag := runFlagSet.String("version", "", "Version of the language")
-
+	websocketFlag := runFlagSet.Bool("ws", false, "Carry stdin and output over a WebSocket")
+	entryFlag := runFlagSet.String("entry", "", "Entry file of the project directory")
+	runFlagSet.Var(env, "env", "Environment variable of the program in the form of KEY=VAL")
+	profileFlag := runFlagSet.String("profile", "", "Named limits of the program, e.g. small or large")
+	timeoutFlag := runFlagSet.Int("timeout", 0, "Timeout of the program in seconds")
+	memoryFlag := runFlagSet.String("memory", "", "Memory of the program, e.g. 256m")
+	cpuQuotaFlag := runFlagSet.Int64("cpu-quota", 0, "CPU of the program in microseconds of every 100ms")
+	pidsFlag := runFlagSet.Int64("pids", 0, "Max number of the processes of the program")
+
+	// Whatever comes after -- goes to the program
 	runFlagSet.Parse(flagargs)
 
-	return client.NewRunner(*langVersionFlag, args[0], *endpointFlag)
+	limits := client.Limits{
+		Profile:   *profileFlag,
+		Timeout:   *timeoutFlag,
+		CPUQuota:  *cpuQuotaFlag,
+		PidsLimit: *pidsFlag,
+	}
+	if *memoryFlag != "" {
+		memory, err := client.ParseMemory(*memoryFlag)
+		if err != nil {
+			return nil, err
+		}
+		limits.Memory = memory
+	}
+
+	apiKey, err := loadAPIKey()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the API key - %v", err)
+	}
+
+	return client.NewRunner(args[0], *endpointFlag, client.Options{
+		Version:   *langVersionFlag,
+		Entry:     *entryFlag,
+		Args:      runFlagSet.Args(),
+		Env:       env,
+		WebSocket: *websocketFlag,
+		Stdin:     stdin,
+		Limits:    limits,
+		APIKey:    apiKey,
+	})
 }
 
 // Exec is the command that will execute the Run command
 func (r Run) Exec(args []string) int {
 	// Started running the code
-	runner, err := createRunnerFromArgs(args)
+	stdin, err := pipedStdin()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: Failed to read stdin - %v\n", err)
+		return 1
+	}
+
+	runner, err := createRunnerFromArgs(args, stdin)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		return 1
@@ -72,11 +189,25 @@ func (r Run) Exec(args []string) int {
 		return 1
 	}
 
-	err = runner.Run()
+	result, err := runner.Run()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error: Failed to execute the code - %v\n", err)
 		return 1
 	}
 
-	return 0
+	return exitStatus(result)
+}
+
+// exitStatus turns the remote result into the exit status of kode, so the
+// exit code of the program can be used in scripts.
+func exitStatus(result *client.Result) int {
+	if result.Reason == client.ReasonExited {
+		return result.ExitCode
+	}
+
+	fmt.Fprintf(os.Stderr, "Error: The program is terminated - %s\n", result.Reason)
+	if result.ExitCode > 0 {
+		return result.ExitCode
+	}
+	return 1
 }
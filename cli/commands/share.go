@@ -1,11 +1,14 @@
 package commands
 
 import (
+	"flag"
 	"fmt"
 	"os"
 	"os/exec"
 	"runtime"
 	"strings"
+
+	"github.com/jaxi/koderunr/cli/client"
 )
 
 // Share is a command that will display the code in the CLI
@@ -14,7 +17,7 @@ type Share struct{}
 // Help give a specific instructions about how to use the share command
 func (s Share) Help() string {
 	text := `
-Usage: kode share [filename]
+Usage: kode share [filename|directory] [options]
 
   Share the code and create a URL (so it can be shown in the browser)
 
@@ -22,10 +25,32 @@ filename:
 
 	The file that contains the source code you want to share
 
+directory:
+
+	The directory of a project, the entry file is given by -entry
+
+options:
+
+  -update=<id> Save the code as a new revision of the snippet you have
+      shared before, with the edit token kept for it
+
+  -fork With -update, share the code as a new snippet if the snippet is not
+      yours
+
+  -version=<version> Version of the programming language you want to use
+
+  -endpoint=<url> The endpoint that you want the code to be shared on
+
+  -entry=<file> The entry file of the project directory, main.* by default
+
+The edit tokens of the snippets you share are kept in
+~/.kode/edit_tokens.json, or the file given by KODE_EDIT_TOKENS.
+
 Examples:
 
   $ kode share main.go
   http://koderunr.tech/#zW0CX1qn02
+  $ kode share main.go -update=zW0CX1qn02
 `
 	return strings.TrimSpace(text)
 }
@@ -37,22 +62,68 @@ func (s Share) ShortDescription() string {
 
 // Exec fetch the share id and compose the uri
 func (s Share) Exec(args []string) int {
-	runner, err := createRunnerFromArgs(args)
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "Error: the file to share is not given")
+		return 1
+	}
+
+	shareFlagSet := flag.NewFlagSet("share", flag.ExitOnError)
+	endpointFlag := shareFlagSet.String("endpoint", Endpoint, "Endpoint of the API")
+	langVersionFlag := shareFlagSet.String("version", "", "Version of the language")
+	entryFlag := shareFlagSet.String("entry", "", "Entry file of the project directory")
+	updateFlag := shareFlagSet.String("update", "", "ID of the snippet to save the code over")
+	forkFlag := shareFlagSet.Bool("fork", false, "Share as a new snippet if the snippet is not yours")
+	shareFlagSet.Parse(args[1:])
+
+	apiKey, err := loadAPIKey()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: Failed to read the API key - %v\n", err)
+		return 1
+	}
+
+	runner, err := client.NewRunner(args[0], *endpointFlag, client.Options{
+		Version: *langVersionFlag,
+		Entry:   *entryFlag,
+		APIKey:  apiKey,
+	})
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		return 1
 	}
 
-	url, err := runner.Share()
+	tokensPath := client.DefaultEditTokensPath()
+	tokens, err := client.LoadEditTokens(tokensPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: Failed to read the edit tokens - %v\n", err)
+		return 1
+	}
+
+	editToken := ""
+	if *updateFlag != "" {
+		editToken = tokens[*endpointFlag+"#"+*updateFlag]
+	}
+
+	shared, err := runner.Share(*updateFlag, editToken, *forkFlag)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error: Failed to share the code - %v\n", err)
 		return 1
 	}
 
+	if shared.EditToken != "" {
+		tokens[shared.URL] = shared.EditToken
+		if err := tokens.Save(tokensPath); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: Failed to keep the edit token, the snippet cannot be updated - %v\n", err)
+		}
+	}
+
+	if shared.Revision > 1 {
+		fmt.Fprintf(os.Stderr, "Saved as revision %d of %s\n", shared.Revision, shared.ID)
+	}
+
 	if isOSDarwin() {
-		exec.Command("open", url).Run()
+		exec.Command("open", shared.URL).Run()
 	} else {
-		fmt.Println(url)
+		fmt.Println(shared.URL)
 	}
 
 	return 0
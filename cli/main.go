@@ -22,8 +22,10 @@ func main() {
 	cli.Cmds = map[string]commands.Command{
 		"run":       commands.Run{},
 		"share":     commands.Share{},
+		"judge":     commands.Judge{},
+		"check":     commands.Check{},
 		"languages": commands.Langs{},
 	}
 
-	cli.Exec(args)
+	os.Exit(cli.Exec(args))
 }
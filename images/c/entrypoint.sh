@@ -1,10 +1,9 @@
 #!/bin/sh
 set -e
 
-source_code=$1
-fname=$2.c
+# The source files are copied into the working directory, $1 is the entry
+# file and the rest are the arguments of the program
+shift
 
-touch $fname
-echo "$source_code" > $fname
-cc $fname
-./a.out
+cc $(find . -name '*.c')
+./a.out "$@"
@@ -1,13 +1,11 @@
 #!/bin/sh
 set -e
 
-source_code=$1
-fname=Runner.cs
-
-touch $fname
-
-echo "$source_code" > $fname
+# The source files are copied into the working directory, $1 is the entry
+# file and the rest are the arguments of the program. project.json compiles
+# every .cs file in it.
+shift
 
 dotnet publish > /dev/null
 
-dotnet bin/Debug/netcoreapp1.0/publish/dotnet.dll
+dotnet bin/Debug/netcoreapp1.0/publish/dotnet.dll "$@"
@@ -1,13 +1,20 @@
 #!/bin/sh
 set -e
 
-source_code=$1
-fname=Runner.fs
+# The source files are copied into the working directory, $1 is the entry
+# file and the rest are the arguments of the program. F# compiles the files
+# in order, so the entry file goes last.
+entry=$1
+shift
 
-touch $fname
-
-echo "$source_code" > $fname
+includes=""
+for f in $(find . -name '*.fs' | sed 's|^\./||'); do
+  if [ "$f" != "$entry" ]; then
+    includes="$includes\"$f\", "
+  fi
+done
+sed -i "s|\"Runner.fs\"|$includes\"$entry\"|" project.json
 
 dotnet publish > /dev/null
 
-dotnet bin/Debug/netcoreapp1.0/publish/fsharp.dll
+dotnet bin/Debug/netcoreapp1.0/publish/fsharp.dll "$@"
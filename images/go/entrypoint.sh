@@ -1,9 +1,10 @@
 #!/bin/sh
 set -e
 
-source_code=$1
-fname=$2.go
+# The source files are copied into the working directory, $1 is the entry
+# file and the rest are the arguments of the program. Packages in sub
+# directories can be imported from $GOPATH/src.
+shift
 
-touch $fname
-echo "$source_code" > $fname
-go run $fname
+go build -o /tmp/main $(find . -maxdepth 1 -name '*.go' ! -name '*_test.go')
+/tmp/main "$@"
@@ -1,9 +1,9 @@
 #!/bin/sh
 set -e
 
-source_code=$1
-fname=$2.rb
+# The source files are copied into the working directory, $1 is the entry
+# file and the rest are the arguments of the program
+entry=$1
+shift
 
-touch $fname
-echo "$source_code" > $fname
-ruby $fname
+ruby "$entry" "$@"
@@ -1,11 +1,10 @@
 #!/bin/bash
 set -e
 
-source_code=$1
-fname=$2.swift
+# The source files are copied into the working directory, $1 is the entry
+# file and the rest are the arguments of the program
+shift
 
-touch $fname
-echo "$source_code" > $fname
-swiftc $fname -o main
+swiftc $(find . -name '*.swift') -o main
 
-./main
+./main "$@"
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+)
+
+// keysUsage tells how to use the keys command
+const keysUsage = `Usage: koderunr [-config=config.json] keys <command>
+
+  create -name=<name> [-concurrent=<n>] [-daily=<n>] [-cpu-seconds=<n>]
+      Create an API key with its quotas, unlimited if 0, and print it
+  list
+      List the API keys with their quotas and usage of today
+  revoke <id>
+      Revoke the API key`
+
+// maxKeyIDAttempts is how many IDs are drawn for a new key before giving up
+const maxKeyIDAttempts = 3
+
+// runKeysCommand manages the API keys in the store and returns the exit status
+func runKeysCommand(store Store, args []string, out io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprintln(out, keysUsage)
+		return 2
+	}
+
+	switch args[0] {
+	case "create":
+		return createKey(store, args[1:], out)
+	case "list":
+		return listKeys(store, out)
+	case "revoke":
+		return revokeKey(store, args[1:], out)
+	}
+
+	fmt.Fprintln(out, keysUsage)
+	return 2
+}
+
+func createKey(store Store, args []string, out io.Writer) int {
+	createFlagSet := flag.NewFlagSet("create", flag.ContinueOnError)
+	createFlagSet.SetOutput(out)
+	nameFlag := createFlagSet.String("name", "", "Who the key is for")
+	concurrentFlag := createFlagSet.Int("concurrent", 0, "Max number of the runs at the same time")
+	dailyFlag := createFlagSet.Int("daily", 0, "Max number of the runs a day")
+	cpuFlag := createFlagSet.Int64("cpu-seconds", 0, "Max CPU seconds of all the runs")
+	if err := createFlagSet.Parse(args); err != nil {
+		return 2
+	}
+
+	if *nameFlag == "" {
+		fmt.Fprintln(out, "The name of the key is not given")
+		return 2
+	}
+
+	// Another ID is drawn should the ID be taken
+	var key *APIKey
+	var given string
+	for i := 0; ; i++ {
+		var err error
+		key, given, err = newAPIKey(*nameFlag)
+		if err != nil {
+			fmt.Fprintf(out, "Cannot create the key - %v\n", err)
+			return 1
+		}
+		key.MaxConcurrentRuns = *concurrentFlag
+		key.MaxRunsPerDay = *dailyFlag
+		key.MaxCPUSeconds = *cpuFlag
+
+		err = store.SaveAPIKey(key)
+		if err == nil {
+			break
+		}
+		if err != ErrAPIKeyExists || i == maxKeyIDAttempts-1 {
+			fmt.Fprintf(out, "Cannot save the key - %v\n", err)
+			return 1
+		}
+	}
+
+	fmt.Fprintf(out, "Created the key %s for %s, it cannot be shown again:\n%s\n", key.ID, key.Name, given)
+	return 0
+}
+
+func listKeys(store Store, out io.Writer) int {
+	keys, err := store.ListAPIKeys()
+	if err != nil {
+		fmt.Fprintf(out, "Cannot list the keys - %v\n", err)
+		return 1
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].CreatedAt.Before(keys[j].CreatedAt) })
+
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tRUNNING\tRUNS TODAY\tCPU SECONDS\tCREATED")
+	for _, key := range keys {
+		usage, err := store.FetchKeyUsage(key.ID, today())
+		if err != nil {
+			fmt.Fprintf(out, "Cannot get the usage of %s - %v\n", key.ID, err)
+			return 1
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", key.ID, key.Name,
+			ofQuota(int64(usage.ConcurrentRuns), int64(key.MaxConcurrentRuns)),
+			ofQuota(int64(usage.RunsToday), int64(key.MaxRunsPerDay)),
+			ofQuota(int64(usage.CPUTime.Seconds()), key.MaxCPUSeconds),
+			key.CreatedAt.Format("2006-01-02"))
+	}
+	w.Flush()
+	return 0
+}
+
+func revokeKey(store Store, args []string, out io.Writer) int {
+	if len(args) != 1 {
+		fmt.Fprintln(out, keysUsage)
+		return 2
+	}
+
+	deleted, err := store.DeleteAPIKey(args[0])
+	if err != nil {
+		fmt.Fprintf(out, "Cannot revoke the key - %v\n", err)
+		return 1
+	}
+	if !deleted {
+		fmt.Fprintf(out, "The key %s doesn't exist\n", args[0])
+		return 1
+	}
+
+	fmt.Fprintf(out, "Revoked the key %s\n", args[0])
+	return 0
+}
+
+// ofQuota shows the usage of a quota, which is unlimited if 0
+func ofQuota(used, max int64) string {
+	if max == 0 {
+		return fmt.Sprintf("%d", used)
+	}
+	return fmt.Sprintf("%d/%d", used, max)
+}
@@ -0,0 +1,512 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// APIError is the error object returned by the v2 API
+type APIError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Error codes of the v2 API
+const (
+	ErrCodeInvalidJSON         = "invalid_json"
+	ErrCodeBodyTooLarge        = "body_too_large"
+	ErrCodeMissingSource       = "missing_source"
+	ErrCodeInvalidProject      = "invalid_project"
+	ErrCodeSourceTooLarge      = "source_too_large"
+	ErrCodeStdinTooLarge       = "stdin_too_large"
+	ErrCodeSnippetTooLarge     = "snippet_too_large"
+	ErrCodePinForbidden        = "pin_forbidden"
+	ErrCodeEditForbidden       = "edit_forbidden"
+	ErrCodeInvalidRevision     = "invalid_revision"
+	ErrCodeAPIKeyRequired      = "api_key_required"
+	ErrCodeInvalidAPIKey       = "invalid_api_key"
+	ErrCodeQuotaExceeded       = "quota_exceeded"
+	ErrCodeRateLimited         = "rate_limited"
+	ErrCodeQueueFull           = "queue_full"
+	ErrCodeInvalidArgs         = "invalid_args"
+	ErrCodeInvalidEnv          = "invalid_env"
+	ErrCodeInvalidCases        = "invalid_cases"
+	ErrCodeInvalidLimits       = "invalid_limits"
+	ErrCodeLimitExceeded       = "limit_exceeded"
+	ErrCodeCheckNotSupported   = "check_not_supported"
+	ErrCodeUnsupportedLanguage = "unsupported_language"
+	ErrCodeNotFound            = "not_found"
+	ErrCodeMethodNotAllowed    = "method_not_allowed"
+	ErrCodeInternal            = "internal_error"
+)
+
+// RunRequest is the JSON body used to register a run or save a snippet.
+// A project is given by Files and Entry instead of Source.
+type RunRequest struct {
+	Lang    string            `json:"lang"`
+	Version string            `json:"version"`
+	Source  string            `json:"source"`
+	Files   map[string]string `json:"files,omitempty"`
+	Entry   string            `json:"entry,omitempty"`
+	Args    []string          `json:"args,omitempty"`
+	Env     map[string]string `json:"env,omitempty"`
+	Stdin   *string           `json:"stdin,omitempty"`
+	Limits  Limits            `json:"limits"`           // Resolved by validateRunRequest
+	Pinned  bool              `json:"pinned,omitempty"` // Only for the snippets
+}
+
+// newRunner creates the runner of the request
+func (req *RunRequest) newRunner() *Runner {
+	runner := &Runner{
+		Lang:    req.Lang,
+		Source:  req.Source,
+		Version: req.Version,
+		Files:   req.Files,
+		Entry:   req.Entry,
+		Args:    req.Args,
+		Env:     req.Env,
+		Stdin:   req.Stdin,
+	}
+	runner.applyLimits(req.Limits)
+
+	return runner
+}
+
+// RunResource is the JSON representation of a registered run
+type RunResource struct {
+	ID        string `json:"id"`
+	Lang      string `json:"lang"`
+	Version   string `json:"version,omitempty"`
+	StreamURL string `json:"stream_url"`
+	SocketURL string `json:"socket_url"`
+}
+
+// SnippetResource is the JSON representation of a saved snippet
+type SnippetResource struct {
+	ID         string            `json:"id"`
+	Lang       string            `json:"lang"`
+	Version    string            `json:"version,omitempty"`
+	Source     string            `json:"source"`
+	Files      map[string]string `json:"files,omitempty"`
+	Entry      string            `json:"entry,omitempty"`
+	Pinned     bool              `json:"pinned"`
+	ExpiresAt  *time.Time        `json:"expires_at,omitempty"`
+	EditToken  string            `json:"edit_token,omitempty"` // Only when the snippet is created
+	Revision   int               `json:"revision"`
+	SavedAt    *time.Time        `json:"saved_at,omitempty"`
+	ForkedFrom *RevisionRef      `json:"forked_from,omitempty"`
+}
+
+func (s *Server) v2RouteMap() map[string]func(w http.ResponseWriter, r *http.Request) {
+	// Both forms are registered so that POST /runs is not redirected by the mux
+	return map[string]func(w http.ResponseWriter, r *http.Request){
+		"runs":      s.HandleRunsV2,
+		"runs/":     s.HandleRunsV2,
+		"snippets":  s.HandleSnippetsV2,
+		"snippets/": s.HandleSnippetsV2,
+		"exec":      s.HandleExecV2,
+		"judge":     s.HandleJudgeV2,
+		"check":     s.HandleCheckV2,
+		"status":    s.HandleStatusV2,
+	}
+}
+
+// HandleRunsV2 serves POST /runs, GET /runs/{id} and the WebSocket of a
+// run at GET /runs/{id}/ws
+func (s *Server) HandleRunsV2(w http.ResponseWriter, r *http.Request) {
+	segments := resourceSegments(r.URL.Path, "runs")
+
+	switch {
+	case len(segments) == 0 && r.Method == http.MethodPost:
+		s.createRunV2(w, r)
+	case len(segments) == 1 && r.Method == http.MethodGet:
+		s.showRunV2(w, segments[0])
+	case len(segments) == 2 && segments[1] == "ws" && r.Method == http.MethodGet:
+		s.serveRunWebSocket(w, r, segments[0])
+	case len(segments) > 1:
+		writeAPIError(w, http.StatusNotFound, ErrCodeNotFound, "The resource doesn't exist")
+	default:
+		writeAPIError(w, http.StatusMethodNotAllowed, ErrCodeMethodNotAllowed, r.Method+" is not allowed here")
+	}
+}
+
+// HandleSnippetsV2 serves POST /snippets and GET/PUT/DELETE /snippets/{id},
+// and the revisions of the snippets. PUT and DELETE need the edit token of the
+// snippet in the X-Edit-Token header, PUT with ?fork=true saves a copy under a
+// new ID without it.
+func (s *Server) HandleSnippetsV2(w http.ResponseWriter, r *http.Request) {
+	segments := resourceSegments(r.URL.Path, "snippets")
+
+	if len(segments) > 1 {
+		s.handleSnippetHistoryV2(w, r, segments)
+		return
+	}
+
+	if len(segments) == 0 {
+		if r.Method != http.MethodPost {
+			writeAPIError(w, http.StatusMethodNotAllowed, ErrCodeMethodNotAllowed, r.Method+" is not allowed here")
+			return
+		}
+		s.putSnippetV2(w, r, "")
+		return
+	}
+
+	codeID := segments[0]
+	switch r.Method {
+	case http.MethodGet:
+		s.showSnippetV2(w, codeID)
+	case http.MethodPut:
+		s.putSnippetV2(w, r, codeID)
+	case http.MethodDelete:
+		s.deleteSnippetV2(w, r, codeID)
+	default:
+		writeAPIError(w, http.StatusMethodNotAllowed, ErrCodeMethodNotAllowed, r.Method+" is not allowed here")
+	}
+}
+
+// StatusResource tells how busy the server is
+type StatusResource struct {
+	Queue QueueStatus  `json:"queue"`
+	Pools []PoolStatus `json:"pools,omitempty"` // Of the warm containers
+}
+
+// HandleStatusV2 serves GET /status
+func (s *Server) HandleStatusV2(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeAPIError(w, http.StatusMethodNotAllowed, ErrCodeMethodNotAllowed, r.Method+" is not allowed here")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, StatusResource{Queue: Runqueue.Status(), Pools: Warmpool.Status()})
+}
+
+func (s *Server) createRunV2(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeRunRequest(w, r)
+	if !ok {
+		return
+	}
+
+	runner := req.newRunner()
+	runner.APIKey = apiKeyID(r)
+
+	uuid, err := s.registerRun(runner)
+	if err != nil {
+		s.logger.Errorf("Cannot register the code: %v", err)
+		writeAPIError(w, http.StatusInternalServerError, ErrCodeInternal, "A serious error has occured.")
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, newRunResource(uuid, runner))
+}
+
+func (s *Server) showRunV2(w http.ResponseWriter, uuid string) {
+	runner, err := s.store.FetchRun(uuid)
+	if err == ErrNotFound {
+		writeAPIError(w, http.StatusNotFound, ErrCodeNotFound, "The run doesn't exist")
+		return
+	}
+	if err != nil {
+		s.logger.Errorf("Cannot get the run %s: %v", uuid, err)
+		writeAPIError(w, http.StatusInternalServerError, ErrCodeInternal, "A serious error has occured.")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, newRunResource(uuid, runner))
+}
+
+func (s *Server) showSnippetV2(w http.ResponseWriter, codeID string) {
+	runner, err := s.store.FetchSnippet(codeID)
+	if err == ErrNotFound {
+		writeAPIError(w, http.StatusNotFound, ErrCodeNotFound, "The snippet doesn't exist")
+		return
+	}
+	if err != nil {
+		s.logger.Errorf("Cannot get code snippet: %v", err)
+		writeAPIError(w, http.StatusInternalServerError, ErrCodeInternal, "A serious error has occured.")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, newSnippetResource(codeID, runner))
+}
+
+func (s *Server) putSnippetV2(w http.ResponseWriter, r *http.Request, codeID string) {
+	req, ok := decodeRunRequest(w, r)
+	if !ok {
+		return
+	}
+
+	runner := req.newRunner()
+	runner.Pinned = req.Pinned
+
+	if err := checkSnippetSize(runner); err != nil {
+		writeAPIError(w, http.StatusRequestEntityTooLarge, ErrCodeSnippetTooLarge, err.Error())
+		return
+	}
+
+	if runner.Pinned && !canPin(r) {
+		writeAPIError(w, http.StatusForbidden, ErrCodePinForbidden, "The snippet cannot be pinned without the pin token")
+		return
+	}
+	keepSnippet(runner)
+
+	claim, err := s.claimSnippet(codeID, r.Header.Get("X-Edit-Token"), r.URL.Query().Get("fork") == "true")
+	if err == ErrEditForbidden {
+		writeAPIError(w, http.StatusForbidden, ErrCodeEditForbidden, err.Error())
+		return
+	}
+
+	if err == nil {
+		err = s.saveSnippet(claim, runner)
+	}
+	if err != nil {
+		s.logger.Errorf("Failed to store code snippet: %v", err)
+		writeAPIError(w, http.StatusInternalServerError, ErrCodeInternal, "A serious error has occured.")
+		return
+	}
+
+	s.writeClaimedSnippet(w, claim, runner)
+}
+
+// writeClaimedSnippet responds with the snippet just saved, and its edit token
+// if it's a new one
+func (s *Server) writeClaimedSnippet(w http.ResponseWriter, claim *snippetClaim, runner *Runner) {
+	snippet := newSnippetResource(claim.ID, runner)
+	status := http.StatusOK
+	if claim.Created {
+		snippet.EditToken = claim.EditToken
+		status = http.StatusCreated
+	}
+	writeJSON(w, status, snippet)
+}
+
+func (s *Server) deleteSnippetV2(w http.ResponseWriter, r *http.Request, codeID string) {
+	err := s.checkEditToken(codeID, r.Header.Get("X-Edit-Token"))
+	if err == ErrNotFound {
+		writeAPIError(w, http.StatusNotFound, ErrCodeNotFound, "The snippet doesn't exist")
+		return
+	}
+	if err == ErrEditForbidden {
+		writeAPIError(w, http.StatusForbidden, ErrCodeEditForbidden, err.Error())
+		return
+	}
+
+	deleted := false
+	if err == nil {
+		deleted, err = s.store.DeleteSnippet(codeID)
+	}
+	if err != nil {
+		s.logger.Errorf("Failed to delete code snippet: %v", err)
+		writeAPIError(w, http.StatusInternalServerError, ErrCodeInternal, "A serious error has occured.")
+		return
+	}
+
+	if !deleted {
+		writeAPIError(w, http.StatusNotFound, ErrCodeNotFound, "The snippet doesn't exist")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func newRunResource(uuid string, runner *Runner) RunResource {
+	return RunResource{
+		ID:        uuid,
+		Lang:      runner.Lang,
+		Version:   runner.Version,
+		StreamURL: "/api/run/?evt=true&uuid=" + uuid,
+		SocketURL: "/api/v2/runs/" + uuid + "/ws",
+	}
+}
+
+func newSnippetResource(codeID string, runner *Runner) SnippetResource {
+	return SnippetResource{
+		ID:         codeID,
+		Lang:       runner.Lang,
+		Version:    runner.Version,
+		Source:     runner.Source,
+		Files:      runner.Files,
+		Entry:      runner.Entry,
+		Pinned:     runner.Pinned,
+		ExpiresAt:  runner.ExpiresAt,
+		Revision:   runner.revisionNumber(),
+		SavedAt:    runner.SavedAt,
+		ForkedFrom: runner.ForkedFrom,
+	}
+}
+
+// decodeRunRequest parses and validates the JSON body, or the multipart form
+// with the project uploaded as an archive. It writes the error response itself
+// and returns false when the request cannot be used.
+func decodeRunRequest(w http.ResponseWriter, r *http.Request) (*RunRequest, bool) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxBodySize(1))
+
+	var req *RunRequest
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		var err error
+		if req, err = decodeProjectUpload(r); isBodyTooLarge(err) {
+			writeBodyTooLarge(w)
+			return nil, false
+		} else if err != nil {
+			writeAPIError(w, http.StatusBadRequest, ErrCodeInvalidProject, err.Error())
+			return nil, false
+		}
+	} else {
+		req = &RunRequest{}
+		if err := json.NewDecoder(r.Body).Decode(req); isBodyTooLarge(err) {
+			writeBodyTooLarge(w)
+			return nil, false
+		} else if err != nil {
+			writeAPIError(w, http.StatusBadRequest, ErrCodeInvalidJSON, "The request body is not valid JSON")
+			return nil, false
+		}
+	}
+
+	if !validateRunRequest(w, req) {
+		return nil, false
+	}
+
+	return req, true
+}
+
+// maxBodySize is the max size of a request body carrying the source code and
+// the stdin of the given number of runs. The JSON encoding is given as much
+// again, and the other fields 64KB.
+func maxBodySize(runs int) int64 {
+	return 2*(appConfig.GetMaxSourceSize()+int64(runs)*appConfig.GetMaxStdinSize()) + 64<<10
+}
+
+// isBodyTooLarge tells whether the body was cut by http.MaxBytesReader
+func isBodyTooLarge(err error) bool {
+	var tooLarge *http.MaxBytesError
+	return errors.As(err, &tooLarge)
+}
+
+func writeBodyTooLarge(w http.ResponseWriter) {
+	writeAPIError(w, http.StatusRequestEntityTooLarge, ErrCodeBodyTooLarge, "The request body is too large")
+}
+
+// validateRunRequest checks the language, source code, stdin, arguments and
+// environment variables of the request. It writes the error response itself
+// and returns false when the request cannot be used.
+func validateRunRequest(w http.ResponseWriter, req *RunRequest) bool {
+	lang, ok := (*appConfig.Languages)[req.Lang]
+	if !ok {
+		writeAPIError(w, http.StatusUnprocessableEntity, ErrCodeUnsupportedLanguage, req.Lang+" is not supported")
+		return false
+	}
+
+	limits, err := lang.ResolveLimits(req.Limits)
+	if _, exceeded := err.(*LimitExceededError); exceeded {
+		writeAPIError(w, http.StatusUnprocessableEntity, ErrCodeLimitExceeded, err.Error())
+		return false
+	}
+	if err != nil {
+		writeAPIError(w, http.StatusUnprocessableEntity, ErrCodeInvalidLimits, err.Error())
+		return false
+	}
+	req.Limits = limits
+
+	if len(req.Files) > 0 {
+		if err := validateProject(req.Files, req.Entry); err != nil {
+			writeAPIError(w, http.StatusUnprocessableEntity, ErrCodeInvalidProject, err.Error())
+			return false
+		}
+		req.Source = ""
+	} else if req.Source == "" {
+		writeAPIError(w, http.StatusUnprocessableEntity, ErrCodeMissingSource, "The source code is empty")
+		return false
+	}
+
+	if err := checkSourceSize(req.newRunner()); err != nil {
+		writeAPIError(w, http.StatusRequestEntityTooLarge, ErrCodeSourceTooLarge, err.Error())
+		return false
+	}
+
+	if err := checkStdinSize(req.newRunner()); err != nil {
+		writeAPIError(w, http.StatusRequestEntityTooLarge, ErrCodeStdinTooLarge, err.Error())
+		return false
+	}
+
+	if err := validateArgs(req.Args); err != nil {
+		writeAPIError(w, http.StatusUnprocessableEntity, ErrCodeInvalidArgs, err.Error())
+		return false
+	}
+
+	if err := validateEnv(req.Env); err != nil {
+		writeAPIError(w, http.StatusUnprocessableEntity, ErrCodeInvalidEnv, err.Error())
+		return false
+	}
+
+	return true
+}
+
+// decodeProjectUpload reads a request whose project is uploaded as the
+// "archive" file of a multipart form
+func decodeProjectUpload(r *http.Request) (*RunRequest, error) {
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		return nil, err
+	}
+
+	file, header, err := r.FormFile("archive")
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	files, err := extractProject(header.Filename, file)
+	if err != nil {
+		return nil, err
+	}
+
+	env, err := parseEnvPairs(r.MultipartForm.Value["env"])
+	if err != nil {
+		return nil, err
+	}
+
+	limits, err := formLimits(r.MultipartForm.Value)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RunRequest{
+		Lang:    r.FormValue("lang"),
+		Version: r.FormValue("version"),
+		Entry:   r.FormValue("entry"),
+		Args:    r.MultipartForm.Value["args"],
+		Env:     env,
+		Stdin:   formStdin(r.MultipartForm.Value),
+		Limits:  limits,
+		Files:   files,
+	}, nil
+}
+
+// resourceSegments returns the path segments after the resource name,
+// e.g. /api/v2/snippets/abc gives ["abc"] for the "snippets" resource.
+func resourceSegments(urlPath, resource string) []string {
+	idx := strings.Index(urlPath, "/"+resource)
+	if idx < 0 {
+		return nil
+	}
+
+	rest := strings.Trim(urlPath[idx+len(resource)+1:], "/")
+	if rest == "" {
+		return nil
+	}
+
+	return strings.Split(rest, "/")
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeAPIError(w http.ResponseWriter, status int, code, message string) {
+	writeJSON(w, status, map[string]APIError{
+		"error": {Code: code, Message: message},
+	})
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestResourceSegments(t *testing.T) {
+	cases := map[string][]string{
+		"/api/v2/runs":          nil,
+		"/api/v2/runs/":         nil,
+		"/api/v2/runs/abc":      {"abc"},
+		"/api/v2/runs/abc/":     {"abc"},
+		"/api/v2/runs/abc/more": {"abc", "more"},
+	}
+
+	for urlPath, expected := range cases {
+		segments := resourceSegments(urlPath, "runs")
+		if !reflect.DeepEqual(segments, expected) {
+			t.Fatalf("Segments of %s are %v, expected %v", urlPath, segments, expected)
+		}
+	}
+}
+
+// serveV2 sends the request to the handler, and decodes the response into v
+// unless it's an error, which is returned
+func serveV2(handler http.HandlerFunc, method, path, body string, v interface{}) (int, APIError) {
+	var r *http.Request
+	if body == "" {
+		r = httptest.NewRequest(method, path, nil)
+	} else {
+		r = httptest.NewRequest(method, path, strings.NewReader(body))
+	}
+	w := httptest.NewRecorder()
+	handler(w, r)
+
+	if w.Code >= 400 {
+		var apiErr map[string]APIError
+		json.NewDecoder(w.Body).Decode(&apiErr)
+		return w.Code, apiErr["error"]
+	}
+	if v != nil {
+		json.NewDecoder(w.Body).Decode(v)
+	}
+	return w.Code, APIError{}
+}
+
+func TestRoutesV2(t *testing.T) {
+	s := newTestServer()
+	defer func() { appConfig = nil }()
+
+	cases := []struct {
+		handler      http.HandlerFunc
+		method, path string
+		status       int
+		code         string
+	}{
+		{s.HandleRunsV2, http.MethodGet, "/api/v2/runs/abc/more", http.StatusNotFound, ErrCodeNotFound},
+		{s.HandleRunsV2, http.MethodGet, "/api/v2/runs", http.StatusMethodNotAllowed, ErrCodeMethodNotAllowed},
+		{s.HandleRunsV2, http.MethodPut, "/api/v2/runs/abc", http.StatusMethodNotAllowed, ErrCodeMethodNotAllowed},
+		{s.HandleRunsV2, http.MethodDelete, "/api/v2/runs/abc", http.StatusMethodNotAllowed, ErrCodeMethodNotAllowed},
+		{s.HandleSnippetsV2, http.MethodGet, "/api/v2/snippets", http.StatusMethodNotAllowed, ErrCodeMethodNotAllowed},
+		{s.HandleSnippetsV2, http.MethodPost, "/api/v2/snippets/abc", http.StatusMethodNotAllowed, ErrCodeMethodNotAllowed},
+		{s.HandleSnippetsV2, http.MethodPatch, "/api/v2/snippets/abc", http.StatusMethodNotAllowed, ErrCodeMethodNotAllowed},
+		{s.HandleSnippetsV2, http.MethodGet, "/api/v2/snippets/abc/more", http.StatusNotFound, ErrCodeNotFound},
+	}
+	for _, c := range cases {
+		if status, apiErr := serveV2(c.handler, c.method, c.path, "", nil); status != c.status || apiErr.Code != c.code {
+			t.Fatalf("Expected %d %s for %s %s, got %d %+v", c.status, c.code, c.method, c.path, status, apiErr)
+		}
+	}
+}
+
+func TestRunRequestErrorsV2(t *testing.T) {
+	s := newTestServer()
+	defer func() { appConfig = nil }()
+	appConfig.MaxSourceSize = 10
+	appConfig.MaxStdinSize = 8
+
+	cases := []struct {
+		body   string
+		status int
+		code   string
+	}{
+		{`{"lang": "ruby",`, http.StatusBadRequest, ErrCodeInvalidJSON},
+		{`{"lang": "cobol", "source": "puts 1"}`, http.StatusUnprocessableEntity, ErrCodeUnsupportedLanguage},
+		{`{"lang": "ruby", "source": ""}`, http.StatusUnprocessableEntity, ErrCodeMissingSource},
+		{`{"lang": "ruby", "source": "puts 1234567890"}`, http.StatusRequestEntityTooLarge, ErrCodeSourceTooLarge},
+		{`{"lang": "ruby", "source": "puts 1", "limits": {"profile": "huge"}}`, http.StatusUnprocessableEntity, ErrCodeInvalidLimits},
+		{`{"lang": "ruby", "source": "puts gets", "stdin": "1 2 3 4 5"}`, http.StatusRequestEntityTooLarge, ErrCodeStdinTooLarge},
+	}
+	for _, c := range cases {
+		if status, apiErr := serveV2(s.HandleRunsV2, http.MethodPost, "/api/v2/runs", c.body, nil); status != c.status || apiErr.Code != c.code {
+			t.Fatalf("Expected %d %s for the run %s, got %d %+v", c.status, c.code, c.body, status, apiErr)
+		}
+		if status, apiErr := serveV2(s.HandleSnippetsV2, http.MethodPost, "/api/v2/snippets", c.body, nil); status != c.status || apiErr.Code != c.code {
+			t.Fatalf("Expected %d %s for the snippet %s, got %d %+v", c.status, c.code, c.body, status, apiErr)
+		}
+	}
+}
+
+func TestRunsV2(t *testing.T) {
+	s := newTestServer()
+	defer func() { appConfig = nil }()
+
+	var created RunResource
+	code, _ := serveV2(s.HandleRunsV2, http.MethodPost, "/api/v2/runs", `{"lang": "ruby", "source": "puts 1"}`, &created)
+	if code != http.StatusCreated || created.Lang != "ruby" {
+		t.Fatalf("Expected the run to be created, got %d - %+v", code, created)
+	}
+	if created.SocketURL != "/api/v2/runs/"+created.ID+"/ws" || created.StreamURL != "/api/run/?evt=true&uuid="+created.ID {
+		t.Fatalf("Expected the URLs of the run, got %+v", created)
+	}
+	if runner, err := s.store.FetchRun(created.ID); err != nil || runner.Source != "puts 1" {
+		t.Fatalf("Expected the run to be registered, got %+v - %v", runner, err)
+	}
+
+	s.store.SaveRun("abc", &Runner{Lang: "ruby", Version: "2.7", Source: "puts 1"})
+	var shown RunResource
+	code, _ = serveV2(s.HandleRunsV2, http.MethodGet, "/api/v2/runs/abc", "", &shown)
+	if code != http.StatusOK || shown.ID != "abc" || shown.Version != "2.7" || shown.SocketURL != "/api/v2/runs/abc/ws" {
+		t.Fatalf("Expected the run to be shown, got %d - %+v", code, shown)
+	}
+
+	if status, apiErr := serveV2(s.HandleRunsV2, http.MethodGet, "/api/v2/runs/missing", "", nil); status != http.StatusNotFound || apiErr.Code != ErrCodeNotFound {
+		t.Fatalf("Expected 404 for a missing run, got %d %+v", status, apiErr)
+	}
+}
+
+func TestSnippetV2(t *testing.T) {
+	s := newTestServer()
+	defer func() { appConfig = nil }()
+
+	var created SnippetResource
+	code, _ := serveV2(s.HandleSnippetsV2, http.MethodPost, "/api/v2/snippets", `{"lang": "ruby", "source": "puts 1"}`, &created)
+	if code != http.StatusCreated || created.ID == "" || created.EditToken == "" {
+		t.Fatalf("Expected the snippet to be created, got %d - %+v", code, created)
+	}
+
+	var shown SnippetResource
+	code, _ = serveV2(s.HandleSnippetsV2, http.MethodGet, "/api/v2/snippets/"+created.ID, "", &shown)
+	if code != http.StatusOK || shown.Source != "puts 1" || shown.Lang != "ruby" || shown.Revision != 1 || shown.EditToken != "" {
+		t.Fatalf("Expected the snippet without its edit token, got %d - %+v", code, shown)
+	}
+
+	path := "/api/v2/snippets/" + created.ID
+	cases := []struct {
+		method, path, body string
+		status             int
+		code               string
+	}{
+		{http.MethodGet, "/api/v2/snippets/missing", "", http.StatusNotFound, ErrCodeNotFound},
+		{http.MethodPut, path, `{"lang": "ruby", "source": "puts 2"}`, http.StatusForbidden, ErrCodeEditForbidden},
+		{http.MethodPut, path, `{"lang": "ruby"`, http.StatusBadRequest, ErrCodeInvalidJSON},
+		{http.MethodDelete, path, "", http.StatusForbidden, ErrCodeEditForbidden},
+		{http.MethodDelete, "/api/v2/snippets/missing", "", http.StatusNotFound, ErrCodeNotFound},
+	}
+	for _, c := range cases {
+		if status, apiErr := serveV2(s.HandleSnippetsV2, c.method, c.path, c.body, nil); status != c.status || apiErr.Code != c.code {
+			t.Fatalf("Expected %d %s for %s %s, got %d %+v", c.status, c.code, c.method, c.path, status, apiErr)
+		}
+	}
+
+	// The snippet is unchanged by the refused requests
+	code, _ = serveV2(s.HandleSnippetsV2, http.MethodGet, path, "", &shown)
+	if code != http.StatusOK || shown.Source != "puts 1" || shown.Revision != 1 {
+		t.Fatalf("Expected the snippet to be unchanged, got %d - %+v", code, shown)
+	}
+}
+
+func TestRunStdinV2(t *testing.T) {
+	s := newTestServer()
+	defer func() { appConfig = nil }()
+
+	var created RunResource
+	code, _ := serveV2(s.HandleRunsV2, http.MethodPost, "/api/v2/runs", `{"lang": "ruby", "source": "puts gets", "stdin": "1 2\n"}`, &created)
+	if code != http.StatusCreated {
+		t.Fatalf("Expected the run to be created, got %d", code)
+	}
+	if runner, err := s.store.FetchRun(created.ID); err != nil || runner.Stdin == nil || *runner.Stdin != "1 2\n" {
+		t.Fatalf("Expected the stdin to be registered, got %+v - %v", runner, err)
+	}
+}
+
+func TestStatusV2MethodNotAllowed(t *testing.T) {
+	s := newTestServer()
+	defer func() { appConfig = nil }()
+
+	if status, apiErr := serveV2(s.HandleStatusV2, http.MethodPost, "/api/v2/status", "", nil); status != http.StatusMethodNotAllowed || apiErr.Code != ErrCodeMethodNotAllowed {
+		t.Fatalf("Expected 405, got %d %+v", status, apiErr)
+	}
+}
+
+func TestBodyTooLargeV2(t *testing.T) {
+	s := newTestServer()
+	defer func() { appConfig = nil }()
+	appConfig.MaxSourceSize = 10
+	appConfig.MaxStdinSize = 8
+
+	body := `{"lang": "ruby", "source": "puts 1", "version": "` + strings.Repeat("1", 128<<10) + `"}`
+	if status, apiErr := serveV2(s.HandleRunsV2, http.MethodPost, "/api/v2/runs", body, nil); status != http.StatusRequestEntityTooLarge || apiErr.Code != ErrCodeBodyTooLarge {
+		t.Fatalf("Expected 413 body_too_large, got %d %+v", status, apiErr)
+	}
+
+	var form bytes.Buffer
+	mw := multipart.NewWriter(&form)
+	mw.WriteField("lang", "ruby")
+	fw, _ := mw.CreateFormFile("archive", "main.tar")
+	fw.Write(make([]byte, 128<<10))
+	mw.Close()
+
+	r := httptest.NewRequest(http.MethodPost, "/api/v2/runs", &form)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	w := httptest.NewRecorder()
+	s.HandleRunsV2(w, r)
+	if w.Code != http.StatusRequestEntityTooLarge || !strings.Contains(w.Body.String(), ErrCodeBodyTooLarge) {
+		t.Fatalf("Expected 413 body_too_large for the upload, got %d - %s", w.Code, w.Body.String())
+	}
+}
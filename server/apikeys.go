@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// APIKey identifies a client of the API and limits how much it can run. The
+// key given to the client is "ID.secret", only the hash of the secret is
+// stored.
+type APIKey struct {
+	ID                string    `json:"id"`
+	Name              string    `json:"name"`
+	SecretHash        string    `json:"secret_hash"`
+	MaxConcurrentRuns int       `json:"max_concurrent_runs"` // Unlimited if 0, as the other quotas
+	MaxRunsPerDay     int       `json:"max_runs_per_day"`    // Of the day in UTC
+	MaxCPUSeconds     int64     `json:"max_cpu_seconds"`     // Of all the runs of the key
+	CreatedAt         time.Time `json:"created_at"`
+}
+
+// KeyUsage tells how much of its quotas an API key has used
+type KeyUsage struct {
+	ConcurrentRuns int
+	RunsToday      int
+	CPUTime        time.Duration
+}
+
+// Quotas of the API keys
+const (
+	QuotaConcurrentRuns = "concurrent_runs"
+	QuotaRunsPerDay     = "runs_per_day"
+	QuotaCPUSeconds     = "cpu_seconds"
+)
+
+// QuotaExceededError is returned when a run is over a quota of its API key
+type QuotaExceededError struct {
+	Quota string
+}
+
+func (e *QuotaExceededError) Error() string {
+	return fmt.Sprintf("the %s quota of the API key is used up", e.Quota)
+}
+
+// ErrInvalidAPIKey is returned when the API key doesn't exist
+var ErrInvalidAPIKey = errors.New("the API key is not valid")
+
+// keyRunLease is how long a run is counted as running when its server is
+// gone without finishing it
+const keyRunLease = time.Hour
+
+// dockerCPUPeriod is the period of the CPU quota of the containers
+const dockerCPUPeriod = 100000
+
+type apiKeyContextKey struct{}
+
+// newAPIKey creates the API key and returns the key to be given to the client
+func newAPIKey(name string) (*APIKey, string, error) {
+	id, err := newRandomHex(8)
+	if err != nil {
+		return nil, "", err
+	}
+
+	secret, err := newRandomHex(24)
+	if err != nil {
+		return nil, "", err
+	}
+
+	key := &APIKey{
+		ID:         id,
+		Name:       name,
+		SecretHash: hashEditToken(secret),
+		CreatedAt:  time.Now().UTC(),
+	}
+	return key, id + "." + secret, nil
+}
+
+// authenticate finds the API key given by the client
+func (s *Server) authenticate(given string) (*APIKey, error) {
+	parts := strings.SplitN(given, ".", 2)
+	if len(parts) != 2 {
+		return nil, ErrInvalidAPIKey
+	}
+
+	key, err := s.store.FetchAPIKey(parts[0])
+	if err == ErrNotFound {
+		return nil, ErrInvalidAPIKey
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	hash := hashEditToken(parts[1])
+	if subtle.ConstantTimeCompare([]byte(hash), []byte(key.SecretHash)) != 1 {
+		return nil, ErrInvalidAPIKey
+	}
+	return key, nil
+}
+
+// apiKeyMiddleWare finds the API key given by the X-API-Key header and puts it
+// in the context of the request. The request is refused when the key is not
+// valid, or when it's not given but required.
+func (s *Server) apiKeyMiddleWare(h http.Handler, required, jsonErrors bool) http.Handler {
+	refuse := func(w http.ResponseWriter, status int, code, message string) {
+		if jsonErrors {
+			writeAPIError(w, status, code, message)
+		} else {
+			http.Error(w, message, status)
+		}
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		given := r.Header.Get("X-API-Key")
+		if given == "" {
+			if required {
+				refuse(w, http.StatusUnauthorized, ErrCodeAPIKeyRequired, "An API key is required in the X-API-Key header")
+				return
+			}
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		key, err := s.authenticate(given)
+		if err == ErrInvalidAPIKey {
+			refuse(w, http.StatusUnauthorized, ErrCodeInvalidAPIKey, "The API key is not valid")
+			return
+		}
+		if err != nil {
+			s.logger.Errorf("Cannot check the API key - %v", err)
+			refuse(w, http.StatusInternalServerError, ErrCodeInternal, "A serious error has occured.")
+			return
+		}
+
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), apiKeyContextKey{}, key)))
+	})
+}
+
+// apiKeyID returns the ID of the API key of the request, or "" without one
+func apiKeyID(r *http.Request) string {
+	if key, ok := r.Context().Value(apiKeyContextKey{}).(*APIKey); ok {
+		return key.ID
+	}
+	return ""
+}
+
+// keyRun is a run counted against the quotas of an API key
+type keyRun struct {
+	store Store
+	key   *APIKey
+	cpu   time.Duration
+}
+
+// startKeyRun counts a run against the quotas of the API key, nothing is
+// counted without a key. It returns ErrInvalidAPIKey if the key has been
+// revoked since the run was registered, or a *QuotaExceededError.
+func (s *Server) startKeyRun(keyID string) (*keyRun, error) {
+	if keyID == "" {
+		return nil, nil
+	}
+
+	key, err := s.store.FetchAPIKey(keyID)
+	if err == ErrNotFound {
+		return nil, ErrInvalidAPIKey
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.store.StartKeyRun(key, today()); err != nil {
+		return nil, err
+	}
+	return &keyRun{store: s.store, key: key}, nil
+}
+
+// charge adds the CPU time of the run, its run time by the CPUs it's given
+func (kr *keyRun) charge(rnr *Runner, result *RunResult) {
+	if kr == nil {
+		return
+	}
+
+	cpus := float64(rnr.resources().CPUQuota) / dockerCPUPeriod
+	kr.cpu += time.Duration(float64(result.RunTime)*cpus) * time.Millisecond
+}
+
+// finish counts the run as finished, with the CPU time charged
+func (kr *keyRun) finish() error {
+	if kr == nil {
+		return nil
+	}
+	return kr.store.FinishKeyRun(kr.key, kr.cpu)
+}
+
+// finishKeyRun counts the run as finished against its API key
+func (s *Server) finishKeyRun(kr *keyRun) {
+	if err := kr.finish(); err != nil {
+		s.logger.Errorf("Cannot count the run of the API key %s as finished - %v", kr.key.ID, err)
+	}
+}
+
+// writeKeyRunError responds to a run that cannot be started for its API key
+func (s *Server) writeKeyRunError(w http.ResponseWriter, err error) {
+	status, code, message := s.keyRunError(err)
+	writeAPIError(w, status, code, message)
+}
+
+func (s *Server) keyRunError(err error) (status int, code, message string) {
+	if qerr, ok := err.(*QuotaExceededError); ok {
+		return http.StatusTooManyRequests, ErrCodeQuotaExceeded, qerr.Error()
+	}
+	if err == ErrInvalidAPIKey {
+		return http.StatusUnauthorized, ErrCodeInvalidAPIKey, "The API key is not valid"
+	}
+
+	s.logger.Errorf("Cannot count the run against its API key - %v", err)
+	return http.StatusInternalServerError, ErrCodeInternal, "A serious error has occured."
+}
+
+// today is the day the runs per day are counted for
+func today() string {
+	return time.Now().UTC().Format("2006-01-02")
+}
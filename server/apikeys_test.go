@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAPIKeyMiddleWare(t *testing.T) {
+	s := newTestServer()
+	defer func() { appConfig = nil }()
+
+	key, given, _ := newAPIKey("ci")
+	s.store.SaveAPIKey(key)
+
+	var seen string
+	h := s.apiKeyMiddleWare(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = apiKeyID(r)
+	}), true, true)
+
+	serve := func(header string) int {
+		r := httptest.NewRequest(http.MethodPost, "/api/v2/exec", nil)
+		if header != "" {
+			r.Header.Set("X-API-Key", header)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	if code := serve(""); code != http.StatusUnauthorized {
+		t.Fatalf("Expected 401 without a key, got %d", code)
+	}
+
+	if code := serve(key.ID + ".wrong"); code != http.StatusUnauthorized {
+		t.Fatalf("Expected 401 with a wrong secret, got %d", code)
+	}
+
+	if code := serve(given); code != http.StatusOK || seen != key.ID {
+		t.Fatalf("Expected the key %s to be given, got %d - %q", key.ID, code, seen)
+	}
+}
+
+func TestKeyQuotas(t *testing.T) {
+	store := NewMemoryStore()
+	key := &APIKey{ID: "abc", MaxConcurrentRuns: 1, MaxRunsPerDay: 2, MaxCPUSeconds: 1}
+
+	if err := store.StartKeyRun(key, "2026-10-18"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err, ok := store.StartKeyRun(key, "2026-10-18").(*QuotaExceededError); !ok || err.Quota != QuotaConcurrentRuns {
+		t.Fatalf("Expected the concurrent runs to be used up, got %v", err)
+	}
+
+	store.FinishKeyRun(key, 500*time.Millisecond)
+	store.StartKeyRun(key, "2026-10-18")
+	store.FinishKeyRun(key, 500*time.Millisecond)
+
+	if err, ok := store.StartKeyRun(key, "2026-10-18").(*QuotaExceededError); !ok || err.Quota != QuotaRunsPerDay {
+		t.Fatalf("Expected the runs of the day to be used up, got %v", err)
+	}
+
+	usage, _ := store.FetchKeyUsage("abc", "2026-10-18")
+	if usage.ConcurrentRuns != 0 || usage.RunsToday != 2 || usage.CPUTime != time.Second {
+		t.Fatalf("Unexpected usage %+v", usage)
+	}
+
+	if err, ok := store.StartKeyRun(key, "2026-10-19").(*QuotaExceededError); !ok || err.Quota != QuotaCPUSeconds {
+		t.Fatalf("Expected the CPU seconds to be used up, got %v", err)
+	}
+}
+
+// takenIDStore refuses the first API keys as if their IDs were taken
+type takenIDStore struct {
+	Store
+	taken int
+}
+
+func (s *takenIDStore) SaveAPIKey(key *APIKey) error {
+	if s.taken > 0 {
+		s.taken--
+		return ErrAPIKeyExists
+	}
+	return s.Store.SaveAPIKey(key)
+}
+
+func TestKeysCommandIDTaken(t *testing.T) {
+	store := &takenIDStore{Store: NewMemoryStore(), taken: 1}
+
+	var out bytes.Buffer
+	if status := runKeysCommand(store, []string{"create", "-name=ci"}, &out); status != 0 {
+		t.Fatalf("Expected the key to be created under another ID, got %d - %s", status, out.String())
+	}
+	if keys, _ := store.ListAPIKeys(); len(keys) != 1 || len(keys[0].ID) != 16 {
+		t.Fatalf("Unexpected keys %+v", keys)
+	}
+
+	store.taken = maxKeyIDAttempts
+	out.Reset()
+	if status := runKeysCommand(store, []string{"create", "-name=ci"}, &out); status != 1 || !strings.Contains(out.String(), ErrAPIKeyExists.Error()) {
+		t.Fatalf("Expected to give up on the taken IDs, got %d - %s", status, out.String())
+	}
+}
+
+func TestKeysCommand(t *testing.T) {
+	store := NewMemoryStore()
+
+	var out bytes.Buffer
+	if status := runKeysCommand(store, []string{"create", "-name=ci", "-daily=100"}, &out); status != 0 {
+		t.Fatalf("Expected the key to be created, got %d - %s", status, out.String())
+	}
+
+	keys, _ := store.ListAPIKeys()
+	if len(keys) != 1 || keys[0].Name != "ci" || keys[0].MaxRunsPerDay != 100 {
+		t.Fatalf("Unexpected keys %+v", keys)
+	}
+
+	out.Reset()
+	runKeysCommand(store, []string{"list"}, &out)
+	if !strings.Contains(out.String(), keys[0].ID) || !strings.Contains(out.String(), "0/100") {
+		t.Fatalf("Unexpected list\n%s", out.String())
+	}
+
+	out.Reset()
+	if status := runKeysCommand(store, []string{"revoke", keys[0].ID}, &out); status != 0 {
+		t.Fatalf("Expected the key to be revoked, got %d - %s", status, out.String())
+	}
+
+	if _, err := store.FetchAPIKey(keys[0].ID); err != ErrNotFound {
+		t.Fatalf("Expected the key to be gone, got %v", err)
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Severities of a diagnostic
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+	SeverityNote    = "note"
+)
+
+// Diagnostic is a message of the compiler about a position in the code
+type Diagnostic struct {
+	File     string `json:"file"`
+	Line     int    `json:"line"`
+	Column   int    `json:"column,omitempty"`
+	Severity string `json:"severity"`
+	Code     string `json:"code,omitempty"`
+	Message  string `json:"message"`
+}
+
+// CheckResult is the JSON document returned by the check. The code compiles
+// when OK is true, Output is what the compiler has written out.
+type CheckResult struct {
+	OK          bool         `json:"ok"`
+	Diagnostics []Diagnostic `json:"diagnostics"`
+	Output      string       `json:"output"`
+	*RunResult
+}
+
+// gccDiagnostic matches file:line:column: severity: message, which is written
+// by gcc, clang, swiftc and go (without the severity)
+var gccDiagnostic = regexp.MustCompile(`^([^\s:]+):(\d+):(?:(\d+):)?\s*(?:(fatal error|error|warning|note):\s*)?(.*)$`)
+
+// msbuildDiagnostic matches file(line,column): severity code: message [project]
+// written by the dotnet compilers
+var msbuildDiagnostic = regexp.MustCompile(`^\s*(.+?)\((\d+),(\d+)(?:,\d+,\d+)?\):\s*(error|warning|info)\s*(\w*):\s*(.*?)(?:\s+\[[^\]]*\])?$`)
+
+// HandleCheckV2 only compiles the code with the check command of the language,
+// and returns the diagnostics of the compiler
+func (s *Server) HandleCheckV2(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeAPIError(w, http.StatusMethodNotAllowed, ErrCodeMethodNotAllowed, r.Method+" is not allowed here")
+		return
+	}
+
+	req, ok := decodeRunRequest(w, r)
+	if !ok {
+		return
+	}
+
+	lang := (*appConfig.Languages)[req.Lang]
+	if lang.CheckCmd == "" {
+		writeAPIError(w, http.StatusUnprocessableEntity, ErrCodeCheckNotSupported, "Checking "+req.Lang+" code is not supported")
+		return
+	}
+
+	stdin := ""
+	runner := req.newRunner()
+	runner.Stdin = &stdin
+	runner.check = true
+	runner.closeNotifier = w.(http.CloseNotifier).CloseNotify()
+	runner.logger = s.logger
+
+	if err := admit(runner); err != nil {
+		writeQueueFull(w, true)
+		return
+	}
+
+	keyRun, err := s.startKeyRun(apiKeyID(r))
+	if err != nil {
+		runner.ticket.Done()
+		s.writeKeyRunError(w, err)
+		return
+	}
+
+	// Compilers write the diagnostics to either of the streams
+	var output syncBuffer
+	result := runner.Run(nil, &output, &output, newUUID())
+	keyRun.charge(runner, result)
+	s.finishKeyRun(keyRun)
+
+	writeJSON(w, http.StatusOK, CheckResult{
+		OK:          result.Reason == ReasonExited && result.ExitCode == 0,
+		Diagnostics: parseDiagnostics(lang, output.String()),
+		Output:      output.String(),
+		RunResult:   result,
+	})
+}
+
+// parseDiagnostics parses the output of the check command by the diagnostic
+// format of the language. Lines that are not diagnostics are skipped, as well
+// as the same diagnostic written more than once.
+func parseDiagnostics(lang Language, output string) []Diagnostic {
+	diagnostics := []Diagnostic{}
+	seen := map[Diagnostic]bool{}
+
+	for _, line := range strings.Split(output, "\n") {
+		d, ok := parseDiagnostic(lang.DiagnosticFormat, strings.TrimRight(line, "\r"))
+		if !ok {
+			continue
+		}
+
+		d.File = strings.TrimPrefix(strings.TrimPrefix(d.File, lang.WorkDir+"/"), "./")
+		if !seen[d] {
+			seen[d] = true
+			diagnostics = append(diagnostics, d)
+		}
+	}
+
+	return diagnostics
+}
+
+func parseDiagnostic(format, line string) (Diagnostic, bool) {
+	var d Diagnostic
+
+	switch format {
+	case "msbuild":
+		m := msbuildDiagnostic.FindStringSubmatch(line)
+		if m == nil {
+			return d, false
+		}
+
+		d.File, d.Severity, d.Code, d.Message = m[1], m[4], m[5], m[6]
+		d.Line, _ = strconv.Atoi(m[2])
+		d.Column, _ = strconv.Atoi(m[3])
+		if d.Severity == "info" {
+			d.Severity = SeverityNote
+		}
+	default:
+		m := gccDiagnostic.FindStringSubmatch(line)
+		if m == nil {
+			return d, false
+		}
+
+		d.File, d.Severity, d.Message = m[1], m[4], m[5]
+		d.Line, _ = strconv.Atoi(m[2])
+		d.Column, _ = strconv.Atoi(m[3])
+		switch d.Severity {
+		case "", "fatal error":
+			// go doesn't tell the severity, everything is an error
+			d.Severity = SeverityError
+		}
+	}
+
+	return d, true
+}
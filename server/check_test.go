@@ -0,0 +1,53 @@
+package main
+
+import "testing"
+
+func TestParseDiagnosticsGCC(t *testing.T) {
+	lang := Language{WorkDir: "/c", DiagnosticFormat: "gcc"}
+	output := `./main.c: In function 'main':
+./main.c:4:5: warning: unused variable 'x' [-Wunused-variable]
+     int x;
+     ^
+lib/util.c:10:12: error: expected ';' before '}' token
+`
+
+	diagnostics := parseDiagnostics(lang, output)
+	if len(diagnostics) != 2 {
+		t.Fatalf("Expected 2 diagnostics, got %+v", diagnostics)
+	}
+
+	expected := Diagnostic{File: "main.c", Line: 4, Column: 5, Severity: SeverityWarning, Message: "unused variable 'x' [-Wunused-variable]"}
+	if diagnostics[0] != expected {
+		t.Fatalf("Expected %+v, got %+v", expected, diagnostics[0])
+	}
+
+	if d := diagnostics[1]; d.File != "lib/util.c" || d.Line != 10 || d.Severity != SeverityError {
+		t.Fatalf("Unexpected diagnostic %+v", d)
+	}
+}
+
+func TestParseDiagnosticsGo(t *testing.T) {
+	lang := Language{WorkDir: "/go/src", DiagnosticFormat: "gcc"}
+	output := "# command-line-arguments\n./main.go:5: undefined: x\n"
+
+	diagnostics := parseDiagnostics(lang, output)
+	expected := Diagnostic{File: "main.go", Line: 5, Severity: SeverityError, Message: "undefined: x"}
+	if len(diagnostics) != 1 || diagnostics[0] != expected {
+		t.Fatalf("Expected %+v, got %+v", expected, diagnostics)
+	}
+}
+
+func TestParseDiagnosticsMSBuild(t *testing.T) {
+	lang := Language{WorkDir: "/dotnet", DiagnosticFormat: "msbuild"}
+	output := `Compiling dotnet for .NETCoreApp,Version=v1.0
+/dotnet/Runner.cs(5,13): error CS0103: The name 'x' does not exist in the current context [/dotnet/project.json]
+Compilation failed.
+/dotnet/Runner.cs(5,13): error CS0103: The name 'x' does not exist in the current context [/dotnet/project.json]
+`
+
+	diagnostics := parseDiagnostics(lang, output)
+	expected := Diagnostic{File: "Runner.cs", Line: 5, Column: 13, Severity: SeverityError, Code: "CS0103", Message: "The name 'x' does not exist in the current context"}
+	if len(diagnostics) != 1 || diagnostics[0] != expected {
+		t.Fatalf("Expected %+v, got %+v", expected, diagnostics)
+	}
+}
@@ -2,6 +2,8 @@ package main
 
 import (
 	"bytes"
+	"encoding/binary"
+	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
@@ -9,115 +11,276 @@ import (
 	"strings"
 
 	"github.com/Sirupsen/logrus"
-	"github.com/garyburd/redigo/redis"
 )
 
 type messages chan string
 
+// Names of the output streams. StreamExit carries the RunResult as JSON
+// and is always the last one sent. StreamQueued carries the QueuePosition
+// of a run waiting for its turn, and StreamStarted the time it waited as
+// {"waited_ms": 1200} once it has its turn.
+const (
+	StreamStdout  = "stdout"
+	StreamStderr  = "stderr"
+	StreamExit    = "exit"
+	StreamQueued  = "queued"
+	StreamStarted = "started"
+)
+
+// Stream types used in the header of the multiplexed raw framing
+const (
+	muxStdout  byte = 1
+	muxStderr  byte = 2
+	muxExit    byte = 3
+	muxQueued  byte = 4
+	muxStarted byte = 5
+)
+
+var muxStreamTypes = map[string]byte{
+	StreamStdout:  muxStdout,
+	StreamStderr:  muxStderr,
+	StreamExit:    muxExit,
+	StreamQueued:  muxQueued,
+	StreamStarted: muxStarted,
+}
+
+// isEventStream tells whether the stream carries JSON rather than output
+func isEventStream(stream string) bool {
+	return stream == StreamExit || stream == StreamQueued || stream == StreamStarted
+}
+
+// isQueueStream tells whether the stream tells about the turn of the run
+func isQueueStream(stream string) bool {
+	return stream == StreamQueued || stream == StreamStarted
+}
+
+// frame is a chunk of the program output tagged with its stream
+type frame struct {
+	stream string
+	data   []byte
+}
+
+// streamWriter hands whatever is written to it over to Client.Write
+type streamWriter struct {
+	stream    string
+	frames    chan<- frame
+	writeDone <-chan struct{}
+}
+
+func (sw *streamWriter) Write(p []byte) (int, error) {
+	data := make([]byte, len(p))
+	copy(data, p)
+
+	select {
+	case sw.frames <- frame{stream: sw.stream, data: data}:
+		return len(p), nil
+	case <-sw.writeDone:
+		return 0, io.ErrClosedPipe
+	}
+}
+
 // Client is a proxy struct registered for running
 type Client struct {
-	runner       *Runner
-	stdoutWriter io.Writer
-	stdoutReader io.Reader
-	stdinWriter  io.Writer
-	stdinReader  io.Reader
-	conn         redis.Conn // redis connection
-	uuid         string
+	runner      *Runner
+	frames      chan frame
+	finished    chan struct{} // closed once the runner is finished
+	writeDone   chan struct{} // closed once the output is not consumed anymore
+	stdinWriter *io.PipeWriter
+	stdinReader *io.PipeReader
+	uuid        string
+	muxQueue    bool // Send the queue streams in the multiplexed framing
 }
 
 // NewClient creates new client
-func NewClient(r *Runner, conn redis.Conn, uuid string) *Client {
-	stdoutReader, stdoutWriter := io.Pipe()
+func NewClient(r *Runner, uuid string) *Client {
 	stdinReader, stdinWriter := io.Pipe()
 	return &Client{
-		stdoutReader: stdoutReader,
-		stdoutWriter: stdoutWriter,
-		stdinReader:  stdinReader,
-		stdinWriter:  stdinWriter,
-		runner:       r,
-		conn:         conn,
-		uuid:         uuid,
+		frames:      make(chan frame),
+		finished:    make(chan struct{}),
+		writeDone:   make(chan struct{}),
+		stdinReader: stdinReader,
+		stdinWriter: stdinWriter,
+		runner:      r,
+		uuid:        uuid,
 	}
 }
 
 // Run kicks start the container
-func (cli *Client) Run() {
-	cli.runner.Run(cli.stdinReader, cli.stdoutWriter, cli.conn, cli.uuid)
+func (cli *Client) Run() *RunResult {
+	stdout := &streamWriter{stream: StreamStdout, frames: cli.frames, writeDone: cli.writeDone}
+	stderr := &streamWriter{stream: StreamStderr, frames: cli.frames, writeDone: cli.writeDone}
+	if cli.runner.Stdin != nil {
+		// Nothing is going to read the interactive stdin
+		cli.stdinReader.Close()
+	}
+
+	cli.runner.onQueued = func(p QueuePosition) {
+		cli.sendEvent(StreamQueued, p)
+	}
+	cli.runner.onStarted = func(waited int64) {
+		cli.sendEvent(StreamStarted, map[string]int64{"waited_ms": waited})
+	}
+
+	result := cli.runner.Run(cli.stdinReader, stdout, stderr, cli.uuid)
+	cli.stdinReader.Close()
+	cli.sendEvent(StreamExit, result)
+
+	// Wait for the output to be delivered before the request is finished
+	close(cli.finished)
+	<-cli.writeDone
+
+	return result
 }
 
-func (cli *Client) Read() {
-	psc := redis.PubSubConn{Conn: cli.conn}
-	psc.Subscribe(cli.uuid + "#stdin")
+// sendEvent delivers v as a JSON frame of the stream, e.g. the result of the
+// run as the final frame of the output
+func (cli *Client) sendEvent(stream string, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		cli.logger().Errorf("The %s event of %s cannot be encoded - %v", stream, cli.uuid, err)
+		return
+	}
+
+	select {
+	case cli.frames <- frame{stream: stream, data: data}:
+	case <-cli.writeDone:
+	}
+}
 
-	defer func() {
-		psc.Unsubscribe(cli.uuid + "#stdin")
-		psc.Close()
-		cli.conn.Close()
+// Read delivers the stdin published to the run into the program, until the
+// program is finished
+func (cli *Client) Read(store Store) {
+	sub, err := store.SubscribeStdin(cli.uuid)
+	if err != nil {
+		cli.logger().Errorf("Stdin of %s cannot be subscribed - %v", cli.uuid, err)
+		return
+	}
+
+	go func() {
+		<-cli.finished
+		sub.Close()
 	}()
 
-StdinSubscriptionLoop:
 	for {
-		switch n := psc.Receive().(type) {
-		case redis.Message:
-			stdinData := strconv.QuoteToASCII(string(n.Data))
-			cli.runner.logger.Infof("Message: %s %s", n.Channel, stdinData)
-			cli.stdinWriter.Write(n.Data)
-		case error:
-			break StdinSubscriptionLoop
+		data, err := sub.Receive()
+		if err != nil {
+			break
 		}
+
+		stdinData := strconv.QuoteToASCII(string(data))
+		cli.logger().Infof("Message: %s#stdin %s", cli.uuid, stdinData)
+		metrics.stdinMessages.Inc("http")
+		cli.stdinWriter.Write(data)
 	}
-	cli.runner.logger.Info("Stdin subscription closed")
+	cli.logger().Info("Stdin subscription closed")
 }
 
-// Writing things out
-func (cli *Client) Write(w http.ResponseWriter, isEvtSource bool) {
+// Writing things out. The output is sent as typed server-sent events when
+// isEvtSource is true, multiplexed with a frame header per chunk when isMux
+// is true, or as plain bytes otherwise.
+func (cli *Client) Write(w http.ResponseWriter, isEvtSource, isMux bool) {
+	defer close(cli.writeDone)
+
 	f, ok := w.(http.Flusher)
 	if !ok {
 		http.Error(w, "The server does not support streaming!", http.StatusInternalServerError)
+		return
 	}
 
-	w.Header().Set("Content-Type", "text/event-stream")
+	if isMux {
+		w.Header().Set("Content-Type", "application/vnd.koderunr.mux")
+	} else {
+		w.Header().Set("Content-Type", "text/event-stream")
+	}
 	w.Header().Set("Cache-Control", "no-cache")
 	w.Header().Set("Connection", "keep-alive")
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("X-Accel-Buffering", "no")
 
-	buffer := make([]byte, 512)
+	// The event of the last SSE message if it has not been terminated yet
+	pendingEvent := ""
 
-	for {
-		n, err := cli.stdoutReader.Read(buffer)
-		if err != nil {
-			break
-		}
-
-		msg := string(buffer[0:n])
+	write := func(fr frame) bool {
+		var msg []byte
 
-		if isEvtSource == true {
-			msg = cli.sseFormat(msg)
+		switch {
+		case isEvtSource && isEventStream(fr.stream):
+			var prefix string
+			if pendingEvent != "" {
+				prefix = "\n"
+			}
+			pendingEvent = ""
+			msg = []byte(fmt.Sprintf("%sevent: %s\ndata: %s\n\n", prefix, fr.stream, fr.data))
+		case isMux && isQueueStream(fr.stream) && !cli.muxQueue:
+			// The clients which don't ask for them cannot read them
+			return true
+		case isEvtSource:
+			var prefix string
+			if pendingEvent != "" && pendingEvent != fr.stream {
+				prefix = "\n"
+			}
+			var sse string
+			sse, pendingEvent = cli.sseFormat(fr.stream, string(fr.data))
+			msg = []byte(prefix + sse)
+		case isMux:
+			msg = muxFormat(muxStreamTypes[fr.stream], fr.data)
+		case isEventStream(fr.stream):
+			// The plain output has no room for the events
+			return true
+		default:
+			msg = fr.data
 		}
 
-		if _, err := fmt.Fprint(w, msg); err != nil {
-			cli.logger().Errorf("Response is not writable for %s\n", msg)
-			return
+		if _, err := w.Write(msg); err != nil {
+			cli.logger().Errorf("Response is not writable for %s\n", fr.data)
+			return false
 		}
 		f.Flush()
+		return true
+	}
+
+	cli.consume(write)
+}
 
-		// Clear the buffer
-		for i := 0; i < n; i++ {
-			buffer[i] = 0
+// consume hands every frame of the output over to write until the runner
+// is finished or write returns false.
+func (cli *Client) consume(write func(fr frame) bool) {
+OutputLoop:
+	for {
+		select {
+		case fr := <-cli.frames:
+			if !write(fr) {
+				return
+			}
+		case <-cli.finished:
+			break OutputLoop
 		}
 	}
 
-	if isEvtSource == true {
-		msg := cli.sseFormat("\n")
-		if _, err := fmt.Fprint(w, msg); err != nil {
-			cli.logger().Errorf("Response is not writable for %s\n", msg)
-			return
+	// Deliver whatever is left once the runner is finished
+	for {
+		select {
+		case fr := <-cli.frames:
+			if !write(fr) {
+				return
+			}
+			continue
+		default:
 		}
-		f.Flush()
+		break
 	}
 }
 
+// muxFormat prefixes the data with an 8 bytes header: the stream type,
+// 3 bytes of padding and the big endian uint32 size of the data.
+func muxFormat(streamType byte, data []byte) []byte {
+	msg := make([]byte, 8+len(data))
+	msg[0] = streamType
+	binary.BigEndian.PutUint32(msg[4:8], uint32(len(data)))
+	copy(msg[8:], data)
+	return msg
+}
+
 // To make event source comfort.
 // From http://www.html5rocks.com/en/tutorials/eventsource/basics/
 // If your message is longer, you can break it up by using multiple "data:" lines.
@@ -125,10 +288,14 @@ func (cli *Client) Write(w http.ResponseWriter, isEvtSource bool) {
 // piece of data, meaning only one message event will be fired. Each line should
 // end in a single "\n" (except for the last, which should end with two). The result
 // passed to your message handler is a single string concatenated by newline characters.
-func (cli *Client) sseFormat(msg string) string {
+//
+// Every message is sent with the event name of its stream, so the browser can
+// listen to "stdout" and "stderr" separately. When msg does not end a line the
+// message is left open and the event is returned as pending.
+func (cli *Client) sseFormat(event, msg string) (string, string) {
 	// if msg does not contain linebreak, we simply wrote that out
 	if !strings.Contains(msg, "\n") {
-		return fmt.Sprintf("data: %s\n", msg)
+		return fmt.Sprintf("event: %s\ndata: %s\n", event, msg), event
 	}
 
 	lines := strings.Split(msg, "\n")
@@ -138,10 +305,10 @@ func (cli *Client) sseFormat(msg string) string {
 		if i == len(lines)-1 && line == "" {
 			continue
 		}
-		fmt.Fprintf(&b, "data: %s\n\n", line)
+		fmt.Fprintf(&b, "event: %s\ndata: %s\n\n", event, line)
 	}
 
-	return b.String()
+	return b.String(), ""
 }
 
 func (cli *Client) logger() *logrus.Logger {
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestSSEFormat(t *testing.T) {
+	cli := &Client{}
+
+	msg, pending := cli.sseFormat(StreamStderr, "oops\nboom\n")
+	if msg != "event: stderr\ndata: oops\n\nevent: stderr\ndata: boom\n\n" || pending != "" {
+		t.Fatalf("Unexpected SSE message %q (pending %q)", msg, pending)
+	}
+
+	msg, pending = cli.sseFormat(StreamStdout, "Enter a number: ")
+	if msg != "event: stdout\ndata: Enter a number: \n" || pending != StreamStdout {
+		t.Fatalf("Unexpected SSE message %q (pending %q)", msg, pending)
+	}
+}
+
+func TestMuxFormat(t *testing.T) {
+	msg := muxFormat(muxStderr, []byte("oops"))
+	expected := []byte{2, 0, 0, 0, 0, 0, 0, 4, 'o', 'o', 'p', 's'}
+
+	if !bytes.Equal(msg, expected) {
+		t.Fatalf("Unexpected frame %v", msg)
+	}
+}
+
+func TestClientRunStdin(t *testing.T) {
+	s := newTestServer()
+	defer func() { Workers, appConfig = nil, nil }()
+	Workers = s.store
+
+	stdin := "1 2\n"
+	cli := NewClient(&Runner{Lang: "ruby", Source: "puts gets", Timeout: 5, Stdin: &stdin, logger: s.logger}, "abc")
+
+	// The interactive stdin is closed as the program has its whole stdin
+	go runJob(t, s.store, func(job *Job, publish func(string, []byte)) {
+		if _, err := cli.stdinWriter.Write([]byte("3\n")); err != io.ErrClosedPipe {
+			t.Errorf("Expected the interactive stdin to be closed, got %v", err)
+		}
+		publish(StreamStdout, []byte(*job.Runner.Stdin))
+		publish(StreamExit, []byte(`{"exit_code": 0, "reason": "exited"}`))
+	})
+
+	var stdout bytes.Buffer
+	go func() {
+		defer close(cli.writeDone)
+		cli.consume(func(fr frame) bool {
+			if fr.stream == StreamStdout {
+				stdout.Write(fr.data)
+			}
+			return true
+		})
+	}()
+
+	if result := cli.Run(); result.Reason != ReasonExited {
+		t.Fatalf("Expected the run to exit, got %+v", result)
+	}
+	if stdout.String() != "1 2\n" {
+		t.Fatalf("Expected the given stdin to be used, got %q", stdout.String())
+	}
+}
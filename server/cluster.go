@@ -0,0 +1,110 @@
+package main
+
+import (
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// clusterPollInterval is how often the waiting runs try again for the slots
+// given back by the other servers
+const clusterPollInterval = 500 * time.Millisecond
+
+// Semaphore lets up to Limit holders have a slot at the same time
+type Semaphore struct {
+	Name  string
+	Limit int
+}
+
+// clusterSlots hands out the slots of the Docker host of the server and of
+// the languages, shared by all the servers through the store. A slot is held
+// for a lease, which is renewed while the run goes on, so the slots of a
+// server which is gone are given back once their leases end.
+type clusterSlots struct {
+	store  Store
+	host   string
+	lease  time.Duration
+	logger *logrus.Logger
+}
+
+func newClusterSlots(store Store, cfg ClusterConfig, logger *logrus.Logger) *clusterSlots {
+	return &clusterSlots{
+		store:  store,
+		host:   cfg.GetDockerHost(),
+		lease:  cfg.GetLease(),
+		logger: logger,
+	}
+}
+
+// semaphores are the semaphores a run of the language takes a slot of, the
+// one of the host first
+func (c *clusterSlots) semaphores(lang string) []Semaphore {
+	sems := []Semaphore{{Name: "host#" + c.host, Limit: appConfig.GetHostSlots(c.host)}}
+	if limit, ok := appConfig.Cluster.LanguageSlots[lang]; ok {
+		sems = append(sems, Semaphore{Name: "lang#" + lang, Limit: limit})
+	}
+	return sems
+}
+
+// acquire takes the slots of a run of the language, and returns how to give
+// them back. hostFull tells when the host has no free slot, so no other run
+// can go either.
+func (c *clusterSlots) acquire(lang string) (release func(), hostFull bool, err error) {
+	holder, err := newRandomHex(8)
+	if err != nil {
+		return nil, false, err
+	}
+
+	sems := c.semaphores(lang)
+	full, err := c.store.AcquireSlots(sems, holder, c.lease)
+	if err != nil || full >= 0 {
+		return nil, full == 0, err
+	}
+
+	stop := make(chan struct{})
+	go c.renew(sems, holder, stop)
+
+	return func() {
+		close(stop)
+		if err := c.store.ReleaseSlots(sems, holder); err != nil {
+			c.logger.Errorf("Cannot give the slots of %s back - %v", holder, err)
+		}
+	}, false, nil
+}
+
+// renew extends the lease of the slots until stop is closed
+func (c *clusterSlots) renew(sems []Semaphore, holder string, stop <-chan struct{}) {
+	ticker := time.NewTicker(c.lease / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			held, err := c.store.RenewSlots(sems, holder, c.lease)
+			if err != nil {
+				c.logger.Errorf("Cannot renew the slots of %s - %v", holder, err)
+			} else if !held {
+				c.logger.Errorf("The slots of %s were lost before the run finished", holder)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// status tells how busy the Docker host is across the servers
+func (c *clusterSlots) status() *ClusterStatus {
+	running, err := c.store.CountSlots("host#" + c.host)
+	if err != nil {
+		c.logger.Errorf("Cannot count the slots of %s - %v", c.host, err)
+	}
+
+	return &ClusterStatus{Host: c.host, Slots: appConfig.GetHostSlots(c.host), Running: running}
+}
+
+// ClusterStatus tells how busy the Docker host of the server is
+type ClusterStatus struct {
+	Host    string `json:"host"`
+	Slots   int    `json:"slots"`
+	Running int    `json:"running"` // By all the servers
+}
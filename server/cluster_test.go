@@ -0,0 +1,156 @@
+package main
+
+import (
+	"io/ioutil"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+func TestMemoryStoreSlots(t *testing.T) {
+	store := NewMemoryStore()
+	sems := []Semaphore{{Name: "host#a", Limit: 2}, {Name: "lang#ruby", Limit: 1}}
+
+	if full, _ := store.AcquireSlots(sems, "one", time.Minute); full != -1 {
+		t.Fatalf("Expected the slots to be taken, got %d", full)
+	}
+
+	if full, _ := store.AcquireSlots(sems, "two", time.Minute); full != 1 {
+		t.Fatalf("Expected the language to be full, got %d", full)
+	}
+
+	if n, _ := store.CountSlots("host#a"); n != 1 {
+		t.Fatalf("Expected no slot to be taken of a full semaphore, got %d", n)
+	}
+
+	store.ReleaseSlots(sems, "one")
+	if full, _ := store.AcquireSlots(sems, "two", 10*time.Millisecond); full != -1 {
+		t.Fatalf("Expected the slots to be given back, got %d", full)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	if held, _ := store.RenewSlots(sems, "two", time.Minute); held {
+		t.Fatal("Expected the lease to have ended")
+	}
+	if n, _ := store.CountSlots("host#a"); n != 0 {
+		t.Fatalf("Expected the slots of an ended lease to be free, got %d", n)
+	}
+}
+
+func TestClusterRunQueue(t *testing.T) {
+	appConfig = &Config{Cluster: ClusterConfig{
+		HostSlots:     map[string]int{"docker1": 2},
+		LanguageSlots: map[string]int{"python": 1},
+	}}
+	defer func() { appConfig = nil }()
+
+	logger := logrus.New()
+	logger.Out = ioutil.Discard
+	store := NewMemoryStore()
+	cluster := &clusterSlots{store: store, host: "docker1", lease: time.Minute, logger: logger}
+
+	// Two servers sharing the Docker host
+	q1, q2 := NewRunQueue(4, 10), NewRunQueue(4, 10)
+	q1.EnableCluster(cluster)
+	q2.EnableCluster(cluster)
+
+	python, _ := q1.Join("python", false)
+	python.Wait(nil, nil)
+
+	waitingPython, _ := q2.Join("python", false)
+	ruby, _ := q2.Join("ruby", false)
+
+	if !waitsFor(ruby) {
+		t.Fatal("Expected the ruby run to go while python has no free slot")
+	}
+
+	if _, err := q1.Join("ruby", true); err != nil {
+		t.Fatal(err)
+	}
+	if status := q1.Status(); status.Cluster == nil || status.Cluster.Running != 2 || status.Queued != 1 {
+		t.Fatalf("Expected the host to be full, got %+v - %+v", status, status.Cluster)
+	}
+
+	python.Done()
+	ruby.Done()
+	if !waitsFor(waitingPython) {
+		t.Fatal("Expected the python run to go once the slots are given back")
+	}
+}
+
+// slowStore holds the slots up until unblock is closed once block is set
+type slowStore struct {
+	*MemoryStore
+	block     int32
+	acquiring chan struct{}
+	unblock   chan struct{}
+}
+
+func (s *slowStore) AcquireSlots(sems []Semaphore, holder string, lease time.Duration) (int, error) {
+	if atomic.LoadInt32(&s.block) == 1 {
+		select {
+		case s.acquiring <- struct{}{}:
+		default:
+		}
+		<-s.unblock
+	}
+	return s.MemoryStore.AcquireSlots(sems, holder, lease)
+}
+
+func TestClusterRunQueueSlowStore(t *testing.T) {
+	appConfig = &Config{Cluster: ClusterConfig{HostSlots: map[string]int{"docker1": 1}}}
+	defer func() { appConfig = nil }()
+
+	logger := logrus.New()
+	logger.Out = ioutil.Discard
+	store := &slowStore{MemoryStore: NewMemoryStore(), acquiring: make(chan struct{}, 1), unblock: make(chan struct{})}
+	cluster := &clusterSlots{store: store, host: "docker1", lease: time.Minute, logger: logger}
+
+	q := NewRunQueue(4, 10)
+	q.EnableCluster(cluster)
+
+	first, _ := q.Join("ruby", false)
+	if !waitsFor(first) {
+		t.Fatal("Expected the first run to go right away")
+	}
+
+	atomic.StoreInt32(&store.block, 1)
+	joined := make(chan *QueueTicket)
+	go func() {
+		second, _ := q.Join("ruby", false)
+		joined <- second
+	}()
+	<-store.acquiring
+
+	// The queue is not held up while the store is
+	statuses := make(chan QueueStatus)
+	go func() {
+		first.Done()
+		statuses <- q.Status()
+	}()
+	select {
+	case status := <-statuses:
+		if status.Running != 0 {
+			t.Fatalf("Expected the slot of the first run to be given back, got %+v", status)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the queue not to wait for the store")
+	}
+
+	close(store.unblock)
+	if !waitsFor(<-joined) {
+		t.Fatal("Expected the second run to go once the store answers")
+	}
+}
+
+// waitsFor tells whether the run has its turn within a few polls
+func waitsFor(ticket *QueueTicket) bool {
+	select {
+	case <-ticket.granted:
+		return true
+	case <-time.After(4 * clusterPollInterval):
+		return false
+	}
+}
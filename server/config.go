@@ -2,18 +2,90 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
+	"os"
+	"strings"
+	"time"
 )
 
 // Config is the configuration for up and running
 type Config struct {
-	LanguagesFile     string `json:"languages_file"`
-	Static            bool   `json:"static"`
-	RunnerThrottleNum int    `json:"runner_throttle_num"`
-	Port              int    `json:"port"`
+	LanguagesFile     string        `json:"languages_file"`
+	Static            bool          `json:"static"`
+	RunnerThrottleNum int           `json:"runner_throttle_num"` // Number of the runs at the same time
+	MaxQueueLength    int           `json:"max_queue_length"`    // Number of the runs waiting for their turn, none if negative
+	Port              int           `json:"port"`
+	MaxSourceSize     int64         `json:"max_source_size"`      // In bytes, of all the files together
+	MaxStdinSize      int64         `json:"max_stdin_size"`       // In bytes, of the stdin given with the run
+	MaxArgs           int           `json:"max_args"`             // Number of the program arguments
+	MaxArgsSize       int           `json:"max_args_size"`        // In bytes, of all the arguments together
+	MaxEnv            int           `json:"max_env"`              // Number of the environment variables
+	MaxEnvSize        int           `json:"max_env_size"`         // In bytes, of all the names and values together
+	MaxJudgeCases     int           `json:"max_judge_cases"`      // Number of the cases judged in one request
+	MaxSnippetSize    int64         `json:"max_snippet_size"`     // In bytes, of all the files of a snippet together
+	MaxRevisions      int           `json:"max_revisions"`        // Number of the revisions kept of a snippet
+	RunTTL            int           `json:"run_ttl_sec"`          // How long a run ticket is kept if the run is never started
+	SnippetTTL        int           `json:"snippet_ttl_sec"`      // How long a snippet is kept, forever if not set
+	JanitorInterval   int           `json:"janitor_interval_sec"` // How often the expired run tickets and snippets are reaped
+	PinToken          string        `json:"pin_token"`            // Needed to pin a snippet, nobody can if empty
+	RequireAPIKey     bool          `json:"require_api_key"`      // For registering runs, saving snippets and the v2 API
+	RateLimits        RateLimits    `json:"rate_limits"`          // By the routes, defaultRateLimits if not given
+	RealIPHeader      string        `json:"real_ip_header"`       // e.g. X-Forwarded-For behind a proxy
+	TrustedProxies    int           `json:"trusted_proxies"`      // Proxies adding to RealIPHeader, 1 by default
+	Store             string        `json:"store"`                // redis, or memory for a single server without Redis
+	Mode              string        `json:"mode"`                 // api or worker to split the server, both by default
+	Redis             RedisConfig   `json:"redis"`
+	Cluster           ClusterConfig `json:"cluster"`
 	Languages         *Languages
 }
 
+// ClusterConfig limits the runs across the servers sharing the store, rather
+// than by runner_throttle_num of every server
+type ClusterConfig struct {
+	Enabled       bool           `json:"enabled"`
+	DockerHost    string         `json:"docker_host"`    // Name of the Docker host of the server, see GetDockerHost
+	HostSlots     map[string]int `json:"host_slots"`     // Runs at the same time on a Docker host, runner_throttle_num if not given
+	LanguageSlots map[string]int `json:"language_slots"` // Runs of a language at the same time on all the hosts, unlimited if not given
+	Lease         int            `json:"lease_sec"`      // How long a slot is held by a server which is gone
+}
+
+// RateLimits are the limits of the routes, e.g. "register/" or "v2/runs"
+type RateLimits map[string]RouteLimit
+
+// RouteLimit limits a route by the IP, or by the API key when the request
+// has one. The route is not limited for an identity without a limit.
+type RouteLimit struct {
+	IP     *RateLimit `json:"ip"`
+	APIKey *RateLimit `json:"api_key"`
+}
+
+// RateLimit is a token bucket holding Burst requests, which gets PerMinute
+// requests back a minute
+type RateLimit struct {
+	Burst     int     `json:"burst"`
+	PerMinute float64 `json:"per_minute"`
+}
+
+// RedisConfig describes the connection to Redis. The master is asked from
+// the sentinels when SentinelAddresses are given, instead of Address.
+type RedisConfig struct {
+	Address           string   `json:"address"`
+	Password          string   `json:"password"`
+	DB                int      `json:"db"`
+	TLS               bool     `json:"tls"`
+	TLSSkipVerify     bool     `json:"tls_skip_verify"`
+	DialTimeout       int      `json:"dial_timeout_ms"`
+	ReadTimeout       int      `json:"read_timeout_ms"`
+	WriteTimeout      int      `json:"write_timeout_ms"`
+	MaxIdle           int      `json:"max_idle"`
+	MaxActive         int      `json:"max_active"` // Unlimited if 0
+	IdleTimeout       int      `json:"idle_timeout_sec"`
+	SentinelAddresses []string `json:"sentinel_addresses"`
+	SentinelMaster    string   `json:"sentinel_master"`
+	SentinelPassword  string   `json:"sentinel_password"`
+}
+
 // ReadConfigFile load config file from JSON into Config struct
 func ReadConfigFile(path string) (*Config, error) {
 	file, err := ioutil.ReadFile(path)
@@ -29,3 +101,240 @@ func ReadConfigFile(path string) (*Config, error) {
 	}
 	return &cfg, err
 }
+
+// GetMaxQueueLength returns how many runs can wait for their turn
+func (c *Config) GetMaxQueueLength() int {
+	if c.MaxQueueLength < 0 {
+		return 0
+	}
+	if c.MaxQueueLength != 0 {
+		return c.MaxQueueLength
+	}
+
+	return 20
+}
+
+// GetTrustedProxies returns how many proxies in front of the server add to
+// the RealIPHeader
+func (c *Config) GetTrustedProxies() int {
+	if c.TrustedProxies > 0 {
+		return c.TrustedProxies
+	}
+
+	return 1
+}
+
+// GetMaxSourceSize returns the max size of the source code in bytes
+func (c *Config) GetMaxSourceSize() int64 {
+	if c.MaxSourceSize != 0 {
+		return c.MaxSourceSize
+	}
+
+	return 512 * 1024
+}
+
+// GetMaxStdinSize returns the max size of the stdin given with a run in bytes
+func (c *Config) GetMaxStdinSize() int64 {
+	if c.MaxStdinSize != 0 {
+		return c.MaxStdinSize
+	}
+
+	return 1024 * 1024
+}
+
+// GetMaxArgs returns the max number of the program arguments
+func (c *Config) GetMaxArgs() int {
+	if c.MaxArgs != 0 {
+		return c.MaxArgs
+	}
+
+	return 32
+}
+
+// GetMaxArgsSize returns the max size of the program arguments in bytes
+func (c *Config) GetMaxArgsSize() int {
+	if c.MaxArgsSize != 0 {
+		return c.MaxArgsSize
+	}
+
+	return 4096
+}
+
+// GetMaxEnv returns the max number of the environment variables
+func (c *Config) GetMaxEnv() int {
+	if c.MaxEnv != 0 {
+		return c.MaxEnv
+	}
+
+	return 32
+}
+
+// GetMaxEnvSize returns the max size of the environment variables in bytes
+func (c *Config) GetMaxEnvSize() int {
+	if c.MaxEnvSize != 0 {
+		return c.MaxEnvSize
+	}
+
+	return 4096
+}
+
+// GetMaxJudgeCases returns the max number of the cases judged in one request
+func (c *Config) GetMaxJudgeCases() int {
+	if c.MaxJudgeCases != 0 {
+		return c.MaxJudgeCases
+	}
+
+	return 50
+}
+
+// GetMaxSnippetSize returns the max size of a snippet in bytes, the max size
+// of the source code by default
+func (c *Config) GetMaxSnippetSize() int64 {
+	if c.MaxSnippetSize != 0 {
+		return c.MaxSnippetSize
+	}
+
+	return c.GetMaxSourceSize()
+}
+
+// GetMaxRevisions returns the number of the revisions kept of a snippet
+func (c *Config) GetMaxRevisions() int {
+	if c.MaxRevisions != 0 {
+		return c.MaxRevisions
+	}
+
+	return 100
+}
+
+// GetRunTTL returns how long a run ticket is kept before it's reaped
+func (c *Config) GetRunTTL() time.Duration {
+	return durationOr(c.RunTTL, time.Second, 10*time.Minute)
+}
+
+// GetSnippetTTL returns how long a snippet is kept before it's reaped, or 0
+// when the snippets are kept forever
+func (c *Config) GetSnippetTTL() time.Duration {
+	if c.SnippetTTL <= 0 {
+		return 0
+	}
+
+	return time.Duration(c.SnippetTTL) * time.Second
+}
+
+// GetJanitorInterval returns how often the expired keys are reaped
+func (c *Config) GetJanitorInterval() time.Duration {
+	return durationOr(c.JanitorInterval, time.Second, time.Minute)
+}
+
+// GetRateLimits returns the limits of the routes, an empty rate_limits turns
+// the rate limiting off
+func (c *Config) GetRateLimits() RateLimits {
+	if c.RateLimits != nil {
+		return c.RateLimits
+	}
+
+	return defaultRateLimits
+}
+
+// GetStore returns the kind of the store, redis by default
+func (c *Config) GetStore() string {
+	if c.Store != "" {
+		return c.Store
+	}
+
+	return StoreRedis
+}
+
+// GetMode returns how the server is run, standalone by default
+func (c *Config) GetMode() string {
+	if c.Mode != "" {
+		return c.Mode
+	}
+
+	return ModeStandalone
+}
+
+// GetDockerHost returns the name the Docker host of the server is limited by.
+// It's DOCKER_HOST by default, or the host name of the server when Docker is
+// reached by its local socket.
+func (c *ClusterConfig) GetDockerHost() string {
+	if c.DockerHost != "" {
+		return c.DockerHost
+	}
+
+	if host := os.Getenv("DOCKER_HOST"); host != "" && !strings.HasPrefix(host, "unix://") {
+		return host
+	}
+
+	hostname, _ := os.Hostname()
+	return hostname
+}
+
+// GetHostSlots returns how many runs can go at the same time on the host
+func (c *Config) GetHostSlots(host string) int {
+	if slots, ok := c.Cluster.HostSlots[host]; ok {
+		return slots
+	}
+
+	return c.RunnerThrottleNum
+}
+
+// GetLease returns how long a slot is held without being renewed
+func (c *ClusterConfig) GetLease() time.Duration {
+	return durationOr(c.Lease, time.Second, 30*time.Second)
+}
+
+// GetAddress returns the address of Redis
+func (c *RedisConfig) GetAddress() string {
+	if c.Address != "" {
+		return c.Address
+	}
+
+	return ":6379"
+}
+
+// GetDialTimeout returns how long connecting to Redis can take
+func (c *RedisConfig) GetDialTimeout() time.Duration {
+	return durationOr(c.DialTimeout, time.Millisecond, 5*time.Second)
+}
+
+// GetReadTimeout returns how long reading a reply of Redis can take
+func (c *RedisConfig) GetReadTimeout() time.Duration {
+	return durationOr(c.ReadTimeout, time.Millisecond, 5*time.Second)
+}
+
+// GetWriteTimeout returns how long writing a command to Redis can take
+func (c *RedisConfig) GetWriteTimeout() time.Duration {
+	return durationOr(c.WriteTimeout, time.Millisecond, 5*time.Second)
+}
+
+// GetMaxIdle returns the max number of the idle connections in the pool
+func (c *RedisConfig) GetMaxIdle() int {
+	if c.MaxIdle != 0 {
+		return c.MaxIdle
+	}
+
+	return 16
+}
+
+// GetIdleTimeout returns how long a connection can be idle in the pool
+func (c *RedisConfig) GetIdleTimeout() time.Duration {
+	return durationOr(c.IdleTimeout, time.Second, 240*time.Second)
+}
+
+func durationOr(n int, unit, defaultDuration time.Duration) time.Duration {
+	if n != 0 {
+		return time.Duration(n) * unit
+	}
+
+	return defaultDuration
+}
+
+// describe tells where Redis is for the error messages
+func (c *RedisConfig) describe() string {
+	if len(c.SentinelAddresses) > 0 {
+		return fmt.Sprintf("master %s of sentinels %s", c.SentinelMaster, strings.Join(c.SentinelAddresses, ", "))
+	}
+
+	return c.GetAddress()
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// diffContext is the number of the unchanged lines around the changes
+const diffContext = 3
+
+// maxDiffLines and maxDiffEdits bound the work of comparing two files, which
+// grows with the lines by the edits, and its memory, which grows with the
+// square of the edits. Beyond them the whole file is told to be replaced.
+const (
+	maxDiffLines = 5000
+	maxDiffEdits = 1000
+)
+
+// diffLine is a line of the edit script: ' ' when kept, '-' when deleted
+// and '+' when inserted
+type diffLine struct {
+	op   byte
+	text string
+}
+
+// splitLines splits the text into its lines, without the line breaks
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
+
+// diffLines finds the shortest edit script turning a into b, by the Myers
+// algorithm on what is left once the common prefix and suffix are kept
+func diffLines(a, b []string) []diffLine {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	lines := make([]diffLine, 0, len(a)+len(b))
+	for _, text := range a[:prefix] {
+		lines = append(lines, diffLine{' ', text})
+	}
+	lines = append(lines, myers(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])...)
+	for _, text := range a[len(a)-suffix:] {
+		lines = append(lines, diffLine{' ', text})
+	}
+	return lines
+}
+
+func myers(a, b []string) []diffLine {
+	n, m := len(a), len(b)
+	if n > maxDiffLines || m > maxDiffLines {
+		return replaceLines(a, b)
+	}
+
+	max := n + m
+	offset := max + 1
+
+	// v holds the furthest x on every diagonal k, trace what it was before
+	// every round so the path can be walked back
+	v := make([]int, 2*max+3)
+	trace := [][]int{}
+
+	for d := 0; d <= max && d <= maxDiffEdits; d++ {
+		trace = append(trace, append([]int(nil), v[offset-d-1:offset+d+2]...))
+
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+
+			if x >= n && y >= m {
+				return backtrack(trace, a, b)
+			}
+		}
+	}
+
+	// Too different
+	return replaceLines(a, b)
+}
+
+// replaceLines is the edit script replacing every line of a by b
+func replaceLines(a, b []string) []diffLine {
+	lines := make([]diffLine, 0, len(a)+len(b))
+	for _, text := range a {
+		lines = append(lines, diffLine{'-', text})
+	}
+	for _, text := range b {
+		lines = append(lines, diffLine{'+', text})
+	}
+	return lines
+}
+
+func backtrack(trace [][]int, a, b []string) []diffLine {
+	x, y := len(a), len(b)
+	reversed := []diffLine{}
+
+	for d := len(trace) - 1; d >= 0; d-- {
+		// trace[d] covers the diagonals from -d-1 to d+1
+		at := func(k int) int { return trace[d][k+d+1] }
+		k := x - y
+
+		prevK := k - 1
+		if k == -d || (k != d && at(k-1) < at(k+1)) {
+			prevK = k + 1
+		}
+		prevX := at(prevK)
+		prevY := prevX - prevK
+
+		for x > prevX && y > prevY && x > 0 && y > 0 {
+			reversed = append(reversed, diffLine{' ', a[x-1]})
+			x--
+			y--
+		}
+
+		if d == 0 {
+			break
+		}
+
+		if x == prevX {
+			reversed = append(reversed, diffLine{'+', b[y-1]})
+			y--
+		} else {
+			reversed = append(reversed, diffLine{'-', a[x-1]})
+			x--
+		}
+	}
+
+	lines := make([]diffLine, len(reversed))
+	for i, line := range reversed {
+		lines[len(reversed)-1-i] = line
+	}
+	return lines
+}
+
+// unifiedDiff gives the hunks of the changes between a and b in the unified
+// format, without the file headers
+func unifiedDiff(a, b []string) string {
+	lines := diffLines(a, b)
+
+	// The lines of a and b before every line of the script
+	aBefore := make([]int, len(lines)+1)
+	bBefore := make([]int, len(lines)+1)
+	for i, line := range lines {
+		aBefore[i+1], bBefore[i+1] = aBefore[i], bBefore[i]
+		if line.op != '+' {
+			aBefore[i+1]++
+		}
+		if line.op != '-' {
+			bBefore[i+1]++
+		}
+	}
+
+	var buf bytes.Buffer
+	for i := 0; i < len(lines); {
+		if lines[i].op == ' ' {
+			i++
+			continue
+		}
+
+		// A hunk goes on while the next change is close enough
+		start := i - diffContext
+		if start < 0 {
+			start = 0
+		}
+
+		end := i
+		for j := i; j < len(lines) && j <= end+2*diffContext; j++ {
+			if lines[j].op != ' ' {
+				end = j
+			}
+		}
+		end += diffContext + 1
+		if end > len(lines) {
+			end = len(lines)
+		}
+
+		fmt.Fprintf(&buf, "@@ -%s +%s @@\n",
+			hunkRange(aBefore[start], aBefore[end]-aBefore[start]),
+			hunkRange(bBefore[start], bBefore[end]-bBefore[start]))
+		for _, line := range lines[start:end] {
+			buf.WriteByte(line.op)
+			buf.WriteString(line.text)
+			buf.WriteByte('\n')
+		}
+
+		i = end
+	}
+
+	return buf.String()
+}
+
+// hunkRange formats the lines of a hunk, which start after the line before
+func hunkRange(before, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", before)
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", before+1)
+	}
+	return fmt.Sprintf("%d,%d", before+1, count)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	a := splitLines("a\nb\nc\nd\ne\nf\ng\nh\ni\nj\n")
+	b := splitLines("a\nB\nc\nd\ne\nf\ng\nh\ni\nj\nk\n")
+
+	expected := "@@ -1,5 +1,5 @@\n a\n-b\n+B\n c\n d\n e\n" +
+		"@@ -8,3 +8,4 @@\n h\n i\n j\n+k\n"
+	if diff := unifiedDiff(a, b); diff != expected {
+		t.Fatalf("Unexpected diff\n%s", diff)
+	}
+
+	if diff := unifiedDiff(nil, splitLines("puts 1\n")); diff != "@@ -0,0 +1 @@\n+puts 1\n" {
+		t.Fatalf("Unexpected diff of an added file\n%s", diff)
+	}
+
+	if diff := unifiedDiff(a, a); diff != "" {
+		t.Fatalf("Expected no diff of the same lines, got\n%s", diff)
+	}
+}
+
+func TestDiffLinesIsShortest(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	randomLines := func() []string {
+		lines := make([]string, random.Intn(12))
+		for i := range lines {
+			lines[i] = string(rune('a' + random.Intn(3)))
+		}
+		return lines
+	}
+
+	for i := 0; i < 500; i++ {
+		a, b := randomLines(), randomLines()
+		lines := diffLines(a, b)
+
+		var gotA, gotB []string
+		edits := 0
+		for _, line := range lines {
+			if line.op != '+' {
+				gotA = append(gotA, line.text)
+			}
+			if line.op != '-' {
+				gotB = append(gotB, line.text)
+			}
+			if line.op != ' ' {
+				edits++
+			}
+		}
+
+		if strings.Join(gotA, "\n") != strings.Join(a, "\n") || strings.Join(gotB, "\n") != strings.Join(b, "\n") {
+			t.Fatalf("The diff of %v and %v doesn't give them back: %v", a, b, lines)
+		}
+
+		if shortest := len(a) + len(b) - 2*lcsLength(a, b); edits != shortest {
+			t.Fatalf("The diff of %v and %v has %d edits, expected %d", a, b, edits, shortest)
+		}
+	}
+}
+
+func TestDiffLinesBounded(t *testing.T) {
+	numbered := func(prefix string, n int) []string {
+		lines := make([]string, n)
+		for i := range lines {
+			lines[i] = fmt.Sprintf("%s%d", prefix, i)
+		}
+		return lines
+	}
+
+	cases := []struct{ a, b []string }{
+		// Too many edits
+		{numbered("a", maxDiffEdits), numbered("b", maxDiffEdits)},
+		// Too many lines, whatever the edits
+		{numbered("a", maxDiffLines+1), append(numbered("a", maxDiffLines+1)[1:], "b")},
+	}
+	for _, c := range cases {
+		lines := diffLines(c.a, c.b)
+		if len(lines) != len(c.a)+len(c.b) || lines[0].op != '-' || lines[len(lines)-1].op != '+' {
+			t.Fatalf("Expected every line of %d and %d lines to be replaced, got %d lines", len(c.a), len(c.b), len(lines))
+		}
+	}
+}
+
+func lcsLength(a, b []string) int {
+	lengths := make([][]int, len(a)+1)
+	for i := range lengths {
+		lengths[i] = make([]int, len(b)+1)
+	}
+
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lengths[i][j] = lengths[i+1][j+1] + 1
+			} else if lengths[i+1][j] > lengths[i][j+1] {
+				lengths[i][j] = lengths[i+1][j]
+			} else {
+				lengths[i][j] = lengths[i][j+1]
+			}
+		}
+	}
+	return lengths[0][0]
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"net/http"
+)
+
+// ErrEditForbidden is returned when a snippet is saved over someone else's
+var ErrEditForbidden = errors.New("the snippet can only be changed with its edit token")
+
+// newEditToken creates the secret that a new snippet can be changed with
+func newEditToken() (string, error) {
+	return newRandomHex(24)
+}
+
+// hashEditToken gives what is stored of the edit token, so the token cannot
+// be read back from the store
+func hashEditToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// editableWith tells whether the snippet can be changed with the token. The
+// snippets saved before the edit tokens have none and can only be forked.
+func (rnr *Runner) editableWith(token string) bool {
+	if rnr.EditTokenHash == "" || token == "" {
+		return false
+	}
+
+	hash := hashEditToken(token)
+	return subtle.ConstantTimeCompare([]byte(hash), []byte(rnr.EditTokenHash)) == 1
+}
+
+// snippetClaim tells where and as which revision a snippet is saved
+type snippetClaim struct {
+	ID         string
+	EditToken  string
+	Created    bool // EditToken is a new one
+	Revision   int
+	ForkedFrom *RevisionRef
+}
+
+// claimSnippet tells where the snippet is saved when codeID is asked for with
+// the edit token. A snippet is created with a new edit token when codeID is
+// empty or doesn't exist. The existing snippet gets a new revision if the
+// token is its own, or is forked under a new ID with fork, or
+// ErrEditForbidden is returned.
+func (s *Server) claimSnippet(codeID, token string, fork bool) (*snippetClaim, error) {
+	var forkedFrom *RevisionRef
+
+	if codeID != "" {
+		existing, err := s.store.FetchSnippet(codeID)
+		switch {
+		case err == ErrNotFound:
+			// A snippet which has expired but not been reaped yet leaves
+			// its revisions behind, they are not the new snippet's
+			if _, err := s.store.DeleteSnippet(codeID); err != nil {
+				return nil, err
+			}
+		case err != nil:
+			return nil, err
+		case existing.editableWith(token):
+			return &snippetClaim{
+				ID:         codeID,
+				EditToken:  token,
+				Revision:   existing.Revision + 1,
+				ForkedFrom: existing.ForkedFrom,
+			}, nil
+		case fork:
+			forkedFrom = &RevisionRef{ID: codeID, Revision: existing.revisionNumber()}
+			codeID = ""
+		default:
+			return nil, ErrEditForbidden
+		}
+	}
+
+	return newSnippetClaim(codeID, forkedFrom)
+}
+
+// newSnippetClaim claims a new snippet, under a new ID if codeID is empty
+func newSnippetClaim(codeID string, forkedFrom *RevisionRef) (*snippetClaim, error) {
+	if codeID == "" {
+		codeID = NewRandID(10)
+	}
+
+	editToken, err := newEditToken()
+	if err != nil {
+		return nil, err
+	}
+
+	return &snippetClaim{
+		ID:         codeID,
+		EditToken:  editToken,
+		Created:    true,
+		Revision:   1,
+		ForkedFrom: forkedFrom,
+	}, nil
+}
+
+// checkEditToken makes sure the snippet can be deleted with the token
+func (s *Server) checkEditToken(codeID, token string) error {
+	existing, err := s.store.FetchSnippet(codeID)
+	if err != nil {
+		return err
+	}
+
+	if !existing.editableWith(token) {
+		return ErrEditForbidden
+	}
+	return nil
+}
+
+// editTokenOf returns the edit token given by the X-Edit-Token header, or by
+// the editToken value of the form
+func editTokenOf(r *http.Request) string {
+	if token := r.Header.Get("X-Edit-Token"); token != "" {
+		return token
+	}
+	return r.FormValue("editToken")
+}
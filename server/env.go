@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// envDenylist are the variables the sandbox relies on, which cannot be
+// overridden by the program's environment
+var envDenylist = map[string]bool{
+	"PATH":            true,
+	"HOME":            true,
+	"HOSTNAME":        true,
+	"LD_PRELOAD":      true,
+	"LD_LIBRARY_PATH": true,
+	"GOPATH":          true,
+	"GOROOT":          true,
+	"C_PATH":          true,
+	"RUBY_PATH":       true,
+	"PYTHON_PATH":     true,
+	"SWIFT_PATH":      true,
+	"DOTNET_PATH":     true,
+	"FSHARP_PATH":     true,
+}
+
+var envNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// validateArgs checks the program arguments against the limits
+func validateArgs(args []string) error {
+	if max := appConfig.GetMaxArgs(); len(args) > max {
+		return fmt.Errorf("the program cannot have more than %d arguments", max)
+	}
+
+	size := 0
+	for _, arg := range args {
+		if strings.ContainsRune(arg, 0) {
+			return fmt.Errorf("the arguments cannot contain NUL characters")
+		}
+		size += len(arg)
+	}
+
+	if max := appConfig.GetMaxArgsSize(); size > max {
+		return fmt.Errorf("the arguments are larger than %d bytes", max)
+	}
+
+	return nil
+}
+
+// validateEnv checks the environment variables against the limits and
+// the denylist
+func validateEnv(env map[string]string) error {
+	if max := appConfig.GetMaxEnv(); len(env) > max {
+		return fmt.Errorf("the program cannot have more than %d environment variables", max)
+	}
+
+	size := 0
+	for name, value := range env {
+		if !envNamePattern.MatchString(name) {
+			return fmt.Errorf("%q is not a valid environment variable name", name)
+		}
+
+		if envDenylist[strings.ToUpper(name)] {
+			return fmt.Errorf("%s cannot be set", name)
+		}
+
+		if strings.ContainsRune(value, 0) {
+			return fmt.Errorf("the value of %s cannot contain NUL characters", name)
+		}
+		size += len(name) + len(value)
+	}
+
+	if max := appConfig.GetMaxEnvSize(); size > max {
+		return fmt.Errorf("the environment variables are larger than %d bytes", max)
+	}
+
+	return nil
+}
+
+// parseEnvPairs turns KEY=VAL pairs into the environment map
+func parseEnvPairs(pairs []string) (map[string]string, error) {
+	if len(pairs) == 0 {
+		return nil, nil
+	}
+
+	env := make(map[string]string, len(pairs))
+	for _, pair := range pairs {
+		idx := strings.Index(pair, "=")
+		if idx <= 0 {
+			return nil, fmt.Errorf("%q is not in the form of KEY=VAL", pair)
+		}
+		env[pair[:idx]] = pair[idx+1:]
+	}
+
+	return env, nil
+}
+
+// containerEnv turns the environment map into the KEY=VAL list of Docker
+func containerEnv(env map[string]string) []string {
+	pairs := make([]string, 0, len(env))
+	for name, value := range env {
+		pairs = append(pairs, name+"="+value)
+	}
+	return pairs
+}
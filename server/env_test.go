@@ -0,0 +1,46 @@
+package main
+
+import "testing"
+
+func TestValidateEnv(t *testing.T) {
+	appConfig = &Config{}
+	defer func() { appConfig = nil }()
+
+	if err := validateEnv(map[string]string{"DEBUG": "1", "name_2": "x"}); err != nil {
+		t.Fatalf("Environment should be valid - %v", err)
+	}
+
+	for _, name := range []string{"PATH", "ld_preload", "1ABC", "A-B", ""} {
+		if err := validateEnv(map[string]string{name: "x"}); err == nil {
+			t.Fatalf("%q should not be allowed", name)
+		}
+	}
+}
+
+func TestValidateArgs(t *testing.T) {
+	appConfig = &Config{MaxArgs: 2}
+	defer func() { appConfig = nil }()
+
+	if err := validateArgs([]string{"-n", "3"}); err != nil {
+		t.Fatalf("Arguments should be valid - %v", err)
+	}
+
+	if err := validateArgs([]string{"a", "b", "c"}); err == nil {
+		t.Fatal("Too many arguments should not be allowed")
+	}
+}
+
+func TestParseEnvPairs(t *testing.T) {
+	env, err := parseEnvPairs([]string{"A=1", "B=x=y", "C="})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if env["A"] != "1" || env["B"] != "x=y" || env["C"] != "" || len(env) != 3 {
+		t.Fatalf("Unexpected environment %v", env)
+	}
+
+	if _, err := parseEnvPairs([]string{"=1"}); err == nil {
+		t.Fatal("Pair without a name should not be allowed")
+	}
+}
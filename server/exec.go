@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"sync"
+)
+
+// ExecResult is the JSON document returned by the synchronous execution
+type ExecResult struct {
+	Stdout string `json:"stdout"`
+	Stderr string `json:"stderr"`
+	*RunResult
+}
+
+// syncBuffer is a bytes.Buffer that can be written from the output pipe
+// while the handler is waiting for the run.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// HandleExecV2 runs the code to completion and returns the whole output,
+// exit code and timing as one JSON document.
+func (s *Server) HandleExecV2(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeAPIError(w, http.StatusMethodNotAllowed, ErrCodeMethodNotAllowed, r.Method+" is not allowed here")
+		return
+	}
+
+	req, ok := decodeRunRequest(w, r)
+	if !ok {
+		return
+	}
+
+	runner := req.newRunner()
+	if runner.Stdin == nil {
+		empty := ""
+		runner.Stdin = &empty
+	}
+	runner.closeNotifier = w.(http.CloseNotifier).CloseNotify()
+	runner.logger = s.logger
+
+	if err := admit(runner); err != nil {
+		writeQueueFull(w, true)
+		return
+	}
+
+	keyRun, err := s.startKeyRun(apiKeyID(r))
+	if err != nil {
+		runner.ticket.Done()
+		s.writeKeyRunError(w, err)
+		return
+	}
+
+	var stdout, stderr syncBuffer
+	result := runner.Run(nil, &stdout, &stderr, newUUID())
+	keyRun.charge(runner, result)
+	s.finishKeyRun(keyRun)
+
+	if result.Reason == ReasonInternalError {
+		writeAPIError(w, http.StatusInternalServerError, ErrCodeInternal, "A serious error has occured.")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, ExecResult{
+		Stdout:    stdout.String(),
+		Stderr:    stderr.String(),
+		RunResult: result,
+	})
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// postExec runs the code through the handler served over HTTP, as it waits
+// for the client to go away
+func postExec(t *testing.T, s *Server, body string) *http.Response {
+	server := httptest.NewServer(http.HandlerFunc(s.HandleExecV2))
+	defer server.Close()
+
+	resp, err := http.Post(server.URL+"/api/v2/exec", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The body is read before the server goes
+	data, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = ioutil.NopCloser(bytes.NewReader(data))
+	return resp
+}
+
+func TestHandleExecV2(t *testing.T) {
+	s := newTestServer()
+	defer func() { Workers, appConfig = nil, nil }()
+	defer func(q *RunQueue) { Runqueue = q }(Runqueue)
+
+	Workers = s.store
+	Runqueue = NewRunQueue(1, 10)
+
+	// The worker runs the program with its whole stdin
+	go runJob(t, s.store, func(job *Job, publish func(string, []byte)) {
+		publish(StreamStdout, []byte(*job.Runner.Stdin))
+		publish(StreamExit, []byte(`{"exit_code": 0, "reason": "exited"}`))
+	})
+
+	body := `{"lang": "ruby", "source": "puts gets", "stdin": "1 2\n"}`
+	resp := postExec(t, s, body)
+	defer resp.Body.Close()
+
+	var result ExecResult
+	json.NewDecoder(resp.Body).Decode(&result)
+	if resp.StatusCode != http.StatusOK || result.RunResult == nil || result.Reason != ReasonExited || result.Stdout != "1 2\n" {
+		t.Fatalf("Expected the output of the run, got %d - %+v", resp.StatusCode, result)
+	}
+}
+
+func TestHandleExecV2InternalError(t *testing.T) {
+	s := newTestServer()
+	defer func() { appConfig, DockerClient = nil, nil }()
+
+	server, _ := fakeDocker(t, func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Docker is down", http.StatusInternalServerError)
+	})
+	defer server.Close()
+
+	resp := postExec(t, s, `{"lang": "ruby", "source": "puts 1"}`)
+	defer resp.Body.Close()
+
+	var apiErr map[string]APIError
+	json.NewDecoder(resp.Body).Decode(&apiErr)
+	if resp.StatusCode != http.StatusInternalServerError || apiErr["error"].Code != ErrCodeInternal {
+		t.Fatalf("Expected 500 with internal_error, got %d - %+v", resp.StatusCode, apiErr)
+	}
+}
+
+func TestHandleExecV2MethodNotAllowed(t *testing.T) {
+	s := newTestServer()
+	defer func() { appConfig = nil }()
+
+	w := httptest.NewRecorder()
+	s.HandleExecV2(w, httptest.NewRequest(http.MethodGet, "/api/v2/exec", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("Expected 405, got %d", w.Code)
+	}
+}
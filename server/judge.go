@@ -0,0 +1,271 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Verdicts of a judged case
+const (
+	VerdictAccepted      = "AC"
+	VerdictWrongAnswer   = "WA"
+	VerdictTimeLimit     = "TLE"
+	VerdictMemoryLimit   = "MLE"
+	VerdictRuntimeError  = "RE"
+	VerdictOutputLimit   = "OLE"
+	VerdictInternalError = "IE"
+)
+
+// Comparators of the output and the expected output
+const (
+	ComparatorExact      = "exact"
+	ComparatorWhitespace = "whitespace"
+	ComparatorFloat      = "float"
+)
+
+// defaultTolerance is used by the float comparator when it's not given
+const defaultTolerance = 1e-6
+
+// JudgeCase is an input and the output it is expected to produce
+type JudgeCase struct {
+	Name     string `json:"name"`
+	Stdin    string `json:"stdin"`
+	Expected string `json:"expected"`
+}
+
+// JudgeRequest is the JSON body to judge the code against the cases
+type JudgeRequest struct {
+	RunRequest
+	Cases      []JudgeCase `json:"cases"`
+	Comparator string      `json:"comparator,omitempty"` // exact by default
+	Tolerance  float64     `json:"tolerance,omitempty"`  // Of the float comparator
+}
+
+// CaseResult is the verdict of a single case
+type CaseResult struct {
+	Name     string `json:"name"`
+	Verdict  string `json:"verdict"`
+	ExitCode int    `json:"exit_code"`
+	WallTime int64  `json:"wall_time_ms"`
+	RunTime  int64  `json:"run_time_ms"`
+	Stdout   string `json:"stdout,omitempty"` // Only given when the case is not accepted
+	Stderr   string `json:"stderr,omitempty"` // Only given when the case is not accepted
+}
+
+// JudgeReport is the JSON document returned by the judge, its verdict is the
+// verdict of the first case not accepted.
+type JudgeReport struct {
+	Verdict string       `json:"verdict"`
+	Passed  int          `json:"passed"`
+	Total   int          `json:"total"`
+	Cases   []CaseResult `json:"cases"`
+}
+
+// HandleJudgeV2 runs the code once per case with the input of the case, and
+// tells whether the output is the expected one.
+func (s *Server) HandleJudgeV2(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeAPIError(w, http.StatusMethodNotAllowed, ErrCodeMethodNotAllowed, r.Method+" is not allowed here")
+		return
+	}
+
+	// Every case has its own stdin and expected output
+	r.Body = http.MaxBytesReader(w, r.Body, maxBodySize(2*appConfig.GetMaxJudgeCases()))
+
+	var req JudgeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); isBodyTooLarge(err) {
+		writeBodyTooLarge(w)
+		return
+	} else if err != nil {
+		writeAPIError(w, http.StatusBadRequest, ErrCodeInvalidJSON, "The request body is not valid JSON")
+		return
+	}
+
+	if !validateRunRequest(w, &req.RunRequest) {
+		return
+	}
+
+	if err := validateCases(&req); err != nil {
+		writeAPIError(w, http.StatusUnprocessableEntity, ErrCodeInvalidCases, err.Error())
+		return
+	}
+
+	// The judge is admitted by its first case, the others wait for their
+	// turn whatever the queue length
+	ticket, err := Runqueue.Join(req.Lang, false)
+	if err != nil {
+		writeQueueFull(w, true)
+		return
+	}
+
+	// The cases are counted as one run of the API key
+	keyRun, err := s.startKeyRun(apiKeyID(r))
+	if err != nil {
+		ticket.Done()
+		s.writeKeyRunError(w, err)
+		return
+	}
+	defer s.finishKeyRun(keyRun)
+
+	closeNotifier := w.(http.CloseNotifier).CloseNotify()
+	report := JudgeReport{Verdict: VerdictAccepted, Total: len(req.Cases)}
+
+	for _, c := range req.Cases {
+		stdin := c.Stdin
+		runner := req.newRunner()
+		runner.Stdin = &stdin
+		runner.closeNotifier = closeNotifier
+		runner.logger = s.logger
+		runner.ticket, ticket = ticket, nil
+
+		var stdout, stderr syncBuffer
+		result := runner.Run(nil, &stdout, &stderr, newUUID())
+		keyRun.charge(runner, result)
+		if result.Reason == ReasonCancelled {
+			// Nobody is waiting for the report
+			return
+		}
+
+		caseResult := CaseResult{
+			Name:     c.Name,
+			Verdict:  verdict(result, req.match(stdout.String(), c.Expected)),
+			ExitCode: result.ExitCode,
+			WallTime: result.WallTime,
+			RunTime:  result.RunTime,
+		}
+
+		if caseResult.Verdict == VerdictAccepted {
+			report.Passed++
+		} else {
+			caseResult.Stdout = stdout.String()
+			caseResult.Stderr = stderr.String()
+			if report.Verdict == VerdictAccepted {
+				report.Verdict = caseResult.Verdict
+			}
+		}
+
+		report.Cases = append(report.Cases, caseResult)
+	}
+
+	writeJSON(w, http.StatusOK, report)
+}
+
+// validateCases checks the number of the cases, the size of their input and
+// the comparator.
+func validateCases(req *JudgeRequest) error {
+	if len(req.Cases) == 0 {
+		return fmt.Errorf("no case is given")
+	}
+
+	if max := appConfig.GetMaxJudgeCases(); len(req.Cases) > max {
+		return fmt.Errorf("cannot judge more than %d cases", max)
+	}
+
+	for i, c := range req.Cases {
+		if c.Name == "" {
+			req.Cases[i].Name = strconv.Itoa(i + 1)
+		}
+
+		if max := appConfig.GetMaxStdinSize(); int64(len(c.Stdin)) > max {
+			return fmt.Errorf("the stdin of case %s is larger than %d bytes", req.Cases[i].Name, max)
+		}
+	}
+
+	switch req.Comparator {
+	case "":
+		req.Comparator = ComparatorExact
+	case ComparatorExact, ComparatorWhitespace, ComparatorFloat:
+	default:
+		return fmt.Errorf("%s is not a comparator, use exact, whitespace or float", req.Comparator)
+	}
+
+	if req.Tolerance < 0 {
+		return fmt.Errorf("the tolerance cannot be negative")
+	}
+	if req.Tolerance == 0 {
+		req.Tolerance = defaultTolerance
+	}
+
+	return nil
+}
+
+// verdict tells the verdict of a case by how the run has finished and
+// whether the output matches
+func verdict(result *RunResult, matched bool) string {
+	switch {
+	case result.Reason == ReasonTimeout:
+		return VerdictTimeLimit
+	case result.Reason == ReasonOOM:
+		return VerdictMemoryLimit
+	case result.Reason == ReasonOutputLimit:
+		return VerdictOutputLimit
+	case result.Reason != ReasonExited:
+		return VerdictInternalError
+	case result.ExitCode != 0:
+		return VerdictRuntimeError
+	case !matched:
+		return VerdictWrongAnswer
+	}
+
+	return VerdictAccepted
+}
+
+// match compares the output with the expected output by the comparator of
+// the request
+func (req *JudgeRequest) match(output, expected string) bool {
+	switch req.Comparator {
+	case ComparatorWhitespace:
+		return matchWhitespace(output, expected)
+	case ComparatorFloat:
+		return matchFloat(output, expected, req.Tolerance)
+	}
+
+	return output == expected
+}
+
+// matchWhitespace compares the outputs token by token, so the amount and
+// kind of whitespace between them doesn't matter
+func matchWhitespace(output, expected string) bool {
+	got, want := strings.Fields(output), strings.Fields(expected)
+	if len(got) != len(want) {
+		return false
+	}
+
+	for i := range got {
+		if got[i] != want[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// matchFloat compares the outputs token by token like matchWhitespace, but
+// numbers only need to be within the absolute or relative tolerance
+func matchFloat(output, expected string, tolerance float64) bool {
+	got, want := strings.Fields(output), strings.Fields(expected)
+	if len(got) != len(want) {
+		return false
+	}
+
+	for i := range got {
+		if got[i] == want[i] {
+			continue
+		}
+
+		a, errA := strconv.ParseFloat(got[i], 64)
+		b, errB := strconv.ParseFloat(want[i], 64)
+		if errA != nil || errB != nil {
+			return false
+		}
+
+		diff := math.Abs(a - b)
+		if diff > tolerance && diff > tolerance*math.Max(math.Abs(a), math.Abs(b)) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestJudgeMatch(t *testing.T) {
+	tests := []struct {
+		comparator string
+		output     string
+		expected   string
+		matched    bool
+	}{
+		{ComparatorExact, "1 2\n", "1 2\n", true},
+		{ComparatorExact, "1 2", "1 2\n", false},
+		{ComparatorWhitespace, "1  2\n\n", "1 2\n", true},
+		{ComparatorWhitespace, "1 2 3", "1 2", false},
+		{ComparatorFloat, "0.3333333 yes\n", "0.33333333 yes", true},
+		{ComparatorFloat, "1000000.5", "1000000.4", true},
+		{ComparatorFloat, "1000.5", "1000.4", false},
+		{ComparatorFloat, "0.34 yes", "0.33 yes", false},
+		{ComparatorFloat, "1.0 no", "1.0 yes", false},
+	}
+
+	for _, test := range tests {
+		req := &JudgeRequest{Comparator: test.comparator, Tolerance: defaultTolerance}
+		if matched := req.match(test.output, test.expected); matched != test.matched {
+			t.Fatalf("%s comparator: %q and %q should match: %v", test.comparator, test.output, test.expected, test.matched)
+		}
+	}
+}
+
+func TestVerdict(t *testing.T) {
+	tests := []struct {
+		result  RunResult
+		matched bool
+		verdict string
+	}{
+		{RunResult{Reason: ReasonExited}, true, VerdictAccepted},
+		{RunResult{Reason: ReasonExited}, false, VerdictWrongAnswer},
+		{RunResult{Reason: ReasonExited, ExitCode: 1}, true, VerdictRuntimeError},
+		{RunResult{Reason: ReasonTimeout, ExitCode: 137}, false, VerdictTimeLimit},
+		{RunResult{Reason: ReasonOOM, ExitCode: 137}, false, VerdictMemoryLimit},
+		{RunResult{Reason: ReasonOutputLimit, ExitCode: -1}, false, VerdictOutputLimit},
+		{RunResult{Reason: ReasonInternalError, ExitCode: -1}, false, VerdictInternalError},
+	}
+
+	for _, test := range tests {
+		if v := verdict(&test.result, test.matched); v != test.verdict {
+			t.Fatalf("Expected %s for %+v, got %s", test.verdict, test.result, v)
+		}
+	}
+}
+
+func TestValidateCases(t *testing.T) {
+	appConfig = &Config{MaxJudgeCases: 2}
+	defer func() { appConfig = nil }()
+
+	req := &JudgeRequest{Cases: []JudgeCase{{Stdin: "1"}, {Name: "big", Stdin: "2"}}}
+	if err := validateCases(req); err != nil {
+		t.Fatalf("Cases should be valid - %v", err)
+	}
+
+	if req.Cases[0].Name != "1" || req.Comparator != ComparatorExact || req.Tolerance != defaultTolerance {
+		t.Fatalf("Unexpected defaults %+v", req)
+	}
+
+	req = &JudgeRequest{Cases: []JudgeCase{{}, {}, {}}}
+	if err := validateCases(req); err == nil {
+		t.Fatal("Too many cases should not be allowed")
+	}
+
+	req = &JudgeRequest{Cases: []JudgeCase{{}}, Comparator: "fuzzy"}
+	if err := validateCases(req); err == nil {
+		t.Fatal("Unknown comparator should not be allowed")
+	}
+}
+
+func TestJudgeV2BodyTooLarge(t *testing.T) {
+	s := newTestServer()
+	defer func() { appConfig = nil }()
+	appConfig.MaxSourceSize = 10
+	appConfig.MaxStdinSize = 8
+	appConfig.MaxJudgeCases = 2
+
+	cases := `[{"stdin": "1", "expected": "` + strings.Repeat("1", 128<<10) + `"}]`
+	body := `{"lang": "ruby", "source": "puts 1", "cases": ` + cases + `}`
+	if status, apiErr := serveV2(s.HandleJudgeV2, http.MethodPost, "/api/v2/judge", body, nil); status != http.StatusRequestEntityTooLarge || apiErr.Code != ErrCodeBodyTooLarge {
+		t.Fatalf("Expected 413 body_too_large, got %d %+v", status, apiErr)
+	}
+}
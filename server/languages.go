@@ -8,9 +8,29 @@ import (
 // Language gives the specification of a programming language
 type Language struct {
 	Versions  []string
+	Timeout   int // In seconds
 	CPUQuota  int64
 	Memory    int64
 	PidsLimit int64
+	// A run is stopped once its output is larger or faster than these
+	MaxOutput     int64 // In bytes, of stdout and stderr together
+	MaxOutputRate int64 // In bytes per second
+	// The most a run can ask for
+	MaxTimeout   int
+	MaxCPUQuota  int64
+	MaxMemory    int64
+	MaxPidsLimit int64
+	// Profiles are named limits a run can ask for, e.g. small or large
+	Profiles   map[string]Limits
+	WorkDir    string // Working directory of the image, where the source files go
+	SourceFile string // File name of the source code when it's not a project
+	// CheckCmd only compiles the code, it's run by sh with the entry file as $1
+	CheckCmd string
+	// DiagnosticFormat tells how the output of CheckCmd is parsed, gcc or msbuild
+	DiagnosticFormat string
+	// WarmPool is the number of containers created ahead of the runs by the
+	// versions
+	WarmPool map[string]int
 }
 
 // Languages tells languages specifications
@@ -28,6 +48,15 @@ func ReadLanuagesFile(path string) (*Languages, error) {
 	return &langs, err
 }
 
+// GetTimeout returns the timeout of the given language in seconds
+func (l *Language) GetTimeout() int {
+	if l.Timeout != 0 {
+		return l.Timeout
+	}
+
+	return 15
+}
+
 // GetCPUQuota returns CPUQuota of the given language
 func (l *Language) GetCPUQuota() int64 {
 	if l.CPUQuota != 0 {
@@ -54,3 +83,69 @@ func (l *Language) GetPidsLimit() int64 {
 
 	return 100
 }
+
+// GetMaxOutput returns the most output in bytes a run of the given language
+// can write out
+func (l *Language) GetMaxOutput() int64 {
+	if l.MaxOutput != 0 {
+		return l.MaxOutput
+	}
+
+	return 1024 * 1024
+}
+
+// GetMaxOutputRate returns how many bytes a run of the given language can
+// write out per second
+func (l *Language) GetMaxOutputRate() int64 {
+	if l.MaxOutputRate != 0 {
+		return l.MaxOutputRate
+	}
+
+	return 256 * 1024
+}
+
+// GetMaxTimeout returns the longest timeout a run of the given language can
+// ask for
+func (l *Language) GetMaxTimeout() int {
+	if l.MaxTimeout != 0 {
+		return l.MaxTimeout
+	}
+
+	return int(maxInt64(60, int64(l.GetTimeout())))
+}
+
+// GetMaxCPUQuota returns the largest CPUQuota a run of the given language can
+// ask for
+func (l *Language) GetMaxCPUQuota() int64 {
+	if l.MaxCPUQuota != 0 {
+		return l.MaxCPUQuota
+	}
+
+	return maxInt64(100000, l.GetCPUQuota())
+}
+
+// GetMaxMemory returns the most memory a run of the given language can ask for
+func (l *Language) GetMaxMemory() int64 {
+	if l.MaxMemory != 0 {
+		return l.MaxMemory
+	}
+
+	return maxInt64(512*1024*1024, l.GetMemory())
+}
+
+// GetMaxPidsLimit returns the most processes a run of the given language can
+// ask for
+func (l *Language) GetMaxPidsLimit() int64 {
+	if l.MaxPidsLimit != 0 {
+		return l.MaxPidsLimit
+	}
+
+	return maxInt64(1000, l.GetPidsLimit())
+}
+
+func maxInt64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+)
+
+// Limits are the resources a run can use. Requested limits left as zero are
+// taken from the profile, or else the defaults of the language.
+type Limits struct {
+	Profile   string `json:"profile,omitempty"`
+	Timeout   int    `json:"timeout,omitempty"`   // In seconds
+	CPUQuota  int64  `json:"cpu_quota,omitempty"` // In microseconds of every 100ms
+	Memory    int64  `json:"memory,omitempty"`    // In bytes
+	PidsLimit int64  `json:"pids_limit,omitempty"`
+}
+
+// LimitExceededError is returned when a run asks for more than the language
+// allows
+type LimitExceededError struct {
+	Resource  string
+	Requested int64
+	Max       int64
+}
+
+func (e *LimitExceededError) Error() string {
+	return fmt.Sprintf("the %s of %d exceeds the maximum of %d", e.Resource, e.Requested, e.Max)
+}
+
+// ResolveLimits returns the limits of a run asking for the requested ones,
+// or an error when they cannot be given.
+func (l *Language) ResolveLimits(requested Limits) (Limits, error) {
+	limits := Limits{
+		Timeout:   l.GetTimeout(),
+		CPUQuota:  l.GetCPUQuota(),
+		Memory:    l.GetMemory(),
+		PidsLimit: l.GetPidsLimit(),
+	}
+
+	if requested.Profile != "" {
+		profile, ok := l.Profiles[requested.Profile]
+		if !ok {
+			return limits, fmt.Errorf("%s is not a profile of the language", requested.Profile)
+		}
+		limits.override(profile)
+		limits.Profile = requested.Profile
+	}
+
+	if requested.Timeout < 0 || requested.CPUQuota < 0 || requested.Memory < 0 || requested.PidsLimit < 0 {
+		return limits, fmt.Errorf("the limits cannot be negative")
+	}
+	limits.override(requested)
+
+	checks := []LimitExceededError{
+		{"timeout", int64(limits.Timeout), int64(l.GetMaxTimeout())},
+		{"cpu_quota", limits.CPUQuota, l.GetMaxCPUQuota()},
+		{"memory", limits.Memory, l.GetMaxMemory()},
+		{"pids_limit", limits.PidsLimit, l.GetMaxPidsLimit()},
+	}
+	for _, check := range checks {
+		if check.Requested > check.Max {
+			return limits, &check
+		}
+	}
+
+	return limits, nil
+}
+
+// override replaces the limits given by other
+func (limits *Limits) override(other Limits) {
+	if other.Timeout != 0 {
+		limits.Timeout = other.Timeout
+	}
+	if other.CPUQuota != 0 {
+		limits.CPUQuota = other.CPUQuota
+	}
+	if other.Memory != 0 {
+		limits.Memory = other.Memory
+	}
+	if other.PidsLimit != 0 {
+		limits.PidsLimit = other.PidsLimit
+	}
+}
+
+// formLimits reads the requested limits from the "profile", "timeout",
+// "cpu_quota", "memory" and "pids_limit" values of the form
+func formLimits(form url.Values) (Limits, error) {
+	limits := Limits{Profile: form.Get("profile")}
+
+	ints := map[string]*int64{
+		"cpu_quota":  &limits.CPUQuota,
+		"memory":     &limits.Memory,
+		"pids_limit": &limits.PidsLimit,
+	}
+
+	var timeout int64
+	ints["timeout"] = &timeout
+
+	for name, value := range ints {
+		if form.Get(name) == "" {
+			continue
+		}
+
+		n, err := strconv.ParseInt(form.Get(name), 10, 64)
+		if err != nil {
+			return limits, fmt.Errorf("%s is not a number", name)
+		}
+		*value = n
+	}
+
+	limits.Timeout = int(timeout)
+	return limits, nil
+}
+
+// applyLimits gives the resolved limits to the runner
+func (rnr *Runner) applyLimits(limits Limits) {
+	rnr.Timeout = limits.Timeout
+	rnr.Limits = &limits
+}
+
+// resources are the limits of the container. The defaults of the language
+// are used for runs registered without limits.
+func (rnr *Runner) resources() Limits {
+	if rnr.Limits != nil {
+		return *rnr.Limits
+	}
+
+	lang := (*appConfig.Languages)[rnr.Lang]
+	limits, _ := lang.ResolveLimits(Limits{})
+	return limits
+}
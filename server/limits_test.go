@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestResolveLimits(t *testing.T) {
+	lang := Language{
+		MaxMemory: 256 * 1024 * 1024,
+		Profiles: map[string]Limits{
+			"large": {Timeout: 60, Memory: 200 * 1024 * 1024},
+		},
+	}
+
+	limits, err := lang.ResolveLimits(Limits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if limits.Timeout != 15 || limits.Memory != lang.GetMemory() || limits.CPUQuota != lang.GetCPUQuota() {
+		t.Fatalf("Expected the defaults of the language, got %+v", limits)
+	}
+
+	limits, err = lang.ResolveLimits(Limits{Profile: "large", Timeout: 30})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if limits.Timeout != 30 || limits.Memory != 200*1024*1024 || limits.PidsLimit != lang.GetPidsLimit() {
+		t.Fatalf("Expected the large profile with the timeout overridden, got %+v", limits)
+	}
+
+	_, err = lang.ResolveLimits(Limits{Memory: 512 * 1024 * 1024})
+	if e, ok := err.(*LimitExceededError); !ok || e.Resource != "memory" {
+		t.Fatalf("Expected the memory to exceed the maximum, got %v", err)
+	}
+
+	if _, err = lang.ResolveLimits(Limits{Profile: "huge"}); err == nil {
+		t.Fatal("Unknown profile should not be allowed")
+	}
+
+	if _, err = lang.ResolveLimits(Limits{Timeout: -1}); err == nil {
+		t.Fatal("Negative limits should not be allowed")
+	}
+}
+
+func TestFormLimits(t *testing.T) {
+	limits, err := formLimits(url.Values{"profile": {"small"}, "timeout": {"20"}, "memory": {"1048576"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := Limits{Profile: "small", Timeout: 20, Memory: 1048576}
+	if limits != expected {
+		t.Fatalf("Expected %+v, got %+v", expected, limits)
+	}
+
+	if _, err := formLimits(url.Values{"memory": {"256m"}}); err == nil {
+		t.Fatal("Memory should be given in bytes")
+	}
+}
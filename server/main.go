@@ -1,25 +1,90 @@
 package main
 
-import "flag"
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+)
 
 var appConfig *Config
 
-func init() {
-	var configPath string
+func main() {
+	var configPath, mode string
 	flag.StringVar(&configPath, "config", "config.json", "Configuration for the Koderunr")
+	flag.StringVar(&mode, "mode", "", "api or worker to override the mode of the configuration")
 	flag.Parse()
 
 	var err error
 	appConfig, err = ReadConfigFile(configPath)
-	DockerClient, err = NewDockerClient()
 	if err != nil {
 		panic(err)
 	}
-}
 
-func main() {
-	Runnerthrottle = make(chan struct{}, appConfig.RunnerThrottleNum)
+	if flag.Arg(0) == "keys" {
+		os.Exit(manageKeys(flag.Args()[1:]))
+	}
 
-	s := NewServer(16, appConfig.Static)
+	if mode != "" {
+		appConfig.Mode = mode
+	}
+	mode = appConfig.GetMode()
+	switch mode {
+	case ModeStandalone, ModeAPI, ModeWorker:
+	default:
+		log.Fatalf("KodeRunr cannot start: %s is not a mode, use api or worker", mode)
+	}
+	if mode != ModeStandalone && appConfig.GetStore() != StoreRedis {
+		log.Fatalf("KodeRunr cannot start: the %s mode needs the redis store", mode)
+	}
+
+	// The API servers don't touch Docker
+	if mode != ModeAPI {
+		DockerClient, err = NewDockerClient()
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	Runqueue = NewRunQueue(appConfig.RunnerThrottleNum, appConfig.GetMaxQueueLength())
+
+	store, err := NewStore(appConfig)
+	if err != nil {
+		log.Fatalf("KodeRunr cannot start: %v", err)
+	}
+
+	s := NewServer(store, appConfig.Static)
+	if appConfig.Cluster.Enabled && mode != ModeAPI {
+		Runqueue.EnableCluster(newClusterSlots(store, appConfig.Cluster, s.logger))
+	}
+
+	if mode != ModeAPI {
+		Warmpool = NewWarmPool(*appConfig.Languages, s.logger)
+		if Warmpool != nil {
+			go Warmpool.Maintain()
+		}
+	}
+
+	if mode == ModeWorker {
+		go s.ServeMetrics(appConfig.Port)
+		s.Work(appConfig.RunnerThrottleNum)
+		return
+	}
+	if mode == ModeAPI {
+		Workers = store
+		Runqueue.EnableWorkers(store)
+	}
+
+	go s.runJanitor(appConfig.GetJanitorInterval())
 	s.Serve("/api/", appConfig.Port)
 }
+
+// manageKeys runs the keys command against the store of the config
+func manageKeys(args []string) int {
+	store, err := NewStore(appConfig)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "KodeRunr cannot reach its store: %v\n", err)
+		return 1
+	}
+	return runKeysCommand(store, args, os.Stdout)
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Buckets of the histograms, in seconds
+var (
+	latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+	runBuckets     = []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 15, 30, 60, 120}
+)
+
+// metrics are exposed at /metrics in the Prometheus text format
+var metrics = struct {
+	runs             *metricVec
+	runSeconds       *metricVec
+	queueWaitSeconds *metricVec
+	containerSeconds *metricVec
+	stdinMessages    *metricVec
+	outputBytes      *metricVec
+	snippetSaves     *metricVec
+	redisErrors      *metricVec
+}{
+	runs:             newCounter("koderunr_runs_total", "Runs by how they have finished.", "lang", "version", "outcome"),
+	runSeconds:       newHistogram("koderunr_run_duration_seconds", "Time from a run being requested until it has finished.", runBuckets, "lang", "version"),
+	queueWaitSeconds: newHistogram("koderunr_queue_wait_seconds", "Time a run has waited for its turn.", runBuckets),
+	containerSeconds: newHistogram("koderunr_container_operation_seconds", "Latency of the Docker operations on the containers.", latencyBuckets, "operation"),
+	stdinMessages:    newCounter("koderunr_stdin_messages_total", "Stdin messages handed to the running programs.", "transport"),
+	outputBytes:      newCounter("koderunr_output_bytes_total", "Bytes written out by the programs.", "stream"),
+	snippetSaves:     newCounter("koderunr_snippet_saves_total", "Snippets and their revisions saved.", "result"),
+	redisErrors:      newCounter("koderunr_redis_errors_total", "Failed Redis commands and connections."),
+}
+
+// metricVec is a counter or a histogram, with a series by the values of its
+// labels
+type metricVec struct {
+	name    string
+	help    string
+	kind    string // counter or histogram
+	labels  []string
+	buckets []float64
+	mu      sync.Mutex
+	series  map[string]*series // By the label values joined by \xff
+}
+
+type series struct {
+	labels []string
+	counts []uint64 // Of the buckets, not cumulative
+	sum    float64
+	count  uint64
+}
+
+func newCounter(name, help string, labels ...string) *metricVec {
+	return &metricVec{name: name, help: help, kind: "counter", labels: labels, series: map[string]*series{}}
+}
+
+func newHistogram(name, help string, buckets []float64, labels ...string) *metricVec {
+	return &metricVec{name: name, help: help, kind: "histogram", labels: labels, buckets: buckets, series: map[string]*series{}}
+}
+
+// Add adds v to the counter, or observes v by the histogram
+func (m *metricVec) Add(v float64, labels ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := strings.Join(labels, "\xff")
+	s := m.series[key]
+	if s == nil {
+		s = &series{labels: labels, counts: make([]uint64, len(m.buckets))}
+		m.series[key] = s
+	}
+
+	s.sum += v
+	s.count++
+	for i, le := range m.buckets {
+		if v <= le {
+			s.counts[i]++
+			break
+		}
+	}
+}
+
+// Inc adds 1 to the counter
+func (m *metricVec) Inc(labels ...string) {
+	m.Add(1, labels...)
+}
+
+// Since observes the seconds since t by the histogram
+func (m *metricVec) Since(t time.Time, labels ...string) {
+	m.Add(time.Since(t).Seconds(), labels...)
+}
+
+// write writes the series out in the text format, sorted by their labels
+func (m *metricVec) write(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.kind)
+
+	keys := make([]string, 0, len(m.series))
+	for key := range m.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		s := m.series[key]
+		if m.kind == "counter" {
+			fmt.Fprintf(w, "%s%s %s\n", m.name, labelPairs(m.labels, s.labels), formatFloat(s.sum))
+			continue
+		}
+
+		names := append(append([]string{}, m.labels...), "le")
+		values := append(append([]string{}, s.labels...), "")
+
+		var cumulative uint64
+		for i, le := range m.buckets {
+			cumulative += s.counts[i]
+			values[len(values)-1] = formatFloat(le)
+			fmt.Fprintf(w, "%s_bucket%s %d\n", m.name, labelPairs(names, values), cumulative)
+		}
+		values[len(values)-1] = "+Inf"
+		fmt.Fprintf(w, "%s_bucket%s %d\n", m.name, labelPairs(names, values), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", m.name, labelPairs(m.labels, s.labels), formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", m.name, labelPairs(m.labels, s.labels), s.count)
+	}
+}
+
+// writeGauge writes a gauge without labels out in the text format
+func writeGauge(w io.Writer, name, help string, v float64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %s\n", name, help, name, name, formatFloat(v))
+}
+
+// labelPairs formats the labels as {name="value",...}, escaping the values
+func labelPairs(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		value := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(values[i])
+		pairs[i] = fmt.Sprintf(`%s="%s"`, name, value)
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// HandleMetrics writes the metrics out in the Prometheus text format, along
+// with the gauges of the run queue and the warm containers
+func (s *Server) HandleMetrics(w http.ResponseWriter, r *http.Request) {
+	var b bytes.Buffer
+
+	for _, m := range []*metricVec{
+		metrics.runs,
+		metrics.runSeconds,
+		metrics.queueWaitSeconds,
+		metrics.containerSeconds,
+		metrics.stdinMessages,
+		metrics.outputBytes,
+		metrics.snippetSaves,
+		metrics.redisErrors,
+	} {
+		m.write(&b)
+	}
+
+	queue := Runqueue.Status()
+	writeGauge(&b, "koderunr_runner_slots", "Runs which can go at the same time.", float64(queue.Slots))
+	writeGauge(&b, "koderunr_runners_busy", "Runs holding a slot.", float64(queue.Running))
+	if queue.Slots > 0 {
+		writeGauge(&b, "koderunr_runner_utilisation", "Share of the slots held by the runs.", float64(queue.Running)/float64(queue.Slots))
+	}
+	writeGauge(&b, "koderunr_runs_queued", "Runs waiting for their turn.", float64(queue.Queued))
+
+	if pools := Warmpool.Status(); len(pools) > 0 {
+		ready := newGaugeVec("koderunr_warm_containers", "Warm containers ready to be taken.", "lang", "version")
+		hits := newCounter("koderunr_warm_pool_hits_total", "Runs which took a warm container.", "lang", "version")
+		misses := newCounter("koderunr_warm_pool_misses_total", "Runs of a pooled version which created their own container.", "lang", "version")
+		for _, pool := range pools {
+			ready.Add(float64(pool.Ready), pool.Lang, pool.Version)
+			hits.Add(float64(pool.Hits), pool.Lang, pool.Version)
+			misses.Add(float64(pool.Misses), pool.Lang, pool.Version)
+		}
+		ready.write(&b)
+		hits.write(&b)
+		misses.write(&b)
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write(b.Bytes())
+}
+
+// newGaugeVec is a gauge with labels, written out like a counter
+func newGaugeVec(name, help string, labels ...string) *metricVec {
+	m := newCounter(name, help, labels...)
+	m.kind = "gauge"
+	return m
+}
+
+// ServeMetrics serves only /metrics, for the workers which don't serve the API
+func (s *Server) ServeMetrics(port int) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", s.recoverMiddleWare(http.HandlerFunc(s.HandleMetrics)))
+	if err := http.ListenAndServe(fmt.Sprintf(":%d", port), mux); err != nil {
+		s.logger.Errorf("Metrics cannot be served - %v", err)
+	}
+}
+
+// metricLabels returns the language and version of the run as labels, those
+// which are not in the languages of the config are unknown so the requests
+// cannot add series at will
+func (rnr *Runner) metricLabels() (string, string) {
+	lang, ok := (*appConfig.Languages)[rnr.Lang]
+	if !ok {
+		return "unknown", "unknown"
+	}
+
+	if rnr.Version == "" {
+		return rnr.Lang, rnr.version()
+	}
+	for _, version := range lang.Versions {
+		if version == rnr.Version {
+			return rnr.Lang, version
+		}
+	}
+	return rnr.Lang, "unknown"
+}
+
+// countingWriter counts the bytes written through it by the stream
+type countingWriter struct {
+	w      io.Writer
+	stream string
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	metrics.outputBytes.Add(float64(n), cw.stream)
+	return n, err
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetricVecWrite(t *testing.T) {
+	counter := newCounter("runs_total", "Runs.", "lang", "outcome")
+	counter.Inc("ruby", "exited")
+	counter.Inc("ruby", "exited")
+	counter.Inc("go", `say "hi"`)
+
+	var b bytes.Buffer
+	counter.write(&b)
+
+	expected := `# HELP runs_total Runs.
+# TYPE runs_total counter
+runs_total{lang="go",outcome="say \"hi\""} 1
+runs_total{lang="ruby",outcome="exited"} 2
+`
+	if b.String() != expected {
+		t.Fatalf("Expected the counter to be\n%s\ngot\n%s", expected, b.String())
+	}
+
+	histogram := newHistogram("wait_seconds", "Waits.", []float64{0.5, 1})
+	histogram.Add(0.2)
+	histogram.Add(0.7)
+	histogram.Add(3)
+
+	b.Reset()
+	histogram.write(&b)
+
+	expected = `# HELP wait_seconds Waits.
+# TYPE wait_seconds histogram
+wait_seconds_bucket{le="0.5"} 1
+wait_seconds_bucket{le="1"} 2
+wait_seconds_bucket{le="+Inf"} 3
+wait_seconds_sum 3.9
+wait_seconds_count 3
+`
+	if b.String() != expected {
+		t.Fatalf("Expected the histogram to be\n%s\ngot\n%s", expected, b.String())
+	}
+}
+
+func TestHandleMetrics(t *testing.T) {
+	s := newTestServer()
+	defer func() { appConfig = nil }()
+
+	metrics.stdinMessages.Inc("websocket")
+
+	w := httptest.NewRecorder()
+	s.HandleMetrics(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	body := w.Body.String()
+	for _, line := range []string{
+		"# TYPE koderunr_runs_total counter",
+		"# TYPE koderunr_queue_wait_seconds histogram",
+		`koderunr_stdin_messages_total{transport="websocket"}`,
+		"koderunr_runner_slots 1",
+		"koderunr_runs_queued 0",
+	} {
+		if !strings.Contains(body, line) {
+			t.Fatalf("Expected %q in the metrics, got\n%s", line, body)
+		}
+	}
+}
+
+func TestRunMetricLabels(t *testing.T) {
+	newTestServer()
+	defer func() { appConfig = nil }()
+	(*appConfig.Languages)["ruby"] = Language{Versions: []string{"2.7", "3.0"}}
+
+	for _, c := range []struct {
+		lang, version, expectedLang, expectedVersion string
+	}{
+		{"ruby", "", "ruby", "2.7"},
+		{"ruby", "3.0", "ruby", "3.0"},
+		{"ruby", "9.9", "ruby", "unknown"},
+		{"cobol", "1", "unknown", "unknown"},
+		{"rand-0x1f", "", "unknown", "unknown"},
+	} {
+		lang, version := (&Runner{Lang: c.lang, Version: c.version}).metricLabels()
+		if lang != c.expectedLang || version != c.expectedVersion {
+			t.Fatalf("Expected %s %s to be labelled %s %s, got %s %s", c.lang, c.version, c.expectedLang, c.expectedVersion, lang, version)
+		}
+	}
+}
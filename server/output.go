@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// outputLimiter counts the output of a run across stdout and stderr. Once
+// the output is larger or faster than allowed, the rest of it is dropped and
+// the run is told to stop.
+type outputLimiter struct {
+	mu        sync.Mutex
+	max       int64 // In bytes
+	rate      int64 // In bytes per second
+	startedAt time.Time
+	written   int64
+	exceeded  chan struct{} // closed once a limit is exceeded
+	reason    string
+	now       func() time.Time
+}
+
+func newOutputLimiter(max, rate int64) *outputLimiter {
+	return &outputLimiter{
+		max:       max,
+		rate:      rate,
+		startedAt: time.Now(),
+		exceeded:  make(chan struct{}),
+		now:       time.Now,
+	}
+}
+
+// Exceeded is closed once the output has exceeded a limit
+func (l *outputLimiter) Exceeded() <-chan struct{} {
+	return l.exceeded
+}
+
+// Reason tells which limit has been exceeded
+func (l *outputLimiter) Reason() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.reason
+}
+
+// Writer limits the output written to w
+func (l *outputLimiter) Writer(w io.Writer) io.Writer {
+	return &limitedWriter{limiter: l, w: w}
+}
+
+// allow returns how much of n bytes can be written out
+func (l *outputLimiter) allow(n int) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.reason != "" {
+		return 0
+	}
+
+	allowed := int64(n)
+	if l.written+allowed > l.max {
+		allowed = l.max - l.written
+		l.exceed(fmt.Sprintf("more than %d bytes", l.max))
+	}
+
+	// A second worth of output can be written out at once
+	elapsed := l.now().Sub(l.startedAt).Seconds()
+	if l.reason == "" && float64(l.written+allowed) > float64(l.rate)*(elapsed+1) {
+		l.exceed(fmt.Sprintf("faster than %d bytes per second", l.rate))
+	}
+
+	l.written += allowed
+	return int(allowed)
+}
+
+func (l *outputLimiter) exceed(reason string) {
+	l.reason = reason
+	close(l.exceeded)
+}
+
+type limitedWriter struct {
+	limiter *outputLimiter
+	w       io.Writer
+}
+
+// Write drops whatever exceeds the limit, without failing the copy of the
+// output so the remaining output is drained
+func (lw *limitedWriter) Write(p []byte) (int, error) {
+	n := lw.limiter.allow(len(p))
+	if n > 0 {
+		if _, err := lw.w.Write(p[:n]); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
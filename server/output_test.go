@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestOutputLimiterSize(t *testing.T) {
+	limiter := newOutputLimiter(8, 1024)
+
+	var stdout, stderr bytes.Buffer
+	limiter.Writer(&stdout).Write([]byte("hello"))
+	n, err := limiter.Writer(&stderr).Write([]byte("world"))
+	if n != 5 || err != nil {
+		t.Fatalf("The dropped output should not fail the write, got %d - %v", n, err)
+	}
+
+	if stdout.String() != "hello" || stderr.String() != "wor" {
+		t.Fatalf("Expected the output to be cut at 8 bytes, got %q and %q", stdout.String(), stderr.String())
+	}
+
+	select {
+	case <-limiter.Exceeded():
+	default:
+		t.Fatal("The limit should be exceeded")
+	}
+
+	limiter.Writer(&stdout).Write([]byte("more"))
+	if stdout.String() != "hello" {
+		t.Fatalf("Nothing should be written out after the limit, got %q", stdout.String())
+	}
+}
+
+func TestOutputLimiterRate(t *testing.T) {
+	limiter := newOutputLimiter(1024, 10)
+	now := limiter.startedAt
+	limiter.now = func() time.Time { return now }
+
+	var out bytes.Buffer
+	w := limiter.Writer(&out)
+
+	w.Write([]byte("0123456789"))
+	now = now.Add(time.Second)
+	w.Write([]byte("0123456789"))
+	if limiter.Reason() != "" {
+		t.Fatalf("10 bytes per second should be allowed, got %s", limiter.Reason())
+	}
+
+	w.Write([]byte("0123456789"))
+	if limiter.Reason() == "" {
+		t.Fatal("The rate should be exceeded")
+	}
+}
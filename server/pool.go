@@ -0,0 +1,232 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/network"
+)
+
+// warmLabel marks the warm containers with the host name of their server
+const warmLabel = "koderunr.warm"
+
+// warmPoolInterval is how often the pools are topped up, besides whenever a
+// container is claimed
+const warmPoolInterval = 10 * time.Second
+
+// Warmpool keeps containers created ahead of the runs, it's nil when no
+// language has a pool or the server doesn't run the code
+var Warmpool *WarmPool
+
+// WarmPool keeps a pool of created containers by the language versions, so a
+// run fitting a pool only copies its source into a warm container and starts
+// it. A warm container is used by a single run, and removed after it like
+// any other container.
+type WarmPool struct {
+	mu     sync.Mutex
+	pools  map[string]*warmPool // By the images
+	owner  string
+	logger *logrus.Logger
+	create func(pool *warmPool) (string, error)
+}
+
+// warmPool holds the containers of a language version, created like the
+// container of a run with the default limits, without arguments or
+// environment variables and without a TTY
+type warmPool struct {
+	lang       string
+	version    string
+	size       int
+	config     *container.Config
+	hostConfig *container.HostConfig
+	ready      []string // IDs of the containers, oldest first
+	creating   int
+	hits       int64
+	misses     int64
+}
+
+// PoolStatus tells how a pool of warm containers is doing
+type PoolStatus struct {
+	Lang    string `json:"lang"`
+	Version string `json:"version"`
+	Size    int    `json:"size"`
+	Ready   int    `json:"ready"`
+	Hits    int64  `json:"hits"`   // Runs which took a warm container
+	Misses  int64  `json:"misses"` // Runs of the version which created their own
+}
+
+// NewWarmPool creates the pools by the WarmPool sizes of the languages, or
+// returns nil if there is none
+func NewWarmPool(langs Languages, logger *logrus.Logger) *WarmPool {
+	owner, _ := os.Hostname()
+	p := &WarmPool{pools: map[string]*warmPool{}, owner: owner, logger: logger}
+	p.create = p.createContainer
+
+	for name, lang := range langs {
+		for version, size := range lang.WarmPool {
+			if size <= 0 {
+				continue
+			}
+
+			template := &Runner{Lang: name, Version: version}
+			config, hostConfig := template.containerConfig()
+			p.pools[config.Image] = &warmPool{
+				lang:       name,
+				version:    version,
+				size:       size,
+				config:     config,
+				hostConfig: hostConfig,
+			}
+		}
+	}
+
+	if len(p.pools) == 0 {
+		return nil
+	}
+	return p
+}
+
+// Maintain removes the warm containers left behind by a previous server on
+// the host, and keeps the pools topped up
+func (p *WarmPool) Maintain() {
+	p.removeLeftovers()
+
+	for {
+		for _, pool := range p.pools {
+			p.fill(pool)
+		}
+		time.Sleep(warmPoolInterval)
+	}
+}
+
+// claim takes a warm container for the run if it would be created the same
+// way, and tops the pool up in the background
+func (p *WarmPool) claim(rnr *Runner) (string, bool) {
+	if p == nil {
+		return "", false
+	}
+
+	config, hostConfig := rnr.containerConfig()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	pool := p.pools[config.Image]
+	if pool == nil {
+		return "", false
+	}
+	defer func() { go p.fill(pool) }()
+
+	fits := reflect.DeepEqual(config, pool.config) && reflect.DeepEqual(hostConfig, pool.hostConfig)
+	if !fits || len(pool.ready) == 0 {
+		pool.misses++
+		return "", false
+	}
+
+	containerID := pool.ready[0]
+	pool.ready = pool.ready[1:]
+	pool.hits++
+	return containerID, true
+}
+
+// fill creates containers until the pool has its size
+func (p *WarmPool) fill(pool *warmPool) {
+	for {
+		p.mu.Lock()
+		if len(pool.ready)+pool.creating >= pool.size {
+			p.mu.Unlock()
+			return
+		}
+		pool.creating++
+		p.mu.Unlock()
+
+		containerID, err := p.create(pool)
+
+		p.mu.Lock()
+		pool.creating--
+		if err == nil {
+			pool.ready = append(pool.ready, containerID)
+		}
+		p.mu.Unlock()
+
+		if err != nil {
+			// Tried again by Maintain
+			p.logger.Errorf("Warm container of %s %s cannot be created - %v", pool.lang, pool.version, err)
+			return
+		}
+	}
+}
+
+func (p *WarmPool) createContainer(pool *warmPool) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	config := *pool.config
+	config.Labels = map[string]string{warmLabel: p.owner}
+
+	defer metrics.containerSeconds.Since(time.Now(), "create")
+	ctr, err := DockerClient.ContainerCreate(ctx, &config, pool.hostConfig, &network.NetworkingConfig{}, "warm-"+newUUID())
+	if err != nil {
+		return "", err
+	}
+	return ctr.ID, nil
+}
+
+// removeLeftovers removes the warm containers of the host which are not in
+// the pools, as they were created by a server that has stopped
+func (p *WarmPool) removeLeftovers() {
+	args := filters.NewArgs()
+	args.Add("label", warmLabel+"="+p.owner)
+
+	ctrs, err := DockerClient.ContainerList(context.Background(), types.ContainerListOptions{All: true, Filters: args})
+	if err != nil {
+		p.logger.Errorf("Warm containers left behind cannot be listed - %v", err)
+		return
+	}
+
+	for _, ctr := range ctrs {
+		DockerClient.ContainerRemove(context.Background(), ctr.ID, types.ContainerRemoveOptions{Force: true})
+	}
+	if len(ctrs) > 0 {
+		p.logger.Infof("Removed %d warm containers left behind", len(ctrs))
+	}
+}
+
+// Status tells how the pools are doing, by the languages and versions
+func (p *WarmPool) Status() []PoolStatus {
+	if p == nil {
+		return nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	statuses := make([]PoolStatus, 0, len(p.pools))
+	for _, pool := range p.pools {
+		statuses = append(statuses, PoolStatus{
+			Lang:    pool.lang,
+			Version: pool.version,
+			Size:    pool.size,
+			Ready:   len(pool.ready),
+			Hits:    pool.hits,
+			Misses:  pool.misses,
+		})
+	}
+
+	sort.Slice(statuses, func(i, j int) bool {
+		if statuses[i].Lang != statuses[j].Lang {
+			return statuses[i].Lang < statuses[j].Lang
+		}
+		return statuses[i].Version < statuses[j].Version
+	})
+	return statuses
+}
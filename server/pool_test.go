@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+func newTestPool(t *testing.T) *WarmPool {
+	appConfig = &Config{Languages: &Languages{
+		"ruby": {Versions: []string{"2.3.1", "2.2.5"}, SourceFile: "main.rb", WarmPool: map[string]int{"2.3.1": 2}},
+		"go":   {Versions: []string{"1.7.0"}, SourceFile: "main.go"},
+	}}
+
+	logger := logrus.New()
+	logger.Out = ioutil.Discard
+
+	p := NewWarmPool(*appConfig.Languages, logger)
+	if p == nil {
+		t.Fatal("Expected a pool of ruby")
+	}
+
+	var created int32
+	p.create = func(pool *warmPool) (string, error) {
+		return fmt.Sprintf("%s-%d", pool.lang, atomic.AddInt32(&created, 1)), nil
+	}
+	return p
+}
+
+func TestWarmPoolClaim(t *testing.T) {
+	p := newTestPool(t)
+	defer func() { appConfig = nil }()
+
+	if _, ok := p.claim(&Runner{Lang: "ruby"}); ok {
+		t.Fatal("Expected nothing to be claimed from an empty pool")
+	}
+	// The miss tops the pool up
+	for i := 0; i < 1000 && p.Status()[0].Ready < 2; i++ {
+		time.Sleep(time.Millisecond)
+	}
+
+	// The timeout is not a part of the container
+	id, ok := p.claim(&Runner{Lang: "ruby", Source: "puts 1", Limits: &Limits{Timeout: 30, CPUQuota: 20000, Memory: 80 * 1024 * 1024, PidsLimit: 100}})
+	if !ok || id == "" {
+		t.Fatalf("Expected a warm container, got %q", id)
+	}
+	if id2, _ := p.claim(&Runner{Lang: "ruby", Version: "2.3.1"}); id2 == id {
+		t.Fatalf("Expected the warm container not to be claimed twice, got %q", id2)
+	}
+
+	misses := []*Runner{
+		{Lang: "ruby", Args: []string{"-v"}},
+		{Lang: "ruby", Env: map[string]string{"A": "1"}},
+		{Lang: "ruby", tty: true},
+		{Lang: "ruby", Limits: &Limits{Memory: 1024}},
+	}
+	for _, rnr := range misses {
+		if id, ok := p.claim(rnr); ok {
+			t.Fatalf("Expected %+v not to fit the pool, got %q", rnr, id)
+		}
+	}
+
+	for _, rnr := range []*Runner{{Lang: "ruby", Version: "2.2.5"}, {Lang: "go"}} {
+		if _, ok := p.claim(rnr); ok {
+			t.Fatalf("Expected no pool of %s %s", rnr.Lang, rnr.Version)
+		}
+	}
+
+	status := p.Status()
+	if len(status) != 1 || status[0].Hits != 2 || status[0].Misses != 5 || status[0].Size != 2 {
+		t.Fatalf("Expected 2 hits and 5 misses of ruby 2.3.1, got %+v", status)
+	}
+}
+
+func TestWarmPoolNone(t *testing.T) {
+	if p := NewWarmPool(Languages{"go": {}}, nil); p != nil {
+		t.Fatal("Expected no pool without sizes")
+	}
+
+	var p *WarmPool
+	if _, ok := p.claim(&Runner{Lang: "go"}); ok || p.Status() != nil {
+		t.Fatal("Expected the nil pool to be empty")
+	}
+}
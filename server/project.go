@@ -0,0 +1,273 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/docker/docker/api/types"
+)
+
+// maxProjectFiles is the max number of files in a project
+const maxProjectFiles = 100
+
+// validateProject checks the file tree of a project and its entry file
+func validateProject(files map[string]string, entry string) error {
+	if len(files) > maxProjectFiles {
+		return fmt.Errorf("a project cannot have more than %d files", maxProjectFiles)
+	}
+
+	for name := range files {
+		if err := validateProjectPath(name); err != nil {
+			return err
+		}
+	}
+
+	if entry == "" {
+		return fmt.Errorf("the entry file is not given")
+	}
+
+	if _, ok := files[entry]; !ok {
+		return fmt.Errorf("the entry file %s is not in the project", entry)
+	}
+
+	return nil
+}
+
+// validateProjectPath makes sure the path stays inside the working directory
+func validateProjectPath(name string) error {
+	if name == "" || path.IsAbs(name) || path.Clean(name) != name || name == "." ||
+		name == ".." || strings.HasPrefix(name, "../") {
+		return fmt.Errorf("%q is not a valid path in the project", name)
+	}
+
+	return nil
+}
+
+// tarProject packs the files of a project into a tar archive
+func tarProject(files map[string]string) (io.Reader, error) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	dirs := map[string]bool{}
+	now := time.Now()
+
+	for name, content := range files {
+		// Parent directories go first so they can be extracted
+		for dir := path.Dir(name); dir != "." && !dirs[dir]; dir = path.Dir(dir) {
+			dirs[dir] = true
+			hdr := &tar.Header{Name: dir + "/", Mode: 0755, Typeflag: tar.TypeDir, ModTime: now}
+			if err := tw.WriteHeader(hdr); err != nil {
+				return nil, err
+			}
+		}
+
+		hdr := &tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), ModTime: now}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return nil, err
+		}
+
+		if _, err := io.WriteString(tw, content); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+
+	return &buf, nil
+}
+
+// extractProject reads the files of an uploaded tar, tar.gz or zip archive.
+// The format is told by the file name of the archive.
+func extractProject(fileName string, r io.Reader) (map[string]string, error) {
+	switch {
+	case strings.HasSuffix(fileName, ".zip"):
+		return extractZip(r)
+	case strings.HasSuffix(fileName, ".tar.gz"), strings.HasSuffix(fileName, ".tgz"):
+		gr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		defer gr.Close()
+		return extractTar(gr)
+	case strings.HasSuffix(fileName, ".tar"):
+		return extractTar(r)
+	}
+
+	return nil, fmt.Errorf("%s is not a tar, tar.gz or zip archive", fileName)
+}
+
+// extractTar reads the files of a tar archive. It stops as soon as there are
+// too many files or they are larger than the source code can be.
+func extractTar(r io.Reader) (map[string]string, error) {
+	files := map[string]string{}
+	tr := tar.NewReader(r)
+	var total int64
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return files, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeRegA {
+			continue
+		}
+
+		if len(files) == maxProjectFiles {
+			return nil, fmt.Errorf("a project cannot have more than %d files", maxProjectFiles)
+		}
+
+		content, err := readProjectFile(tr, &total)
+		if err != nil {
+			return nil, err
+		}
+		files[strings.TrimPrefix(hdr.Name, "./")] = content
+	}
+}
+
+// extractZip reads the files of a zip archive, with the same limits as
+// extractTar
+func extractZip(r io.Reader) (map[string]string, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+
+	files := map[string]string{}
+	max := appConfig.GetMaxSourceSize()
+	var total int64
+
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+
+		if len(files) == maxProjectFiles {
+			return nil, fmt.Errorf("a project cannot have more than %d files", maxProjectFiles)
+		}
+
+		// The size told by the archive is checked first, but can't be trusted
+		if f.UncompressedSize64 > uint64(max) {
+			return nil, fmt.Errorf("the source code is larger than %d bytes", max)
+		}
+
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		content, err := readProjectFile(rc, &total)
+		rc.Close()
+		if err != nil {
+			return nil, err
+		}
+		files[f.Name] = content
+	}
+
+	return files, nil
+}
+
+// readProjectFile reads a file of an archive and adds its size to the total.
+// No more than the max size of the source code is ever read.
+func readProjectFile(r io.Reader, total *int64) (string, error) {
+	max := appConfig.GetMaxSourceSize()
+
+	content, err := ioutil.ReadAll(io.LimitReader(r, max-*total+1))
+	if err != nil {
+		return "", err
+	}
+
+	*total += int64(len(content))
+	if *total > max {
+		return "", fmt.Errorf("the source code is larger than %d bytes", max)
+	}
+
+	return string(content), nil
+}
+
+// sourceFiles returns the files to be copied into the container and the
+// entry file. The source of a single file run is named after the language.
+func (rnr *Runner) sourceFiles() (map[string]string, string) {
+	if rnr.isProject() {
+		return rnr.Files, rnr.Entry
+	}
+
+	name := (*appConfig.Languages)[rnr.Lang].SourceFile
+	return map[string]string{name: rnr.Source}, name
+}
+
+// sourceSize is the total size of the source files
+func (rnr *Runner) sourceSize() int64 {
+	size := int64(len(rnr.Source))
+	for _, content := range rnr.Files {
+		size += int64(len(content))
+	}
+	return size
+}
+
+// checkSourceSize makes sure the source code is not larger than allowed
+func checkSourceSize(rnr *Runner) error {
+	if max := appConfig.GetMaxSourceSize(); rnr.sourceSize() > max {
+		return fmt.Errorf("the source code is larger than %d bytes", max)
+	}
+	return nil
+}
+
+// checkSnippetSize makes sure the snippet is not larger than allowed
+func checkSnippetSize(rnr *Runner) error {
+	if max := appConfig.GetMaxSnippetSize(); rnr.sourceSize() > max {
+		return fmt.Errorf("the snippet is larger than %d bytes", max)
+	}
+	return nil
+}
+
+// checkStdinSize makes sure the given stdin is not larger than allowed
+func checkStdinSize(rnr *Runner) error {
+	if rnr.Stdin == nil {
+		return nil
+	}
+
+	if max := appConfig.GetMaxStdinSize(); int64(len(*rnr.Stdin)) > max {
+		return fmt.Errorf("the stdin is larger than %d bytes", max)
+	}
+	return nil
+}
+
+// copySource copies the source files into the working directory of the
+// container byte for byte, which has to be done before the container is
+// started.
+func (rnr *Runner) copySource() error {
+	lang := (*appConfig.Languages)[rnr.Lang]
+	if lang.WorkDir == "" || lang.SourceFile == "" {
+		return fmt.Errorf("the WorkDir and SourceFile of %s are not configured", rnr.Lang)
+	}
+
+	files, _ := rnr.sourceFiles()
+	archive, err := tarProject(files)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	return DockerClient.CopyToContainer(ctx, rnr.containerID, lang.WorkDir, archive, types.CopyToContainerOptions{})
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValidateProject(t *testing.T) {
+	files := map[string]string{"main.go": "package main", "lib/lib.go": "package lib"}
+	if err := validateProject(files, "main.go"); err != nil {
+		t.Fatalf("Project should be valid - %v", err)
+	}
+
+	if err := validateProject(files, "other.go"); err == nil {
+		t.Fatal("Entry file outside of the project should not be valid")
+	}
+
+	for _, name := range []string{"/etc/passwd", "../main.go", "lib/../../main.go", "./main.go", ""} {
+		if err := validateProjectPath(name); err == nil {
+			t.Fatalf("%q should not be a valid path", name)
+		}
+	}
+}
+
+func TestTarProject(t *testing.T) {
+	appConfig = &Config{}
+	defer func() { appConfig = nil }()
+
+	files := map[string]string{"main.c": "#include \"lib/lib.h\"", "lib/lib.h": "int x;\\n"}
+
+	archive, err := tarProject(files)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	extracted, err := extractTar(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(files, extracted) {
+		t.Fatalf("Extracted files %v are not the same as %v", extracted, files)
+	}
+}
+
+func TestExtractArchiveBomb(t *testing.T) {
+	appConfig = &Config{MaxSourceSize: 1024}
+	defer func() { appConfig = nil }()
+
+	// 16MB of zeros which compress to a few KB
+	zeros := make([]byte, 16<<20)
+
+	var tgz bytes.Buffer
+	gw := gzip.NewWriter(&tgz)
+	tw := tar.NewWriter(gw)
+	tw.WriteHeader(&tar.Header{Name: "main.rb", Mode: 0644, Size: int64(len(zeros))})
+	tw.Write(zeros)
+	tw.Close()
+	gw.Close()
+
+	if _, err := extractProject("bomb.tar.gz", &tgz); err == nil || !strings.Contains(err.Error(), "larger than 1024 bytes") {
+		t.Fatalf("Expected the tar.gz to be too large, got %v", err)
+	}
+
+	var zipped bytes.Buffer
+	zw := zip.NewWriter(&zipped)
+	w, _ := zw.Create("main.rb")
+	w.Write(zeros)
+	zw.Close()
+
+	if _, err := extractProject("bomb.zip", &zipped); err == nil || !strings.Contains(err.Error(), "larger than 1024 bytes") {
+		t.Fatalf("Expected the zip to be too large, got %v", err)
+	}
+
+	// The sizes told by an archive can't be trusted, so no more is read
+	// than allowed
+	var total int64
+	if _, err := readProjectFile(bytes.NewReader(zeros), &total); err == nil || total != 1025 {
+		t.Fatalf("Expected to stop after 1025 bytes, read %d - %v", total, err)
+	}
+}
+
+func TestExtractTooManyFiles(t *testing.T) {
+	appConfig = &Config{}
+	defer func() { appConfig = nil }()
+
+	files := map[string]string{}
+	for i := 0; i <= maxProjectFiles; i++ {
+		files[fmt.Sprintf("%d.rb", i)] = "puts 1"
+	}
+
+	archive, err := tarProject(files)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := extractTar(archive); err == nil {
+		t.Fatalf("Expected more than %d files to be refused", maxProjectFiles)
+	}
+}
+
+func TestCheckSourceSize(t *testing.T) {
+	appConfig = &Config{MaxSourceSize: 10}
+	defer func() { appConfig = nil }()
+
+	if err := checkSourceSize(&Runner{Source: "puts 1"}); err != nil {
+		t.Fatalf("Source code should be small enough - %v", err)
+	}
+
+	runner := &Runner{Files: map[string]string{"main.rb": "require './a'", "a.rb": "puts 1"}}
+	if err := checkSourceSize(runner); err == nil {
+		t.Fatal("Source code should be too large")
+	}
+}
+
+func TestCheckStdinSize(t *testing.T) {
+	appConfig = &Config{MaxStdinSize: 4}
+	defer func() { appConfig = nil }()
+
+	if err := checkStdinSize(&Runner{}); err != nil {
+		t.Fatalf("An interactive stdin should not be checked - %v", err)
+	}
+
+	stdin := "1 2\n"
+	if err := checkStdinSize(&Runner{Stdin: &stdin}); err != nil {
+		t.Fatalf("Stdin should be small enough - %v", err)
+	}
+
+	stdin = "1 2 3\n"
+	if err := checkStdinSize(&Runner{Stdin: &stdin}); err == nil {
+		t.Fatal("Stdin should be too large")
+	}
+}
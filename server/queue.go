@@ -0,0 +1,419 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ErrQueueFull is returned when a run cannot even wait for its turn
+var ErrQueueFull = errors.New("the run queue is full")
+
+// defaultRunEstimate is how long a run is guessed to hold its slot before
+// any run has finished
+const defaultRunEstimate = 5 * time.Second
+
+// Runqueue hands the slots of the runs out in the order they are asked for,
+// it's sized by the config when the server starts
+var Runqueue = NewRunQueue(1, 0)
+
+// RunQueue lets a number of runs go at the same time, and keeps the others
+// waiting in a queue of a bounded length. In the cluster mode, the number of
+// the runs is limited by the slots shared by the servers instead. In the api
+// mode, the runs go right away and wait for a worker in the job queue of the
+// workers store, which is what's bounded then.
+//
+// The store is never called with mu held, so a slow store holds up the runs
+// but not the queue itself.
+type RunQueue struct {
+	mu          sync.Mutex
+	slots       int
+	maxLen      int
+	running     int
+	waiting     []*QueueTicket
+	estimate    time.Duration // Moving average of how long a run holds its slot
+	cluster     *clusterSlots
+	workers     Store
+	dispatching bool // The slots of the cluster are being taken for the waiting runs
+	redispatch  bool // The queue has changed while dispatching
+	moved       bool // The waiting runs have moved while dispatching
+}
+
+// QueueTicket is the turn of a run in the queue
+type QueueTicket struct {
+	queue     *RunQueue
+	lang      string
+	release   func()        // Gives the slots of the cluster back
+	granted   chan struct{} // closed once the run has its slot
+	moved     chan struct{} // told when the position has changed
+	grantedAt time.Time
+	started   bool // The run went on with its slot
+	done      bool
+}
+
+// QueueStatus tells how busy the queue is
+type QueueStatus struct {
+	Slots         int            `json:"slots"` // 0 in the api mode, where the workers have the slots
+	Running       int            `json:"running"`
+	Queued        int            `json:"queued"` // Waiting for a worker in the api mode
+	MaxQueued     int            `json:"max_queued"`
+	EstimatedWait int64          `json:"estimated_wait_ms"` // Of a run joining the queue now
+	Cluster       *ClusterStatus `json:"cluster,omitempty"`
+}
+
+// QueuePosition is told to a run while it's waiting
+type QueuePosition struct {
+	Position      int   `json:"position"` // 1 for the next run to go
+	EstimatedWait int64 `json:"estimated_wait_ms"`
+}
+
+// NewRunQueue creates the queue of slots runs at the same time, with up to
+// maxLen runs waiting
+func NewRunQueue(slots, maxLen int) *RunQueue {
+	if slots < 1 {
+		slots = 1
+	}
+
+	return &RunQueue{slots: slots, maxLen: maxLen, estimate: defaultRunEstimate}
+}
+
+// EnableCluster limits the runs by the slots of the cluster rather than by
+// the slots of the queue, and keeps trying for the slots given back by the
+// other servers
+func (q *RunQueue) EnableCluster(cluster *clusterSlots) {
+	q.mu.Lock()
+	q.cluster = cluster
+	q.slots = appConfig.GetHostSlots(cluster.host)
+	q.mu.Unlock()
+
+	go func() {
+		for range time.Tick(clusterPollInterval) {
+			q.dispatch(false)
+		}
+	}()
+}
+
+// EnableWorkers lets the runs go right away to wait for a worker in the job
+// queue of the store, which is bounded by the length of the queue instead
+func (q *RunQueue) EnableWorkers(workers Store) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.workers = workers
+}
+
+// Join takes a turn in the queue for a run of the language. The run gets its
+// slot right away if one is free, otherwise it waits at the end of the queue
+// unless the queue is full and ErrQueueFull is returned. A forced run always
+// waits.
+func (q *RunQueue) Join(lang string, force bool) (*QueueTicket, error) {
+	t := &QueueTicket{queue: q, lang: lang, granted: make(chan struct{}), moved: make(chan struct{}, 1)}
+
+	if workers := q.workersStore(); workers != nil {
+		if !force {
+			// The jobs are counted outside the lock, a slow store holds up
+			// nothing but this run
+			jobs, err := workers.CountJobs()
+			if err == nil && jobs >= q.maxLen {
+				return nil, ErrQueueFull
+			}
+		}
+
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		q.grant(t)
+		return t, nil
+	}
+
+	q.mu.Lock()
+	cluster, first := q.cluster, len(q.waiting) == 0
+	q.mu.Unlock()
+
+	if cluster != nil && first {
+		if ok, _ := q.take(t); ok {
+			q.mu.Lock()
+			defer q.mu.Unlock()
+			q.grant(t)
+			return t, nil
+		}
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.cluster == nil && len(q.waiting) == 0 && q.running < q.slots {
+		q.grant(t)
+		return t, nil
+	}
+
+	if !force && len(q.waiting) >= q.maxLen {
+		return nil, ErrQueueFull
+	}
+	q.waiting = append(q.waiting, t)
+	return t, nil
+}
+
+// take tries to take the slots of the cluster for the run, without the lock.
+// stop tells that no run after it can have one either.
+func (q *RunQueue) take(t *QueueTicket) (ok, stop bool) {
+	release, hostFull, err := q.cluster.acquire(t.lang)
+	if err != nil {
+		q.cluster.logger.Errorf("Cannot take the slots of a %s run - %v", t.lang, err)
+		return false, true
+	}
+
+	t.release = release
+	return release != nil, hostFull
+}
+
+func (q *RunQueue) workersStore() Store {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.workers
+}
+
+// Status tells how busy the queue is
+func (q *RunQueue) Status() QueueStatus {
+	if workers := q.workersStore(); workers != nil {
+		q.mu.Lock()
+		status := QueueStatus{Running: q.running, MaxQueued: q.maxLen}
+		q.mu.Unlock()
+
+		status.Queued, _ = workers.CountJobs()
+		return status
+	}
+
+	q.mu.Lock()
+	status := QueueStatus{
+		Slots:         q.slots,
+		Running:       q.running,
+		Queued:        len(q.waiting),
+		MaxQueued:     q.maxLen,
+		EstimatedWait: q.estimatedWait(len(q.waiting) + 1),
+	}
+	cluster := q.cluster
+	q.mu.Unlock()
+
+	if cluster != nil {
+		status.Cluster = cluster.status()
+	}
+	return status
+}
+
+// estimatedWait guesses how long the run at the position waits, as every
+// slot lets a run go once a while
+func (q *RunQueue) estimatedWait(position int) int64 {
+	if q.running < q.slots && position == 1 {
+		return 0
+	}
+
+	rounds := (position + q.slots - 1) / q.slots
+	return int64(time.Duration(rounds)*q.estimate) / int64(time.Millisecond)
+}
+
+func (q *RunQueue) grant(t *QueueTicket) {
+	q.running++
+	t.grantedAt = time.Now()
+	close(t.granted)
+}
+
+// next lets the waiting runs go in their order while there are free slots,
+// and tells the others if they have moved
+func (q *RunQueue) next(moved bool) {
+	for len(q.waiting) > 0 && q.running < q.slots {
+		q.grant(q.waiting[0])
+		q.waiting = q.waiting[1:]
+		moved = true
+	}
+
+	if moved {
+		q.tellMoved()
+	}
+}
+
+// dispatch lets the waiting runs go in their order while the slots of the
+// cluster can be taken. A run of a language without a free slot lets the runs
+// after it go. The slots are taken for a snapshot of the queue without the
+// lock, by a single dispatch at a time, and the queue is gone through again
+// if it has changed meanwhile.
+func (q *RunQueue) dispatch(moved bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.moved = q.moved || moved
+	if q.dispatching {
+		q.redispatch = true
+		return
+	}
+	q.dispatching = true
+	defer func() { q.dispatching = false }()
+
+	for {
+		waiting := append([]*QueueTicket(nil), q.waiting...)
+		q.redispatch = false
+		q.mu.Unlock()
+
+		for _, t := range waiting {
+			ok, stop := q.take(t)
+			if ok {
+				q.mu.Lock()
+				left := t.done
+				if !left {
+					q.grant(t)
+					q.leave(t)
+					q.moved = true
+				}
+				q.mu.Unlock()
+
+				// The run has left the queue while its slots were taken
+				if left {
+					t.release()
+				}
+				continue
+			}
+			if stop {
+				break
+			}
+		}
+
+		q.mu.Lock()
+		if !q.redispatch {
+			break
+		}
+	}
+
+	if q.moved {
+		q.moved = false
+		q.tellMoved()
+	}
+}
+
+// leave takes the ticket out of the waiting runs
+func (q *RunQueue) leave(t *QueueTicket) {
+	for i, waiting := range q.waiting {
+		if waiting == t {
+			q.waiting = append(q.waiting[:i:i], q.waiting[i+1:]...)
+			return
+		}
+	}
+}
+
+// tellMoved tells the waiting runs that they have moved
+func (q *RunQueue) tellMoved() {
+	for _, t := range q.waiting {
+		select {
+		case t.moved <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// position is where the ticket is in the queue, 0 once it has its slot
+func (q *RunQueue) position(t *QueueTicket) QueuePosition {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for i, waiting := range q.waiting {
+		if waiting == t {
+			return QueuePosition{Position: i + 1, EstimatedWait: q.estimatedWait(i + 1)}
+		}
+	}
+	return QueuePosition{}
+}
+
+// Wait blocks until the run has its slot, and tells queued where it is in the
+// queue whenever it moves. It returns false if the run is cancelled by
+// closeNotifier before that.
+func (t *QueueTicket) Wait(closeNotifier <-chan bool, queued func(QueuePosition)) bool {
+	for {
+		if p := t.queue.position(t); p.Position > 0 && queued != nil {
+			queued(p)
+		}
+
+		select {
+		case <-t.granted:
+			t.started = true
+			return true
+		case <-t.moved:
+		case <-closeNotifier:
+			return false
+		}
+	}
+}
+
+// Done gives the slot back, or leaves the queue if the run is still waiting.
+// How long the slot was held by a run goes into the estimated wait. A nil
+// ticket has nothing to give back.
+func (t *QueueTicket) Done() {
+	if t == nil {
+		return
+	}
+
+	q := t.queue
+	q.mu.Lock()
+
+	if t.done {
+		q.mu.Unlock()
+		return
+	}
+	t.done = true
+
+	var release func()
+	select {
+	case <-t.granted:
+		q.running--
+		release = t.release
+		if t.started {
+			q.estimate = (q.estimate*7 + time.Since(t.grantedAt)) / 8
+		}
+	default:
+		q.leave(t)
+	}
+
+	if q.cluster == nil {
+		q.next(true)
+		q.mu.Unlock()
+		return
+	}
+	q.mu.Unlock()
+
+	// The slots of the cluster are given back and taken by the store
+	if release != nil {
+		release()
+	}
+	q.dispatch(true)
+}
+
+// admit takes the turn of the runner in Runqueue, unless the queue is full
+func admit(runner *Runner) error {
+	ticket, err := Runqueue.Join(runner.Lang, false)
+	if err != nil {
+		return err
+	}
+
+	runner.ticket = ticket
+	return nil
+}
+
+// writeQueueFull refuses a run with 503 as the queue is full, telling when it
+// might not be anymore
+func writeQueueFull(w http.ResponseWriter, jsonErrors bool) {
+	status := Runqueue.Status()
+	retryAfter := ceilSeconds(time.Duration(status.EstimatedWait) * time.Millisecond)
+	if retryAfter < 1 {
+		retryAfter = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+
+	message := fmt.Sprintf("All the %d runners are busy and %d runs are waiting, retry in %d seconds", status.Slots, status.Queued, retryAfter)
+	if status.Slots == 0 {
+		message = fmt.Sprintf("%d runs are waiting for a worker, retry in %d seconds", status.Queued, retryAfter)
+	}
+	if jsonErrors {
+		writeAPIError(w, http.StatusServiceUnavailable, ErrCodeQueueFull, message)
+	} else {
+		http.Error(w, message, http.StatusServiceUnavailable)
+	}
+}
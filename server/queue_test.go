@@ -0,0 +1,93 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunQueue(t *testing.T) {
+	q := NewRunQueue(1, 2)
+
+	first, _ := q.Join("ruby", false)
+	if !first.Wait(nil, nil) {
+		t.Fatal("Expected the first run to go right away")
+	}
+
+	second, _ := q.Join("ruby", false)
+	third, _ := q.Join("ruby", false)
+	if _, err := q.Join("ruby", false); err != ErrQueueFull {
+		t.Fatalf("Expected the queue to be full, got %v", err)
+	}
+
+	if status := q.Status(); status.Running != 1 || status.Queued != 2 {
+		t.Fatalf("Unexpected status %+v", status)
+	}
+
+	positions := make(chan int, 4)
+	waited := make(chan bool)
+	go func() {
+		waited <- third.Wait(nil, func(p QueuePosition) { positions <- p.Position })
+	}()
+
+	if p := <-positions; p != 2 {
+		t.Fatalf("Expected the third run to be second in the queue, got %d", p)
+	}
+
+	second.Done()
+	if p := <-positions; p != 1 {
+		t.Fatalf("Expected the third run to move up once the second left, got %d", p)
+	}
+
+	first.Done()
+	select {
+	case ok := <-waited:
+		if !ok {
+			t.Fatal("Expected the third run to go")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the third run to have its turn")
+	}
+	third.Done()
+
+	if status := q.Status(); status.Running != 0 || status.Queued != 0 {
+		t.Fatalf("Expected the queue to be empty, got %+v", status)
+	}
+}
+
+func TestRunQueueCancel(t *testing.T) {
+	q := NewRunQueue(1, 1)
+	first, _ := q.Join("ruby", false)
+	first.Wait(nil, nil)
+
+	second, _ := q.Join("ruby", false)
+	closeNotifier := make(chan bool, 1)
+	closeNotifier <- true
+	if second.Wait(closeNotifier, nil) {
+		t.Fatal("Expected the run to be cancelled")
+	}
+	second.Done()
+
+	if status := q.Status(); status.Queued != 0 {
+		t.Fatalf("Expected the cancelled run to leave the queue, got %+v", status)
+	}
+}
+
+func TestWriteQueueFull(t *testing.T) {
+	defer func(q *RunQueue) { Runqueue = q }(Runqueue)
+	Runqueue = NewRunQueue(1, 0)
+	Runqueue.Join("ruby", false)
+
+	runner := &Runner{}
+	if err := admit(runner); err != ErrQueueFull {
+		t.Fatalf("Expected the run not to be admitted, got %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	writeQueueFull(w, true)
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") != "5" || !strings.Contains(w.Body.String(), ErrCodeQueueFull) {
+		t.Fatalf("Unexpected response %d - %v - %s", w.Code, w.Header(), w.Body.String())
+	}
+}
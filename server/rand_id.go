@@ -1,6 +1,8 @@
 package main
 
 import (
+	cryptorand "crypto/rand"
+	"encoding/hex"
 	"math/rand"
 	"time"
 )
@@ -19,3 +21,12 @@ func NewRandID(n int) string {
 
 	return string(s)
 }
+
+// newRandomHex creates a secret of n random bytes, hex encoded
+func newRandomHex(n int) (string, error) {
+	bts := make([]byte, n)
+	if _, err := cryptorand.Read(bts); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(bts), nil
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultRateLimits keep a single client from filling the store with run
+// tickets, snippets and stdin, or the runners with runs. A judge request runs
+// all of its cases, so it's limited the most.
+var defaultRateLimits = RateLimits{
+	"register/":   {IP: &RateLimit{Burst: 10, PerMinute: 30}, APIKey: &RateLimit{Burst: 60, PerMinute: 300}},
+	"save/":       {IP: &RateLimit{Burst: 5, PerMinute: 10}, APIKey: &RateLimit{Burst: 30, PerMinute: 120}},
+	"stdin/":      {IP: &RateLimit{Burst: 100, PerMinute: 1200}, APIKey: &RateLimit{Burst: 200, PerMinute: 3000}},
+	"v2/runs":     {IP: &RateLimit{Burst: 10, PerMinute: 30}, APIKey: &RateLimit{Burst: 60, PerMinute: 300}},
+	"v2/exec":     {IP: &RateLimit{Burst: 10, PerMinute: 30}, APIKey: &RateLimit{Burst: 60, PerMinute: 300}},
+	"v2/judge":    {IP: &RateLimit{Burst: 3, PerMinute: 6}, APIKey: &RateLimit{Burst: 20, PerMinute: 60}},
+	"v2/check":    {IP: &RateLimit{Burst: 10, PerMinute: 30}, APIKey: &RateLimit{Burst: 60, PerMinute: 300}},
+	"v2/snippets": {IP: &RateLimit{Burst: 5, PerMinute: 10}, APIKey: &RateLimit{Burst: 30, PerMinute: 120}},
+}
+
+// burst is how many requests the bucket holds, at least one
+func (l RateLimit) burst() float64 {
+	if l.Burst < 1 {
+		return 1
+	}
+	return float64(l.Burst)
+}
+
+// perSecond is how many requests the bucket gets back a second
+func (l RateLimit) perSecond() float64 {
+	return l.PerMinute / 60
+}
+
+// refill gives the tokens of the bucket at now, which had tokens at the time
+func (l RateLimit) refill(tokens float64, at, now time.Time) float64 {
+	if elapsed := now.Sub(at).Seconds(); elapsed > 0 {
+		tokens += elapsed * l.perSecond()
+	}
+	return math.Min(tokens, l.burst())
+}
+
+// fullIn is how long the bucket takes to be full again with tokens left
+func (l RateLimit) fullIn(tokens float64) time.Duration {
+	return time.Duration((l.burst() - tokens) / l.perSecond() * float64(time.Second))
+}
+
+// rateLimitOf finds the limit of the route for the client of the request, and
+// the bucket the client takes its tokens from. It's nil when the route is
+// not limited for the client.
+func rateLimitOf(route string, r *http.Request) (*RateLimit, string) {
+	routeLimit, ok := appConfig.GetRateLimits()[route]
+	if !ok {
+		return nil, ""
+	}
+
+	limit, bucket := routeLimit.IP, route+"#ip#"+clientIP(r)
+	if keyID := apiKeyID(r); keyID != "" {
+		limit, bucket = routeLimit.APIKey, route+"#api_key#"+keyID
+	}
+
+	if limit == nil || limit.PerMinute <= 0 {
+		return nil, ""
+	}
+	return limit, bucket
+}
+
+// clientIP is the IP of the client, told by the RealIPHeader of the config
+// behind a proxy. The client can send the header too, so the IP is the one
+// added by the outermost of the trusted proxies, counted from the right.
+func clientIP(r *http.Request) string {
+	if appConfig.RealIPHeader != "" {
+		if forwarded := r.Header.Get(appConfig.RealIPHeader); forwarded != "" {
+			ips := strings.Split(forwarded, ",")
+			i := len(ips) - appConfig.GetTrustedProxies()
+			if i < 0 {
+				i = 0
+			}
+			return strings.TrimSpace(ips[i])
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// rateLimitMiddleWare takes a token for every request to the route, and
+// refuses the request with 429 when there is none left. The limit is told
+// by the X-RateLimit-* headers of the response. The requests go through
+// when the store cannot be reached.
+func (s *Server) rateLimitMiddleWare(h http.Handler, route string, jsonErrors bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limit, bucket := rateLimitOf(route, r)
+		if limit == nil {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		allowed, tokens, err := s.store.TakeToken(bucket, *limit, time.Now())
+		if err != nil {
+			s.logger.Errorf("Cannot take a token of %s - %v", bucket, err)
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		header := w.Header()
+		header.Set("X-RateLimit-Limit", strconv.Itoa(int(limit.burst())))
+		header.Set("X-RateLimit-Remaining", strconv.Itoa(int(tokens)))
+		header.Set("X-RateLimit-Reset", strconv.Itoa(ceilSeconds(limit.fullIn(tokens))))
+
+		if !allowed {
+			retryAfter := ceilSeconds(time.Duration((1 - tokens) / limit.perSecond() * float64(time.Second)))
+			header.Set("Retry-After", strconv.Itoa(retryAfter))
+
+			message := fmt.Sprintf("Too many requests, retry in %d seconds", retryAfter)
+			if jsonErrors {
+				writeAPIError(w, http.StatusTooManyRequests, ErrCodeRateLimited, message)
+			} else {
+				http.Error(w, message, http.StatusTooManyRequests)
+			}
+			return
+		}
+
+		h.ServeHTTP(w, r)
+	})
+}
+
+// ceilSeconds rounds the duration up to whole seconds
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRateLimitMiddleWare(t *testing.T) {
+	s := newTestServer()
+	defer func() { appConfig = nil }()
+	appConfig.RateLimits = RateLimits{
+		"save/": {IP: &RateLimit{Burst: 2, PerMinute: 6}, APIKey: &RateLimit{Burst: 1, PerMinute: 60}},
+	}
+
+	h := s.rateLimitMiddleWare(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), "save/", false)
+	serve := func(remoteAddr string, key *APIKey) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/api/save/", nil)
+		r.RemoteAddr = remoteAddr
+		if key != nil {
+			r = r.WithContext(context.WithValue(r.Context(), apiKeyContextKey{}, key))
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	serve("10.0.0.1:1234", nil)
+	w := serve("10.0.0.1:1234", nil)
+	if w.Code != http.StatusOK || w.Header().Get("X-RateLimit-Limit") != "2" || w.Header().Get("X-RateLimit-Remaining") != "0" {
+		t.Fatalf("Expected the last token to be taken, got %d - %v", w.Code, w.Header())
+	}
+
+	w = serve("10.0.0.1:5678", nil)
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "10" {
+		t.Fatalf("Expected 429 with Retry-After, got %d - %v", w.Code, w.Header())
+	}
+
+	if w = serve("10.0.0.2:1234", nil); w.Code != http.StatusOK {
+		t.Fatalf("Expected another IP to have its own bucket, got %d", w.Code)
+	}
+
+	if w = serve("10.0.0.1:1234", &APIKey{ID: "abc"}); w.Code != http.StatusOK || w.Header().Get("X-RateLimit-Limit") != "1" {
+		t.Fatalf("Expected the API key to have its own bucket, got %d - %v", w.Code, w.Header())
+	}
+
+	other := s.rateLimitMiddleWare(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), "fetch/", false)
+	w = httptest.NewRecorder()
+	other.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/fetch/", nil))
+	if w.Code != http.StatusOK || w.Header().Get("X-RateLimit-Limit") != "" {
+		t.Fatalf("Expected a route without limits not to be limited, got %d - %v", w.Code, w.Header())
+	}
+}
+
+func TestRateLimitJudgeV2(t *testing.T) {
+	s := newTestServer()
+	defer func() { appConfig = nil }()
+
+	// The judge runs all of its cases, so it's limited by default
+	h := s.rateLimitMiddleWare(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), "v2/judge", true)
+	var w *httptest.ResponseRecorder
+	for i := 0; i <= defaultRateLimits["v2/judge"].IP.Burst; i++ {
+		r := httptest.NewRequest(http.MethodPost, "/api/v2/judge", nil)
+		r.RemoteAddr = "10.0.0.1:1234"
+		w = httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+	}
+
+	var apiErr map[string]APIError
+	json.NewDecoder(w.Body).Decode(&apiErr)
+	if w.Code != http.StatusTooManyRequests || apiErr["error"].Code != ErrCodeRateLimited {
+		t.Fatalf("Expected 429 with rate_limited, got %d - %+v", w.Code, apiErr)
+	}
+}
+
+func TestMemoryStoreTakeToken(t *testing.T) {
+	store := NewMemoryStore()
+	limit := RateLimit{Burst: 1, PerMinute: 60}
+	now := time.Now()
+
+	if allowed, _, _ := store.TakeToken("b", limit, now); !allowed {
+		t.Fatal("Expected the bucket to start full")
+	}
+
+	if allowed, tokens, _ := store.TakeToken("b", limit, now.Add(500*time.Millisecond)); allowed || tokens != 0.5 {
+		t.Fatalf("Expected half a token, got %v - %v", allowed, tokens)
+	}
+
+	if allowed, _, _ := store.TakeToken("b", limit, now.Add(1500*time.Millisecond)); !allowed {
+		t.Fatal("Expected the bucket to be refilled")
+	}
+
+	store.Reap(now.Add(time.Hour))
+	if len(store.buckets) != 0 {
+		t.Fatalf("Expected the full buckets to be dropped, got %v", store.buckets)
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	appConfig = &Config{RealIPHeader: "X-Forwarded-For"}
+	defer func() { appConfig = nil }()
+
+	r := httptest.NewRequest(http.MethodPost, "/", nil)
+	r.RemoteAddr = "10.0.0.1:1234"
+	if ip := clientIP(r); ip != "10.0.0.1" {
+		t.Fatalf("Expected the remote address, got %s", ip)
+	}
+
+	r.Header.Set("X-Forwarded-For", "203.0.113.7")
+	if ip := clientIP(r); ip != "203.0.113.7" {
+		t.Fatalf("Expected the forwarded address, got %s", ip)
+	}
+
+	// The entries on the left are sent by the client
+	r.Header.Set("X-Forwarded-For", "198.51.100.1, 203.0.113.7")
+	if ip := clientIP(r); ip != "203.0.113.7" {
+		t.Fatalf("Expected the address added by the proxy, got %s", ip)
+	}
+
+	appConfig.TrustedProxies = 2
+	r.Header.Set("X-Forwarded-For", "198.51.100.1, 203.0.113.7, 10.0.0.2")
+	if ip := clientIP(r); ip != "203.0.113.7" {
+		t.Fatalf("Expected the address added by the outer proxy, got %s", ip)
+	}
+}
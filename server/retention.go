@@ -0,0 +1,61 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"time"
+)
+
+// expiresAfter returns when something kept for ttl expires, or nil when it's
+// kept forever
+func expiresAfter(ttl time.Duration) *time.Time {
+	if ttl <= 0 {
+		return nil
+	}
+
+	expiresAt := time.Now().Add(ttl).UTC()
+	return &expiresAt
+}
+
+// expired tells whether the run ticket or the snippet has expired by now
+func (rnr *Runner) expired(now time.Time) bool {
+	return rnr.ExpiresAt != nil && !now.Before(*rnr.ExpiresAt)
+}
+
+// keepSnippet sets when the snippet expires, pinned snippets never do
+func keepSnippet(runner *Runner) {
+	runner.ExpiresAt = nil
+	if !runner.Pinned {
+		runner.ExpiresAt = expiresAfter(appConfig.GetSnippetTTL())
+	}
+}
+
+// canPin tells whether the request can pin a snippet, which needs the pin
+// token of the config in the X-Pin-Token header
+func canPin(r *http.Request) bool {
+	token := appConfig.PinToken
+	if token == "" {
+		return false
+	}
+
+	given := r.Header.Get("X-Pin-Token")
+	return subtle.ConstantTimeCompare([]byte(given), []byte(token)) == 1
+}
+
+// runJanitor reaps the expired run tickets and snippets every interval
+func (s *Server) runJanitor(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		stats, err := s.store.Reap(now)
+		if err != nil {
+			s.logger.Errorf("Janitor failed to reap the expired keys - %v", err)
+			continue
+		}
+
+		if stats.Runs > 0 || stats.Snippets > 0 {
+			s.logger.Infof("Janitor reaped %d run tickets and %d snippets", stats.Runs, stats.Snippets)
+		}
+	}
+}
@@ -0,0 +1,341 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// RevisionRef points at a revision of a snippet
+type RevisionRef struct {
+	ID       string `json:"id"`
+	Revision int    `json:"revision"`
+}
+
+// RevisionSummary describes a revision in the list of the revisions
+type RevisionSummary struct {
+	Revision int        `json:"revision"`
+	SavedAt  *time.Time `json:"saved_at,omitempty"`
+	Lang     string     `json:"lang"`
+	Version  string     `json:"version,omitempty"`
+	Size     int64      `json:"size"` // In bytes, of all the files together
+}
+
+// RevisionList is the JSON representation of the revisions of a snippet
+type RevisionList struct {
+	ID        string            `json:"id"`
+	Revisions []RevisionSummary `json:"revisions"`
+}
+
+// SnippetDiff is the JSON representation of the changes between two
+// revisions of a snippet
+type SnippetDiff struct {
+	ID    string     `json:"id"`
+	From  int        `json:"from"` // 0 when To is compared with nothing
+	To    int        `json:"to"`
+	Files []FileDiff `json:"files"`
+}
+
+// FileDiff is the unified diff of a file changed between the revisions
+type FileDiff struct {
+	Path   string `json:"path"`
+	Status string `json:"status"` // added, deleted or modified
+	Diff   string `json:"diff"`
+}
+
+// revisionNumber returns the number of the revision of the snippet, the
+// snippets saved before the revisions are their first one
+func (rnr *Runner) revisionNumber() int {
+	if rnr.Revision == 0 {
+		return 1
+	}
+	return rnr.Revision
+}
+
+// revision returns what is kept of the snippet in its revisions, which
+// expire and are deleted along with the snippet
+func (rnr *Runner) revision() *Runner {
+	return &Runner{
+		Lang:       rnr.Lang,
+		Source:     rnr.Source,
+		Version:    rnr.Version,
+		Timeout:    rnr.Timeout,
+		Files:      rnr.Files,
+		Entry:      rnr.Entry,
+		Args:       rnr.Args,
+		Env:        rnr.Env,
+		Stdin:      rnr.Stdin,
+		Limits:     rnr.Limits,
+		Revision:   rnr.revisionNumber(),
+		SavedAt:    rnr.SavedAt,
+		ForkedFrom: rnr.ForkedFrom,
+	}
+}
+
+// saveSnippet saves the runner as the claimed revision of the snippet
+func (s *Server) saveSnippet(claim *snippetClaim, runner *Runner) error {
+	savedAt := time.Now().UTC()
+	runner.Revision = claim.Revision
+	runner.SavedAt = &savedAt
+	runner.ForkedFrom = claim.ForkedFrom
+	runner.EditTokenHash = hashEditToken(claim.EditToken)
+
+	err := s.store.SaveSnippet(claim.ID, runner)
+	if err == nil {
+		err = s.store.SaveRevision(claim.ID, runner.revision(), appConfig.GetMaxRevisions())
+	}
+
+	if err != nil {
+		metrics.snippetSaves.Inc("error")
+	} else {
+		metrics.snippetSaves.Inc("ok")
+	}
+	return err
+}
+
+// snippetRevisions loads the revisions of the snippet, oldest first. The
+// snippet itself is the latest one when it's missing from the revisions.
+func (s *Server) snippetRevisions(codeID string) ([]*Runner, error) {
+	head, err := s.store.FetchSnippet(codeID)
+	if err != nil {
+		return nil, err
+	}
+
+	revisions, err := s.store.FetchRevisions(codeID)
+	if err != nil {
+		return nil, err
+	}
+
+	if n := len(revisions); n == 0 || revisions[n-1].Revision < head.revisionNumber() {
+		revisions = append(revisions, head.revision())
+	}
+	return revisions, nil
+}
+
+// snippetRevision loads the revision n of the snippet, the latest if n is 0.
+// The latest one is the snippet itself, so the revisions are only read for
+// an earlier one.
+func (s *Server) snippetRevision(codeID string, n int) (*Runner, error) {
+	if n == 0 {
+		head, err := s.store.FetchSnippet(codeID)
+		if err != nil {
+			return nil, err
+		}
+		return head.revision(), nil
+	}
+
+	revisions, err := s.snippetRevisions(codeID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, revision := range revisions {
+		if revision.Revision == n {
+			return revision, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// handleSnippetHistoryV2 serves GET /snippets/{id}/revisions,
+// GET /snippets/{id}/revisions/{n}, GET /snippets/{id}/diff?from=&to= and
+// POST /snippets/{id}/fork?revision=
+func (s *Server) handleSnippetHistoryV2(w http.ResponseWriter, r *http.Request, segments []string) {
+	codeID := segments[0]
+	method := http.MethodGet
+
+	var handle func()
+	switch {
+	case len(segments) == 2 && segments[1] == "revisions":
+		handle = func() { s.listRevisionsV2(w, codeID) }
+	case len(segments) == 3 && segments[1] == "revisions":
+		handle = func() { s.showRevisionV2(w, codeID, segments[2]) }
+	case len(segments) == 2 && segments[1] == "diff":
+		handle = func() { s.diffRevisionsV2(w, r, codeID) }
+	case len(segments) == 2 && segments[1] == "fork":
+		method = http.MethodPost
+		handle = func() { s.forkSnippetV2(w, r, codeID) }
+	default:
+		writeAPIError(w, http.StatusNotFound, ErrCodeNotFound, "The resource doesn't exist")
+		return
+	}
+
+	if r.Method != method {
+		writeAPIError(w, http.StatusMethodNotAllowed, ErrCodeMethodNotAllowed, r.Method+" is not allowed here")
+		return
+	}
+	handle()
+}
+
+func (s *Server) listRevisionsV2(w http.ResponseWriter, codeID string) {
+	revisions, err := s.snippetRevisions(codeID)
+	if err != nil {
+		s.writeRevisionError(w, codeID, err)
+		return
+	}
+
+	list := RevisionList{ID: codeID, Revisions: make([]RevisionSummary, 0, len(revisions))}
+	for _, revision := range revisions {
+		list.Revisions = append(list.Revisions, RevisionSummary{
+			Revision: revision.Revision,
+			SavedAt:  revision.SavedAt,
+			Lang:     revision.Lang,
+			Version:  revision.Version,
+			Size:     revision.sourceSize(),
+		})
+	}
+
+	writeJSON(w, http.StatusOK, list)
+}
+
+func (s *Server) showRevisionV2(w http.ResponseWriter, codeID, segment string) {
+	n, err := parseRevision(segment)
+	if err != nil || n == 0 {
+		writeAPIError(w, http.StatusBadRequest, ErrCodeInvalidRevision, fmt.Sprintf("%s is not a revision", segment))
+		return
+	}
+
+	revision, err := s.snippetRevision(codeID, n)
+	if err != nil {
+		s.writeRevisionError(w, codeID, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, newSnippetResource(codeID, revision))
+}
+
+func (s *Server) diffRevisionsV2(w http.ResponseWriter, r *http.Request, codeID string) {
+	query := r.URL.Query()
+
+	toN, err := parseRevision(query.Get("to"))
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, ErrCodeInvalidRevision, err.Error())
+		return
+	}
+
+	to, err := s.snippetRevision(codeID, toN)
+	if err != nil {
+		s.writeRevisionError(w, codeID, err)
+		return
+	}
+
+	// The revision before to by default
+	fromN := to.Revision - 1
+	if query.Get("from") != "" {
+		if fromN, err = parseRevision(query.Get("from")); err != nil || fromN == 0 {
+			writeAPIError(w, http.StatusBadRequest, ErrCodeInvalidRevision, fmt.Sprintf("%s is not a revision", query.Get("from")))
+			return
+		}
+	}
+
+	var from *Runner
+	if fromN > 0 {
+		if from, err = s.snippetRevision(codeID, fromN); err != nil {
+			s.writeRevisionError(w, codeID, err)
+			return
+		}
+	}
+
+	writeJSON(w, http.StatusOK, SnippetDiff{
+		ID:    codeID,
+		From:  fromN,
+		To:    to.Revision,
+		Files: diffRevisions(from, to),
+	})
+}
+
+func (s *Server) forkSnippetV2(w http.ResponseWriter, r *http.Request, codeID string) {
+	n, err := parseRevision(r.URL.Query().Get("revision"))
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, ErrCodeInvalidRevision, err.Error())
+		return
+	}
+
+	runner, err := s.snippetRevision(codeID, n)
+	if err != nil {
+		s.writeRevisionError(w, codeID, err)
+		return
+	}
+
+	claim, err := newSnippetClaim("", &RevisionRef{ID: codeID, Revision: runner.Revision})
+	if err == nil {
+		keepSnippet(runner)
+		err = s.saveSnippet(claim, runner)
+	}
+	if err != nil {
+		s.logger.Errorf("Failed to fork code snippet %s: %v", codeID, err)
+		writeAPIError(w, http.StatusInternalServerError, ErrCodeInternal, "A serious error has occured.")
+		return
+	}
+
+	s.writeClaimedSnippet(w, claim, runner)
+}
+
+func (s *Server) writeRevisionError(w http.ResponseWriter, codeID string, err error) {
+	if err == ErrNotFound {
+		writeAPIError(w, http.StatusNotFound, ErrCodeNotFound, "The revision doesn't exist")
+		return
+	}
+
+	s.logger.Errorf("Cannot get the revisions of %s: %v", codeID, err)
+	writeAPIError(w, http.StatusInternalServerError, ErrCodeInternal, "A serious error has occured.")
+}
+
+// parseRevision reads the number of a revision, 0 when it's not given
+func parseRevision(value string) (int, error) {
+	if value == "" {
+		return 0, nil
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("%s is not a revision", value)
+	}
+	return n, nil
+}
+
+// diffRevisions compares the files of the revisions, from is nil when to is
+// compared with nothing
+func diffRevisions(from, to *Runner) []FileDiff {
+	fromFiles := map[string]string{}
+	if from != nil {
+		fromFiles, _ = from.sourceFiles()
+	}
+	toFiles, _ := to.sourceFiles()
+
+	paths := []string{}
+	for p := range fromFiles {
+		paths = append(paths, p)
+	}
+	for p := range toFiles {
+		if _, ok := fromFiles[p]; !ok {
+			paths = append(paths, p)
+		}
+	}
+	sort.Strings(paths)
+
+	diffs := []FileDiff{}
+	for _, p := range paths {
+		before, inFrom := fromFiles[p]
+		after, inTo := toFiles[p]
+		if inFrom && inTo && before == after {
+			continue
+		}
+
+		status := "modified"
+		if !inFrom {
+			status = "added"
+		} else if !inTo {
+			status = "deleted"
+		}
+
+		diffs = append(diffs, FileDiff{
+			Path:   p,
+			Status: status,
+			Diff:   unifiedDiff(splitLines(before), splitLines(after)),
+		})
+	}
+	return diffs
+}
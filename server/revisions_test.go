@@ -0,0 +1,66 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+// revisionlessStore cannot read the revisions of the snippets
+type revisionlessStore struct {
+	*MemoryStore
+}
+
+func (s revisionlessStore) FetchRevisions(codeID string) ([]*Runner, error) {
+	return nil, errors.New("the revisions are not read")
+}
+
+func TestSnippetRevisionLatest(t *testing.T) {
+	s := newTestServer()
+	defer func() { appConfig = nil }()
+
+	store := NewMemoryStore()
+	s.store = revisionlessStore{store}
+
+	store.SaveRevision("abc", &Runner{Lang: "ruby", Source: "puts 1", Revision: 1}, 10)
+	store.SaveSnippet("abc", &Runner{Lang: "ruby", Source: "puts 2", Revision: 2, EditTokenHash: "secret"})
+
+	latest, err := s.snippetRevision("abc", 0)
+	if err != nil {
+		t.Fatalf("Expected the latest revision without reading the others, got %v", err)
+	}
+	if latest.Source != "puts 2" || latest.Revision != 2 || latest.EditTokenHash != "" {
+		t.Fatalf("Expected the snippet as its latest revision, got %+v", latest)
+	}
+
+	if _, err := s.snippetRevision("abc", 1); err == nil {
+		t.Fatal("Expected an earlier revision to be read from the revisions")
+	}
+}
+
+func TestClaimExpiredSnippet(t *testing.T) {
+	s := newTestServer()
+	defer func() { appConfig = nil }()
+
+	// The snippet has expired, its revisions are still there
+	s.store.SaveRevision("abc", &Runner{Lang: "ruby", Source: "puts 1", Revision: 1}, 10)
+	s.store.SaveRevision("abc", &Runner{Lang: "ruby", Source: "puts 2", Revision: 2}, 10)
+
+	claim, err := s.claimSnippet("abc", "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claim.ID != "abc" || !claim.Created || claim.Revision != 1 {
+		t.Fatalf("Expected a new snippet under the ID, got %+v", claim)
+	}
+	if err := s.saveSnippet(claim, &Runner{Lang: "ruby", Source: "puts 3"}); err != nil {
+		t.Fatal(err)
+	}
+
+	revisions, err := s.snippetRevisions("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revisions) != 1 || revisions[0].Source != "puts 3" {
+		t.Fatalf("Expected only the revision of the new snippet, got %d revisions", len(revisions))
+	}
+}
@@ -2,11 +2,11 @@ package main
 
 import (
 	"bufio"
-	"encoding/json"
 	"fmt"
 	"io"
-	"net"
 	"os"
+	"strings"
+	"sync"
 	"time"
 
 	"golang.org/x/net/context"
@@ -15,9 +15,9 @@ import (
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/api/types/strslice"
 	dcli "github.com/docker/docker/client"
 	"github.com/docker/docker/pkg/stdcopy"
-	"github.com/garyburd/redigo/redis"
 )
 
 // DockerClient for running code
@@ -28,17 +28,71 @@ const DockerAPIVersion = "1.24"
 
 // Runner runs the code
 type Runner struct {
-	Lang          string `json:"lang"`
-	Source        string `json:"source"`
-	Version       string `json:"version"`
-	Timeout       int    `json:"timeout"` // How long is the code going to run
+	Lang          string            `json:"lang"`
+	Source        string            `json:"source"`
+	Version       string            `json:"version"`
+	Timeout       int               `json:"timeout"`              // How long is the code going to run
+	Files         map[string]string `json:"files,omitempty"`      // Files of a project by their paths, Source is not used then
+	Entry         string            `json:"entry,omitempty"`      // The file in Files to be run
+	Args          []string          `json:"args,omitempty"`       // Arguments of the program
+	Env           map[string]string `json:"env,omitempty"`        // Environment variables of the program
+	Stdin         *string           `json:"stdin,omitempty"`      // Whole stdin of a non-interactive run
+	Limits        *Limits           `json:"limits,omitempty"`     // Resolved by the language, its defaults are used if nil
+	Pinned        bool              `json:"pinned,omitempty"`     // The snippet never expires
+	ExpiresAt     *time.Time        `json:"expires_at,omitempty"` // When the run ticket or the snippet is reaped, never if nil
+	EditTokenHash string            `json:"edit_token_hash,omitempty"`
+	Revision      int               `json:"revision,omitempty"`    // Number of the revision of the snippet
+	SavedAt       *time.Time        `json:"saved_at,omitempty"`    // When the revision of the snippet was saved
+	ForkedFrom    *RevisionRef      `json:"forked_from,omitempty"` // The revision the snippet was forked from
+	APIKey        string            `json:"api_key,omitempty"`     // ID of the API key the run is counted against
 	closeNotifier <-chan bool
 	logger        *logrus.Logger
+	tty           bool // Allocate a TTY, stdout and stderr are merged then
+	check         bool // Only compile the code with the check command of the language
+	output        *outputLimiter
+	ticket        *QueueTicket         // Turn in Runqueue taken when the run was admitted
+	onQueued      func(QueuePosition)  // Told while the run is waiting for its turn
+	onStarted     func(waited int64)   // Told once the run has its turn
+	relayInput    func(jobInput) error // Sends the input to the worker of a relayed run
+	mu            sync.Mutex
 	containerID   string
 }
 
-// Runnerthrottle Limit the max throttle for runner
-var Runnerthrottle chan struct{}
+// signals can be sent to the running program
+var signals = map[string]bool{
+	"SIGINT":  true,
+	"SIGTERM": true,
+	"SIGKILL": true,
+	"SIGQUIT": true,
+	"SIGHUP":  true,
+	"SIGUSR1": true,
+	"SIGUSR2": true,
+}
+
+// errNotStarted is returned when the container is not ready for the operation
+var errNotStarted = fmt.Errorf("the program is not running yet")
+
+// Termination reasons of a run
+const (
+	ReasonExited        = "exited"
+	ReasonTimeout       = "timeout"
+	ReasonOOM           = "oom"
+	ReasonCancelled     = "cancelled"
+	ReasonOutputLimit   = "output_limit"
+	ReasonInternalError = "internal_error"
+)
+
+// RunResult tells how a run has finished
+type RunResult struct {
+	ExitCode int    `json:"exit_code"`
+	Reason   string `json:"reason"`
+	WallTime int64  `json:"wall_time_ms"` // From the run being requested until it's finished
+	RunTime  int64  `json:"run_time_ms"`  // From the container being started until it's finished
+}
+
+// outputDrainTimeout is how long a run waits for the remaining output
+// after the container has finished
+const outputDrainTimeout = 2 * time.Second
 
 // WaitCtx is the context for the container wait
 type WaitCtx struct {
@@ -48,7 +102,8 @@ type WaitCtx struct {
 
 func newWaitCtx(r *Runner) WaitCtx {
 	ctx := context.WithValue(context.Background(), "close", r.closeNotifier)
-	ctx = context.WithValue(ctx, "succeed", make(chan struct{}))
+	ctx = context.WithValue(ctx, "succeed", make(chan int64, 1))
+	ctx = context.WithValue(ctx, "output", r.output.Exceeded())
 
 	wctx := WaitCtx{}
 	wctx.Context, wctx.Cancel = context.WithTimeout(ctx, time.Duration(r.Timeout)*time.Second)
@@ -56,9 +111,9 @@ func newWaitCtx(r *Runner) WaitCtx {
 	return wctx
 }
 
-// ChSucceed delivers the message that the context's been finished successfully
-func (w WaitCtx) ChSucceed() chan struct{} {
-	return w.Value("succeed").(chan struct{})
+// ChSucceed delivers the exit code once the context's been finished successfully
+func (w WaitCtx) ChSucceed() chan int64 {
+	return w.Value("succeed").(chan int64)
 }
 
 // ChClose deliver the message that the context's forced to be closed
@@ -66,28 +121,56 @@ func (w WaitCtx) ChClose() <-chan bool {
 	return w.Value("close").(<-chan bool)
 }
 
-// FetchCode get the code from Redis Server according to the UUID
-func FetchCode(uuid string, redisConn redis.Conn) (r *Runner, err error) {
-	value, err := redis.Bytes(redisConn.Do("GET", uuid+"#run"))
+// ChOutputExceeded is closed once the output has exceeded the limits
+func (w WaitCtx) ChOutputExceeded() <-chan struct{} {
+	return w.Value("output").(<-chan struct{})
+}
 
-	if err != nil {
-		return
+// Run the code in the container. When the stdin of the run has been given,
+// it's used instead of r and the program gets EOF right after it.
+func (rnr *Runner) Run(r io.Reader, stdout, stderr io.Writer, uuid string) *RunResult {
+	requestedAt := time.Now()
+	result := &RunResult{ExitCode: -1, Reason: ReasonInternalError}
+	defer func() {
+		result.WallTime = msSince(requestedAt)
+		lang, version := rnr.metricLabels()
+		metrics.runs.Inc(lang, version, result.Reason)
+		metrics.runSeconds.Since(requestedAt, lang, version)
+	}()
+
+	// The runs which were not admitted wait whatever the queue length
+	ticket := rnr.ticket
+	if ticket == nil {
+		ticket, _ = Runqueue.Join(rnr.Lang, true)
 	}
+	defer ticket.Done()
 
-	r = &Runner{}
-	err = json.Unmarshal(value, r)
-	return
-}
+	// The relayed run waits for its turn in the job queue of the workers
+	if Workers != nil {
+		result = rnr.relay(r, stdout, stderr, uuid, requestedAt)
+		return result
+	}
 
-// Run the code in the container
-func (rnr *Runner) Run(r io.Reader, w io.Writer, conn redis.Conn, uuid string) {
-	Runnerthrottle <- struct{}{}
-	defer func() { <-Runnerthrottle }()
+	if !ticket.Wait(rnr.closeNotifier, rnr.onQueued) {
+		result.Reason = ReasonCancelled
+		return result
+	}
+	metrics.queueWaitSeconds.Since(requestedAt)
+	if rnr.onStarted != nil {
+		rnr.onStarted(msSince(requestedAt))
+	}
 
 	err := rnr.createContainer(uuid)
 	if err != nil {
 		rnr.logger.Errorf("Container %s cannot be created - %v", uuid, err)
-		return
+		return result
+	}
+	// Whatever happens next, the container is never used again
+	defer rnr.removeContainer()
+
+	if err := rnr.copySource(); err != nil {
+		rnr.logger.Errorf("Source code cannot be copied into container %s - %v", rnr.shortContainerID(), err)
+		return result
 	}
 
 	hijackResp, err := DockerClient.ContainerAttach(context.Background(), rnr.containerID, types.ContainerAttachOptions{
@@ -99,39 +182,124 @@ func (rnr *Runner) Run(r io.Reader, w io.Writer, conn redis.Conn, uuid string) {
 
 	if err != nil {
 		rnr.logger.Errorf("Container %s cannot be attached - %v", rnr.shortContainerID(), err)
-		return
+		return result
 	}
+	defer hijackResp.Close()
 
-	go pipeIn(hijackResp.Conn, r, rnr.logger)
-	go pipeOut(hijackResp.Reader, w, rnr.logger)
+	if rnr.Stdin != nil {
+		r = strings.NewReader(*rnr.Stdin)
+	}
+
+	lang := (*appConfig.Languages)[rnr.Lang]
+	rnr.output = newOutputLimiter(lang.GetMaxOutput(), lang.GetMaxOutputRate())
+
+	outputDone := make(chan struct{})
+	go pipeIn(hijackResp, r, rnr.logger)
+	stdout = &countingWriter{w: rnr.output.Writer(stdout), stream: StreamStdout}
+	stderr = &countingWriter{w: rnr.output.Writer(stderr), stream: StreamStderr}
+	go pipeOut(hijackResp.Reader, stdout, stderr, rnr.tty, outputDone, rnr.logger)
 
 	// Start running the container
+	startedAt := time.Now()
 	err = rnr.startContainer()
 	if err != nil {
 		rnr.logger.Errorf("Container %s cannot be started - %v", rnr.shortContainerID(), err)
-		return
+		return result
 	}
-	defer func() {
-		rnr.logger.Infof("Removing container %s", rnr.containerID)
-		DockerClient.ContainerRemove(context.Background(), rnr.containerID, types.ContainerRemoveOptions{
-			Force: true,
-		})
-		rnr.logger.Infof("Container %s removed successfully", rnr.containerID)
-	}()
 
-	rnr.waitContainer(w, newWaitCtx(rnr))
+	result = rnr.waitContainer(stderr, newWaitCtx(rnr))
+	result.RunTime = msSince(startedAt)
+
+	// Make sure everything the program printed out has been delivered
+	select {
+	case <-outputDone:
+	case <-time.After(outputDrainTimeout):
+		rnr.logger.Errorf("Output of container %s is not drained in time", rnr.shortContainerID())
+	}
+
+	return result
 }
 
-func pipeIn(stdin net.Conn, r io.Reader, logger *logrus.Logger) {
-	io.Copy(stdin, r)
+// pipeIn copies the stdin into the container and closes the container's
+// stdin once r reaches EOF.
+func pipeIn(hijackResp types.HijackedResponse, r io.Reader, logger *logrus.Logger) {
+	io.Copy(hijackResp.Conn, r)
+	if err := hijackResp.CloseWrite(); err != nil {
+		logger.Error(err)
+	}
 }
 
-func pipeOut(r *bufio.Reader, w io.Writer, logger *logrus.Logger) {
-	if _, err := stdcopy.StdCopy(w, w, r); err != nil {
+func pipeOut(r *bufio.Reader, stdout, stderr io.Writer, tty bool, done chan<- struct{}, logger *logrus.Logger) {
+	defer close(done)
+
+	// The output of a TTY is not multiplexed
+	if tty {
+		if _, err := io.Copy(stdout, r); err != nil {
+			logger.Error(err)
+		}
+		return
+	}
+
+	if _, err := stdcopy.StdCopy(stdout, stderr, r); err != nil {
 		logger.Error(err)
 	}
 }
 
+// Signal sends the signal to the running program
+func (rnr *Runner) Signal(signal string) error {
+	if !signals[signal] {
+		return fmt.Errorf("signal %s is not allowed", signal)
+	}
+
+	if send := rnr.relayed(); send != nil {
+		return send(jobInput{Type: jobSignal, Signal: signal})
+	}
+
+	containerID := rnr.runningContainerID()
+	if containerID == "" {
+		return errNotStarted
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+
+	return DockerClient.ContainerKill(ctx, containerID, signal)
+}
+
+// Resize changes the size of the TTY of the running program
+func (rnr *Runner) Resize(height, width uint) error {
+	if !rnr.tty {
+		return fmt.Errorf("the program is not running with a TTY")
+	}
+
+	if send := rnr.relayed(); send != nil {
+		return send(jobInput{Type: jobResize, Height: height, Width: width})
+	}
+
+	containerID := rnr.runningContainerID()
+	if containerID == "" {
+		return errNotStarted
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+
+	return DockerClient.ContainerResize(ctx, containerID, types.ResizeOptions{
+		Height: height,
+		Width:  width,
+	})
+}
+
+func (rnr *Runner) runningContainerID() string {
+	rnr.mu.Lock()
+	defer rnr.mu.Unlock()
+	return rnr.containerID
+}
+
+func msSince(t time.Time) int64 {
+	return int64(time.Since(t) / time.Millisecond)
+}
+
 // NewDockerClient create a new docker client
 func NewDockerClient() (*dcli.Client, error) {
 	os.Setenv("DOCKER_API_VERSION", DockerAPIVersion)
@@ -149,94 +317,171 @@ var imageMapper = map[string]string{
 }
 
 func (rnr *Runner) image() string {
-	selectedVersion := rnr.Version
-	availableVersions := (*appConfig.Languages)[rnr.Lang].Versions
-
-	if selectedVersion == "" {
-		if len(availableVersions) > 0 {
-			selectedVersion = availableVersions[0]
-		} else {
-			selectedVersion = "latest"
-		}
+	return fmt.Sprintf("%s:%s", imageMapper[rnr.Lang], rnr.version())
+}
+
+// version is the version of the run, the first one of the language by default
+func (rnr *Runner) version() string {
+	if rnr.Version != "" {
+		return rnr.Version
+	}
+
+	if availableVersions := (*appConfig.Languages)[rnr.Lang].Versions; len(availableVersions) > 0 {
+		return availableVersions[0]
 	}
-	return fmt.Sprintf("%s:%s", imageMapper[rnr.Lang], selectedVersion)
+	return "latest"
 }
 
+// createContainer takes a warm container of the pool when the run fits it,
+// and creates one otherwise
 func (rnr *Runner) createContainer(uuid string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
-	defer cancel()
+	containerID, ok := Warmpool.claim(rnr)
+	if !ok {
+		ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+		defer cancel()
+
+		config, hostConfig := rnr.containerConfig()
+		createdAt := time.Now()
+		ctr, err := DockerClient.ContainerCreate(ctx, config, hostConfig, &network.NetworkingConfig{}, uuid)
+		metrics.containerSeconds.Since(createdAt, "create")
+		if err != nil {
+			return err
+		}
+		containerID = ctr.ID
+	}
+
+	rnr.mu.Lock()
+	rnr.containerID = containerID
+	rnr.mu.Unlock()
+	return nil
+}
 
-	cmd := []string{rnr.Source, uuid}
+// containerConfig is what the container of the run is created with
+func (rnr *Runner) containerConfig() (*container.Config, *container.HostConfig) {
+	// The source files are copied into the working directory before the
+	// container starts, so only the entry file is given to the entrypoint.
+	_, entry := rnr.sourceFiles()
+	cmd := append([]string{entry}, rnr.Args...)
 	lang := (*appConfig.Languages)[rnr.Lang]
+	limits := rnr.resources()
 
-	ctr, err := DockerClient.ContainerCreate(ctx,
-		&container.Config{
-			Cmd:             cmd,
-			Image:           rnr.image(),
-			OpenStdin:       true,
-			AttachStdin:     true,
-			AttachStdout:    true,
-			AttachStderr:    true,
-			NetworkDisabled: true,
-		},
-		&container.HostConfig{
-			Privileged: false,
-			CapDrop:    []string{"all"},
-			Resources: container.Resources{
-				CPUQuota:   lang.GetCPUQuota(),
-				MemorySwap: -1,
-				Memory:     lang.GetMemory(),
-				PidsLimit:  lang.GetPidsLimit(),
-			},
-		},
-		&network.NetworkingConfig{},
-		uuid,
-	)
+	// The check command replaces the entrypoint, with the entry file as $1
+	var entrypoint strslice.StrSlice
+	if rnr.check {
+		entrypoint = strslice.StrSlice{"sh", "-c", lang.CheckCmd, "sh"}
+		cmd = []string{entry}
+	}
 
-	if err != nil {
-		return err
+	return &container.Config{
+		Entrypoint:      entrypoint,
+		Cmd:             cmd,
+		Env:             containerEnv(rnr.Env),
+		Image:           rnr.image(),
+		OpenStdin:       true,
+		Tty:             rnr.tty,
+		AttachStdin:     true,
+		AttachStdout:    true,
+		AttachStderr:    true,
+		NetworkDisabled: true,
+	}, &container.HostConfig{
+		Privileged: false,
+		CapDrop:    []string{"all"},
+		Resources: container.Resources{
+			CPUQuota:   limits.CPUQuota,
+			MemorySwap: -1,
+			Memory:     limits.Memory,
+			PidsLimit:  limits.PidsLimit,
+		},
 	}
+}
 
-	rnr.containerID = ctr.ID
-	return nil
+func (rnr *Runner) isProject() bool {
+	return len(rnr.Files) > 0
 }
 
 func (rnr *Runner) startContainer() error {
 	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
 	defer cancel()
+	defer metrics.containerSeconds.Since(time.Now(), "start")
 
 	return DockerClient.ContainerStart(ctx, rnr.containerID, types.ContainerStartOptions{})
+}
+
+func (rnr *Runner) removeContainer() {
+	rnr.logger.Infof("Removing container %s", rnr.containerID)
+	defer metrics.containerSeconds.Since(time.Now(), "remove")
 
+	err := DockerClient.ContainerRemove(context.Background(), rnr.containerID, types.ContainerRemoveOptions{
+		Force: true,
+	})
+	if err != nil {
+		rnr.logger.Errorf("Container %s cannot be removed - %v", rnr.shortContainerID(), err)
+		return
+	}
+	rnr.logger.Infof("Container %s removed successfully", rnr.containerID)
 }
 
 func (rnr *Runner) shortContainerID() string {
 	return rnr.containerID[:7]
 }
 
-func (rnr *Runner) waitContainer(w io.Writer, wctx WaitCtx) {
+func (rnr *Runner) waitContainer(w io.Writer, wctx WaitCtx) *RunResult {
 	defer wctx.Cancel()
 
 	go func() {
-		_, err := DockerClient.ContainerWait(wctx, rnr.containerID)
+		exitCode, err := DockerClient.ContainerWait(wctx, rnr.containerID)
 		if err == nil {
-			wctx.ChSucceed() <- struct{}{}
+			wctx.ChSucceed() <- exitCode
 		}
 	}()
 
+	result := &RunResult{ExitCode: -1}
+
 	select {
-	case <-wctx.ChSucceed():
+	case exitCode := <-wctx.ChSucceed():
 		rnr.logger.Infof("Container %s is executed successfully", rnr.shortContainerID())
+		result.ExitCode = int(exitCode)
+		result.Reason = ReasonExited
+		if rnr.isOOMKilled() {
+			result.Reason = ReasonOOM
+		}
 	case <-wctx.ChClose():
+		stoppedAt := time.Now()
 		DockerClient.ContainerStop(context.Background(), rnr.containerID, nil)
+		metrics.containerSeconds.Since(stoppedAt, "stop")
 		rnr.logger.Infof("Container %s is stopped since the streamming has been halted", rnr.shortContainerID())
+		result.Reason = ReasonCancelled
+	case <-wctx.ChOutputExceeded():
+		DockerClient.ContainerKill(context.Background(), rnr.containerID, "SIGKILL")
+		msg := fmt.Sprintf("Container %s is terminated since its output is %s", rnr.shortContainerID(), rnr.output.Reason())
+		rnr.logger.Info(msg)
+		fmt.Fprintf(w, "%s\n", msg)
+		result.Reason = ReasonOutputLimit
 	case <-wctx.Done():
 		switch wctx.Err() {
 		case context.DeadlineExceeded:
 			msg := fmt.Sprintf("Container %s is terminated caused by %d sec timeout\n", rnr.shortContainerID(), rnr.Timeout)
 			rnr.logger.Error(msg)
 			fmt.Fprintf(w, "%s\n", msg)
+			result.Reason = ReasonTimeout
 		default:
 			rnr.logger.Error(wctx.Err())
+			result.Reason = ReasonInternalError
 		}
 	}
+
+	return result
+}
+
+func (rnr *Runner) isOOMKilled() bool {
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+
+	info, err := DockerClient.ContainerInspect(ctx, rnr.containerID)
+	if err != nil {
+		rnr.logger.Errorf("Container %s cannot be inspected - %v", rnr.shortContainerID(), err)
+		return false
+	}
+
+	return info.State != nil && info.State.OOMKilled
 }
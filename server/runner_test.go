@@ -0,0 +1,68 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	dcli "github.com/docker/docker/client"
+)
+
+// fakeDocker answers the Docker API with the handler, and records the
+// requests as "METHOD path"
+func fakeDocker(t *testing.T, handler func(w http.ResponseWriter, r *http.Request)) (*httptest.Server, func() []string) {
+	var mu sync.Mutex
+	var requests []string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests = append(requests, r.Method+" "+r.URL.Path[strings.Index(r.URL.Path, "/containers"):])
+		mu.Unlock()
+		handler(w, r)
+	}))
+
+	client, err := dcli.NewClient("tcp://"+strings.TrimPrefix(server.URL, "http://"), DockerAPIVersion, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	DockerClient = client
+
+	return server, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string{}, requests...)
+	}
+}
+
+func TestRunRemovesContainerOnFailure(t *testing.T) {
+	s := newTestServer()
+	(*appConfig.Languages)["ruby"] = Language{WorkDir: "/ruby", SourceFile: "main.rb"}
+	defer func() { appConfig, DockerClient = nil, nil }()
+
+	server, requests := fakeDocker(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/containers/create"):
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"Id": "0123456789abcdef"}`))
+		case r.Method == http.MethodDelete:
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.Error(w, "no space left", http.StatusInternalServerError)
+		}
+	})
+	defer server.Close()
+
+	runner := &Runner{Lang: "ruby", Source: "puts 1", Timeout: 5, logger: s.logger}
+	result := runner.Run(nil, ioutil.Discard, ioutil.Discard, "abc")
+	if result.Reason != ReasonInternalError {
+		t.Fatalf("Expected the run to fail, got %+v", result)
+	}
+
+	got := requests()
+	if len(got) != 3 || got[1] != "PUT /containers/0123456789abcdef/archive" || got[2] != "DELETE /containers/0123456789abcdef" {
+		t.Fatalf("Expected the container to be removed after the copy failed, got %v", got)
+	}
+}
@@ -5,33 +5,26 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/url"
 	"os/exec"
+	"strconv"
 	"strings"
 
 	"log/syslog"
 
 	"github.com/Sirupsen/logrus"
 	logrus_syslog "github.com/Sirupsen/logrus/hooks/syslog"
-	"github.com/garyburd/redigo/redis"
 )
 
 // Server is the abstraction of a koderunr web api
 type Server struct {
-	redisPool     *redis.Pool
+	store         Store
 	logger        *logrus.Logger
 	servingStatic bool
 }
 
 // NewServer create a new Server struct
-func NewServer(maxRedisConn int, servingStatic bool) *Server {
-	redisPool := redis.NewPool(func() (redis.Conn, error) {
-		conn, err := redis.Dial("tcp", ":6379")
-		if err != nil {
-			return nil, err
-		}
-		return conn, err
-	}, maxRedisConn)
-
+func NewServer(store Store, servingStatic bool) *Server {
 	log := logrus.New()
 	hook, err := logrus_syslog.NewSyslogHook("", "", syslog.LOG_INFO, "[KodeRunr Service]")
 
@@ -41,7 +34,7 @@ func NewServer(maxRedisConn int, servingStatic bool) *Server {
 	log.Hooks.Add(hook)
 
 	return &Server{
-		redisPool:     redisPool,
+		store:         store,
 		logger:        log,
 		servingStatic: servingStatic,
 	}
@@ -56,12 +49,29 @@ func (s *Server) Serve(scope string, port int) {
 	}
 
 	for url, handleFn := range s.routeMap() {
-		http.Handle(scope+url, s.recoverMiddleWare(http.HandlerFunc(handleFn)))
+		h := s.rateLimitMiddleWare(http.HandlerFunc(handleFn), url, false)
+		h = s.apiKeyMiddleWare(h, appConfig.RequireAPIKey && keyedRoutes[url], false)
+		http.Handle(scope+url, s.recoverMiddleWare(h))
 	}
 
+	// "runs" and "runs/" are limited as the same route
+	for url, handleFn := range s.v2RouteMap() {
+		h := s.rateLimitMiddleWare(http.HandlerFunc(handleFn), "v2/"+strings.TrimSuffix(url, "/"), true)
+		h = s.apiKeyMiddleWare(h, appConfig.RequireAPIKey, true)
+		http.Handle(scope+"v2/"+url, s.recoverMiddleWare(h))
+	}
+
+	http.Handle("/metrics", s.recoverMiddleWare(http.HandlerFunc(s.HandleMetrics)))
 	http.ListenAndServe(fmt.Sprintf(":%d", port), nil)
 }
 
+// keyedRoutes need an API key when the keys are required. A registered run
+// is streamed and given its stdin by its UUID alone.
+var keyedRoutes = map[string]bool{
+	"register/": true,
+	"save/":     true,
+}
+
 func (s *Server) routeMap() map[string]func(w http.ResponseWriter, r *http.Request) {
 	return map[string]func(w http.ResponseWriter, r *http.Request){
 		"langs/":    s.HandleLangs,
@@ -77,74 +87,98 @@ func (s *Server) routeMap() map[string]func(w http.ResponseWriter, r *http.Reque
 func (s *Server) HandleRunCode(w http.ResponseWriter, r *http.Request) {
 	uuid := r.FormValue("uuid")
 
-	conn := s.redisPool.Get()
-	defer conn.Close()
-
-	// Fetch the code into runner from Redis
-	runner, err := FetchCode(uuid, conn)
+	runner, err := s.store.FetchRun(uuid)
 	if err != nil {
-		s.logger.Infof("Source code cannot be found in redis - %v", err)
+		s.logger.Infof("Source code cannot be found - %v", err)
 		http.Error(w, "Cannot find the source code for some reason", 422)
 		return
 	}
 
+	if err := admit(runner); err != nil {
+		writeQueueFull(w, false)
+		return
+	}
+
+	keyRun, err := s.startKeyRun(runner.APIKey)
+	if err != nil {
+		runner.ticket.Done()
+		status, _, message := s.keyRunError(err)
+		http.Error(w, message, status)
+		return
+	}
+
 	// for close the container right away after the request is halted
 	closeNotifier := w.(http.CloseNotifier).CloseNotify()
 	runner.closeNotifier = closeNotifier
 	runner.logger = s.logger
 
 	isEvtStream := r.FormValue("evt") == "true"
-	client := NewClient(runner, s.redisPool.Get(), uuid)
+	isMuxStream := r.FormValue("mux") == "true"
+	client := NewClient(runner, uuid)
+	client.muxQueue = r.FormValue("queue") == "true"
 
-	go client.Read()
-	go client.Write(w, isEvtStream)
-	client.Run()
+	go client.Read(s.store)
+	go client.Write(w, isEvtStream, isMuxStream)
+	keyRun.charge(runner, client.Run())
 
-	// Purge the source code
-	_, err = conn.Do("DEL", uuid+"#run")
-	if err != nil {
-		s.logger.Errorf("Failed to purge the source code for %s - %v", uuid, err)
-	}
+	s.purgeRun(uuid)
+	s.finishKeyRun(keyRun)
 }
 
 // HandleSaveCode saves the source code and returns a ID.
+// The snippet expires unless it's pinned by "pinned=true", which needs the pin
+// token in the X-Pin-Token header. A new snippet comes with its edit token in
+// the X-Edit-Token header of the response, which is needed to save over it
+// by "codeID" later. Without it, "fork=true" saves a copy under a new ID.
 func (s *Server) HandleSaveCode(w http.ResponseWriter, r *http.Request) {
 	runner := Runner{
 		Lang:    r.FormValue("lang"),
 		Source:  r.FormValue("source"),
 		Version: r.FormValue("version"),
+		Pinned:  r.FormValue("pinned") == "true",
 	}
 
-	bts, _ := json.Marshal(&runner)
-	strj := string(bts)
+	if err := checkSnippetSize(&runner); err != nil {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
 
-	codeID := r.FormValue("codeID")
-	if codeID == "" {
-		codeID = NewRandID(10)
+	if runner.Pinned && !canPin(r) {
+		http.Error(w, "The snippet cannot be pinned without the pin token", http.StatusForbidden)
+		return
 	}
+	keepSnippet(&runner)
 
-	conn := s.redisPool.Get()
-	defer conn.Close()
+	claim, err := s.claimSnippet(r.FormValue("codeID"), editTokenOf(r), r.FormValue("fork") == "true")
+	if err == ErrEditForbidden {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
 
-	_, err := conn.Do("SET", codeID+"#snippet", strj)
+	if err == nil {
+		err = s.saveSnippet(claim, &runner)
+	}
 	if err != nil {
 		s.logger.Errorf("Failed to store code snippet: %v", err)
 		http.Error(w, "A serious error has occured.", 500)
 		return
 	}
 
-	fmt.Fprintf(w, codeID)
+	if claim.Created {
+		w.Header().Set("X-Edit-Token", claim.EditToken)
+	}
+	w.Header().Set("X-Revision", strconv.Itoa(claim.Revision))
+	fmt.Fprint(w, claim.ID)
 }
 
 // HandleFetchCode loads the code by codeID and returns the source code to user
-// Only used by web interface at the moment.
+// Only used by web interface at the moment. An earlier revision is given by
+// the "revision" value.
 func (s *Server) HandleFetchCode(w http.ResponseWriter, r *http.Request) {
 	codeID := r.FormValue("codeID")
+	revision, _ := strconv.Atoi(r.FormValue("revision"))
 
-	conn := s.redisPool.Get()
-	defer conn.Close()
-
-	value, err := redis.Bytes(conn.Do("GET", codeID+"#snippet"))
+	runner, err := s.snippetRevision(codeID, revision)
 	if err != nil {
 		s.logger.Errorf("Cannot get code snippet: %v", err)
 		http.Error(w, "The source code doesn't exist", 422)
@@ -152,29 +186,68 @@ func (s *Server) HandleFetchCode(w http.ResponseWriter, r *http.Request) {
 	}
 
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
-	w.Write(value)
+	json.NewEncoder(w).Encode(runner)
 }
 
-// HandleReg fetch the code from the client and save it in Redis
+// HandleReg fetch the code from the client and save it in Redis.
+// The program arguments are given by the "args" values, the environment
+// variables by the "env" values in the form of KEY=VAL and the whole stdin
+// of a non-interactive run by the "stdin" value. The limits are asked for by
+// the "profile", "timeout", "cpu_quota", "memory" and "pids_limit" values.
 func (s *Server) HandleReg(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+
+	env, err := parseEnvPairs(r.Form["env"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	requested, err := formLimits(r.Form)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	lang := (*appConfig.Languages)[r.FormValue("lang")]
+	limits, err := lang.ResolveLimits(requested)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
 	runner := Runner{
 		Lang:    r.FormValue("lang"),
 		Source:  r.FormValue("source"),
 		Version: r.FormValue("version"),
-		Timeout: 15,
+		Args:    r.Form["args"],
+		Env:     env,
+		Stdin:   formStdin(r.Form),
+		APIKey:  apiKeyID(r),
 	}
+	runner.applyLimits(limits)
 
-	bts, _ := json.Marshal(&runner)
-	strj := string(bts)
+	if err := checkSourceSize(&runner); err != nil {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
 
-	cmd := exec.Command("uuidgen")
-	output, _ := cmd.Output()
-	uuid := strings.TrimSuffix(string(output), "\n")
+	if err := checkStdinSize(&runner); err != nil {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	if err := validateArgs(runner.Args); err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
 
-	conn := s.redisPool.Get()
-	defer conn.Close()
+	if err := validateEnv(runner.Env); err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
 
-	_, err := conn.Do("SET", uuid+"#run", strj)
+	uuid, err := s.registerRun(&runner)
 	if err != nil {
 		s.logger.Errorf("Cannot register the code: %v", err)
 		http.Error(w, "A serious error has occured.", 500)
@@ -189,15 +262,14 @@ func (s *Server) HandleStdin(w http.ResponseWriter, r *http.Request) {
 	input := r.FormValue("input")
 	uuid := r.FormValue("uuid")
 
-	conn := s.redisPool.Get()
-	defer conn.Close()
-
-	conn.Do("PUBLISH", uuid+"#stdin", input)
+	if err := s.store.PublishStdin(uuid, []byte(input)); err != nil {
+		s.logger.Errorf("Stdin of %s cannot be published - %v", uuid, err)
+	}
 
 	fmt.Fprintf(w, "")
 }
 
-//HandleLangs deals with the request for show available programming languages
+// HandleLangs deals with the request for show available programming languages
 func (s *Server) HandleLangs(w http.ResponseWriter, r *http.Request) {
 	var b bytes.Buffer
 	b.WriteString("Supported Languages\n")
@@ -208,6 +280,13 @@ func (s *Server) HandleLangs(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	status := Runqueue.Status()
+	if status.Slots == 0 {
+		b.WriteString(fmt.Sprintf("\nRunning %d, %d of %d waiting for a worker\n", status.Running, status.Queued, status.MaxQueued))
+	} else {
+		b.WriteString(fmt.Sprintf("\nRunning %d of %d, %d of %d waiting\n", status.Running, status.Slots, status.Queued, status.MaxQueued))
+	}
+
 	b.WriteTo(w)
 }
 
@@ -221,3 +300,33 @@ func (s *Server) recoverMiddleWare(h http.Handler) http.Handler {
 		h.ServeHTTP(w, r)
 	})
 }
+
+// registerRun stores the runner as a run ticket and returns its UUID. The
+// ticket expires if the run is not started in time.
+func (s *Server) registerRun(runner *Runner) (string, error) {
+	uuid := newUUID()
+	runner.ExpiresAt = expiresAfter(appConfig.GetRunTTL())
+	return uuid, s.store.SaveRun(uuid, runner)
+}
+
+// purgeRun removes the run ticket once the code has been run
+func (s *Server) purgeRun(uuid string) {
+	if err := s.store.DeleteRun(uuid); err != nil {
+		s.logger.Errorf("Failed to purge the source code for %s - %v", uuid, err)
+	}
+}
+
+// formStdin returns the stdin value of the form, or nil if it's not given
+func formStdin(form url.Values) *string {
+	values, ok := form["stdin"]
+	if !ok || len(values) == 0 {
+		return nil
+	}
+	return &values[0]
+}
+
+func newUUID() string {
+	cmd := exec.Command("uuidgen")
+	output, _ := cmd.Output()
+	return strings.TrimSuffix(string(output), "\n")
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/Sirupsen/logrus"
+)
+
+func newTestServer() *Server {
+	appConfig = &Config{Languages: &Languages{"ruby": {SourceFile: "main.rb"}}}
+
+	logger := logrus.New()
+	logger.Out = ioutil.Discard
+
+	return &Server{store: NewMemoryStore(), logger: logger}
+}
+
+func postForm(handler http.HandlerFunc, values url.Values) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(values.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	w := httptest.NewRecorder()
+	handler(w, r)
+	return w
+}
+
+func TestHandleReg(t *testing.T) {
+	s := newTestServer()
+	defer func() { appConfig = nil }()
+
+	w := postForm(s.HandleReg, url.Values{"lang": {"ruby"}, "source": {"puts 1"}, "args": {"-n"}})
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d - %s", w.Code, w.Body.String())
+	}
+
+	runner, err := s.store.FetchRun(w.Body.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if runner.Source != "puts 1" || len(runner.Args) != 1 || runner.Timeout != 15 {
+		t.Fatalf("Unexpected run %+v", runner)
+	}
+}
+
+func TestHandleSaveAndFetchCode(t *testing.T) {
+	s := newTestServer()
+	defer func() { appConfig = nil }()
+
+	w := postForm(s.HandleSaveCode, url.Values{"lang": {"ruby"}, "source": {"puts 1"}})
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d - %s", w.Code, w.Body.String())
+	}
+
+	w = postForm(s.HandleFetchCode, url.Values{"codeID": {w.Body.String()}})
+	runner := &Runner{}
+	if err := json.NewDecoder(w.Body).Decode(runner); err != nil || runner.Source != "puts 1" {
+		t.Fatalf("Unexpected snippet %+v - %v", runner, err)
+	}
+
+	w = postForm(s.HandleFetchCode, url.Values{"codeID": {"missing"}})
+	if w.Code != 422 {
+		t.Fatalf("Expected 422 for a missing snippet, got %d", w.Code)
+	}
+}
+
+func TestHandleStdin(t *testing.T) {
+	s := newTestServer()
+	defer func() { appConfig = nil }()
+
+	sub, _ := s.store.SubscribeStdin("abc")
+	defer sub.Close()
+
+	postForm(s.HandleStdin, url.Values{"uuid": {"abc"}, "input": {"42\n"}})
+
+	data, err := sub.Receive()
+	if err != nil || string(data) != "42\n" {
+		t.Fatalf("Expected the stdin to be delivered, got %q - %v", data, err)
+	}
+}
+
+func TestHandleSnippetsV2(t *testing.T) {
+	s := newTestServer()
+	defer func() { appConfig = nil }()
+
+	body := `{"lang": "ruby", "source": "puts 1"}`
+	w := httptest.NewRecorder()
+	s.HandleSnippetsV2(w, httptest.NewRequest(http.MethodPost, "/api/v2/snippets", strings.NewReader(body)))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d - %s", w.Code, w.Body.String())
+	}
+
+	var snippet SnippetResource
+	json.NewDecoder(w.Body).Decode(&snippet)
+
+	w = httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodDelete, "/api/v2/snippets/"+snippet.ID, nil)
+	r.Header.Set("X-Edit-Token", snippet.EditToken)
+	s.HandleSnippetsV2(w, r)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("Expected 204, got %d - %s", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	s.HandleSnippetsV2(w, httptest.NewRequest(http.MethodGet, "/api/v2/snippets/"+snippet.ID, nil))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("Expected 404, got %d - %s", w.Code, w.Body.String())
+	}
+}
+
+func TestPinnedSnippetsV2(t *testing.T) {
+	s := newTestServer()
+	defer func() { appConfig = nil }()
+	appConfig.PinToken = "secret"
+	appConfig.SnippetTTL = 3600
+
+	body := `{"lang": "ruby", "source": "puts 1", "pinned": true}`
+	w := httptest.NewRecorder()
+	s.HandleSnippetsV2(w, httptest.NewRequest(http.MethodPost, "/api/v2/snippets", strings.NewReader(body)))
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("Expected 403 without the pin token, got %d - %s", w.Code, w.Body.String())
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/api/v2/snippets", strings.NewReader(body))
+	r.Header.Set("X-Pin-Token", "secret")
+	w = httptest.NewRecorder()
+	s.HandleSnippetsV2(w, r)
+
+	var snippet SnippetResource
+	json.NewDecoder(w.Body).Decode(&snippet)
+	if w.Code != http.StatusCreated || !snippet.Pinned || snippet.ExpiresAt != nil {
+		t.Fatalf("Expected a pinned snippet, got %d - %+v", w.Code, snippet)
+	}
+
+	w = httptest.NewRecorder()
+	body = `{"lang": "ruby", "source": "puts 2"}`
+	s.HandleSnippetsV2(w, httptest.NewRequest(http.MethodPost, "/api/v2/snippets", strings.NewReader(body)))
+	json.NewDecoder(w.Body).Decode(&snippet)
+	if snippet.Pinned || snippet.ExpiresAt == nil {
+		t.Fatalf("Expected the snippet to expire, got %+v", snippet)
+	}
+}
+
+func TestSnippetSizeV2(t *testing.T) {
+	s := newTestServer()
+	defer func() { appConfig = nil }()
+	appConfig.MaxSnippetSize = 4
+
+	body := `{"lang": "ruby", "source": "puts 1"}`
+	w := httptest.NewRecorder()
+	s.HandleSnippetsV2(w, httptest.NewRequest(http.MethodPost, "/api/v2/snippets", strings.NewReader(body)))
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("Expected 413, got %d - %s", w.Code, w.Body.String())
+	}
+}
+
+func TestSnippetEditTokensV2(t *testing.T) {
+	s := newTestServer()
+	defer func() { appConfig = nil }()
+
+	put := func(path, token, body string) (int, SnippetResource) {
+		r := httptest.NewRequest(http.MethodPut, path, strings.NewReader(body))
+		r.Header.Set("X-Edit-Token", token)
+		w := httptest.NewRecorder()
+		s.HandleSnippetsV2(w, r)
+
+		var snippet SnippetResource
+		json.NewDecoder(w.Body).Decode(&snippet)
+		return w.Code, snippet
+	}
+
+	code, created := put("/api/v2/snippets/abc", "", `{"lang": "ruby", "source": "puts 1"}`)
+	if code != http.StatusCreated || created.EditToken == "" {
+		t.Fatalf("Expected the snippet to be created with an edit token, got %d - %+v", code, created)
+	}
+
+	if code, _ := put("/api/v2/snippets/abc", "wrong", `{"lang": "ruby", "source": "puts 2"}`); code != http.StatusForbidden {
+		t.Fatalf("Expected 403 with a wrong edit token, got %d", code)
+	}
+
+	code, updated := put("/api/v2/snippets/abc", created.EditToken, `{"lang": "ruby", "source": "puts 2"}`)
+	if code != http.StatusOK || updated.Source != "puts 2" || updated.EditToken != "" {
+		t.Fatalf("Expected the snippet to be updated, got %d - %+v", code, updated)
+	}
+
+	code, forked := put("/api/v2/snippets/abc?fork=true", "", `{"lang": "ruby", "source": "puts 3"}`)
+	if code != http.StatusCreated || forked.ID == "abc" || forked.EditToken == created.EditToken {
+		t.Fatalf("Expected the snippet to be forked, got %d - %+v", code, forked)
+	}
+
+	runner, _ := s.store.FetchSnippet("abc")
+	if runner.Source != "puts 2" {
+		t.Fatalf("The fork should not change the snippet, got %+v", runner)
+	}
+}
+
+func TestHandleSaveCodeEditToken(t *testing.T) {
+	s := newTestServer()
+	defer func() { appConfig = nil }()
+
+	w := postForm(s.HandleSaveCode, url.Values{"lang": {"ruby"}, "source": {"puts 1"}})
+	codeID, token := w.Body.String(), w.Header().Get("X-Edit-Token")
+	if token == "" {
+		t.Fatal("Expected the edit token of the new snippet")
+	}
+
+	w = postForm(s.HandleSaveCode, url.Values{"codeID": {codeID}, "lang": {"ruby"}, "source": {"puts 2"}})
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("Expected 403 without the edit token, got %d", w.Code)
+	}
+
+	w = postForm(s.HandleSaveCode, url.Values{"codeID": {codeID}, "editToken": {token}, "lang": {"ruby"}, "source": {"puts 2"}})
+	if w.Code != http.StatusOK || w.Body.String() != codeID || w.Header().Get("X-Edit-Token") != "" {
+		t.Fatalf("Expected the snippet to be updated, got %d - %s", w.Code, w.Body.String())
+	}
+
+	w = postForm(s.HandleFetchCode, url.Values{"codeID": {codeID}})
+	if strings.Contains(w.Body.String(), "edit_token") {
+		t.Fatalf("The edit token should not be fetched, got %s", w.Body.String())
+	}
+}
+
+func TestSnippetRevisionsV2(t *testing.T) {
+	s := newTestServer()
+	defer func() { appConfig = nil }()
+
+	serve := func(method, path, token, body string, out interface{}) int {
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		r.Header.Set("X-Edit-Token", token)
+		w := httptest.NewRecorder()
+		s.HandleSnippetsV2(w, r)
+		json.NewDecoder(w.Body).Decode(out)
+		return w.Code
+	}
+
+	var created, updated SnippetResource
+	serve(http.MethodPost, "/api/v2/snippets", "", `{"lang": "ruby", "source": "puts 1\n"}`, &created)
+	serve(http.MethodPut, "/api/v2/snippets/"+created.ID, created.EditToken, `{"lang": "ruby", "source": "puts 2\n"}`, &updated)
+	if created.Revision != 1 || updated.Revision != 2 {
+		t.Fatalf("Expected the revisions 1 and 2, got %d and %d", created.Revision, updated.Revision)
+	}
+
+	var list RevisionList
+	serve(http.MethodGet, "/api/v2/snippets/"+created.ID+"/revisions", "", "", &list)
+	if len(list.Revisions) != 2 || list.Revisions[0].Revision != 1 || list.Revisions[1].Size != 7 {
+		t.Fatalf("Unexpected revisions %+v", list)
+	}
+
+	var first SnippetResource
+	if code := serve(http.MethodGet, "/api/v2/snippets/"+created.ID+"/revisions/1", "", "", &first); code != http.StatusOK || first.Source != "puts 1\n" {
+		t.Fatalf("Unexpected first revision %d - %+v", code, first)
+	}
+
+	if code := serve(http.MethodGet, "/api/v2/snippets/"+created.ID+"/revisions/3", "", "", &first); code != http.StatusNotFound {
+		t.Fatalf("Expected 404 for a missing revision, got %d", code)
+	}
+
+	var diff SnippetDiff
+	serve(http.MethodGet, "/api/v2/snippets/"+created.ID+"/diff", "", "", &diff)
+	if diff.From != 1 || diff.To != 2 || len(diff.Files) != 1 || diff.Files[0].Diff != "@@ -1 +1 @@\n-puts 1\n+puts 2\n" {
+		t.Fatalf("Unexpected diff %+v", diff)
+	}
+
+	var forked SnippetResource
+	code := serve(http.MethodPost, "/api/v2/snippets/"+created.ID+"/fork?revision=1", "", "", &forked)
+	if code != http.StatusCreated || forked.Source != "puts 1\n" || forked.Revision != 1 || forked.EditToken == "" {
+		t.Fatalf("Unexpected fork %d - %+v", code, forked)
+	}
+	if forked.ForkedFrom == nil || *forked.ForkedFrom != (RevisionRef{ID: created.ID, Revision: 1}) {
+		t.Fatalf("Expected the fork of %s@1, got %+v", created.ID, forked.ForkedFrom)
+	}
+}
+
+func TestSnippetsKeptForever(t *testing.T) {
+	s := newTestServer()
+	defer func() { appConfig = nil }()
+
+	w := httptest.NewRecorder()
+	body := `{"lang": "ruby", "source": "puts 1"}`
+	s.HandleSnippetsV2(w, httptest.NewRequest(http.MethodPost, "/api/v2/snippets", strings.NewReader(body)))
+
+	var snippet SnippetResource
+	json.NewDecoder(w.Body).Decode(&snippet)
+	if w.Code != http.StatusCreated || snippet.ExpiresAt != nil {
+		t.Fatalf("Expected the snippet to be kept without snippet_ttl_sec, got %d - %+v", w.Code, snippet)
+	}
+}
+
+func TestFormStdin(t *testing.T) {
+	if stdin := formStdin(url.Values{"lang": {"ruby"}}); stdin != nil {
+		t.Fatalf("Expected an interactive stdin, got %q", *stdin)
+	}
+
+	if stdin := formStdin(url.Values{"stdin": {""}}); stdin == nil || *stdin != "" {
+		t.Fatalf("Expected an empty stdin, got %v", stdin)
+	}
+
+	if stdin := formStdin(url.Values{"stdin": {"1 2\n", "3"}}); stdin == nil || *stdin != "1 2\n" {
+		t.Fatalf("Expected the first stdin, got %v", stdin)
+	}
+}
+
+func TestHandleRegStdin(t *testing.T) {
+	s := newTestServer()
+	defer func() { appConfig = nil }()
+	appConfig.MaxStdinSize = 8
+
+	w := postForm(s.HandleReg, url.Values{"lang": {"ruby"}, "source": {"puts gets"}, "stdin": {"1 2\n"}})
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d - %s", w.Code, w.Body.String())
+	}
+
+	runner, err := s.store.FetchRun(w.Body.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if runner.Stdin == nil || *runner.Stdin != "1 2\n" {
+		t.Fatalf("Expected the stdin to be registered, got %v", runner.Stdin)
+	}
+
+	w = postForm(s.HandleReg, url.Values{"lang": {"ruby"}, "source": {"puts gets"}, "stdin": {"1 2 3 4 5\n"}})
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("Expected 413 over max_stdin_size, got %d - %s", w.Code, w.Body.String())
+	}
+}
@@ -74,7 +74,7 @@ $(function() {
       runner.term.clear();
       runner.term.focus();
       var evtSource = new EventSource(ROUTERS.RUN + "?evt=true&uuid=" + uuid);
-      evtSource.onmessage = function(e) {
+      evtSource.addEventListener("stdout", function(e) {
         var str = e.data.split("\n").join("");
 
         if (str === "") {
@@ -82,7 +82,39 @@ $(function() {
         } else {
           runner.term.echo(str);
         }
-      }
+      });
+
+      evtSource.addEventListener("stderr", function(e) {
+        var str = e.data.split("\n").join("");
+
+        if (str === "") {
+          runner.term.echo("\r");
+        } else {
+          runner.term.echo("[[;red;]" + $.terminal.escape_brackets(str) + "]");
+        }
+      });
+
+      evtSource.addEventListener("queued", function(e) {
+        var queued = JSON.parse(e.data);
+        var wait = Math.ceil(queued.estimated_wait_ms / 1000);
+        runner.term.echo("[[;gray;]Waiting for a runner, " + queued.position + " in the queue, about " + wait + "s]");
+      });
+
+      evtSource.addEventListener("exit", function(e) {
+        var result = JSON.parse(e.data);
+        evtSource.close();
+        uuid = undefined;
+
+        if (result.reason === "exited" && result.exit_code === 0) {
+          runner.term.echo("[[;green;]Completed!]");
+        } else if (result.reason === "exited") {
+          runner.term.echo("[[;red;]Exited with status " + result.exit_code + "]");
+        } else {
+          runner.term.echo("[[;red;]Terminated: " + result.reason + "]");
+        }
+        runner.term.focus(false);
+        runner.running = false;
+      });
 
       evtSource.onerror = function(e) {
         if (uuid) {
@@ -115,11 +147,37 @@ $(function() {
       runnable.version = this.version
     }
 
-    $.post(ROUTERS.SAVE, runnable, function(codeID) {
+    // Save over the snippet if it's ours, or fork it
+    if (this.codeID) {
+      runnable.codeID = this.codeID;
+      runnable.fork = true;
+      var editToken = editTokens.fetch(this.codeID);
+      if (editToken) {
+        runnable.editToken = editToken;
+      }
+    }
+
+    var self = this;
+    $.post(ROUTERS.SAVE, runnable, function(codeID, status, xhr) {
+      var editToken = xhr.getResponseHeader("X-Edit-Token");
+      if (editToken) {
+        editTokens.store(codeID, editToken);
+      }
+
+      self.codeID = codeID;
       window.history.pushState(codeID, "KodeRunr#" + codeID, "/#" + codeID);
     });
   }
 
+  var editTokens = editTokens || {};
+  editTokens.fetch = function(codeID) {
+    return localStorage.getItem("editToken#" + codeID)
+  }
+
+  editTokens.store = function(codeID, editToken) {
+    localStorage.setItem("editToken#" + codeID, editToken)
+  }
+
   var sourceCodeCache = sourceCodeCache || {};
   sourceCodeCache.fetch = function(runner) {
     return localStorage.getItem(runner.lang)
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrNotFound is returned when the run or the snippet doesn't exist
+var ErrNotFound = errors.New("not found")
+
+// ErrAPIKeyExists is returned when a new API key has the ID of another
+var ErrAPIKeyExists = errors.New("an API key with the ID exists")
+
+// Store keeps the run tickets and the snippets, and delivers the stdin sent
+// by the client to the server running the code. The run tickets and the
+// snippets with ExpiresAt are not found once they have expired, and are
+// removed for good by Reap.
+type Store interface {
+	// SaveRun stores the runner as a run ticket under the UUID
+	SaveRun(uuid string, runner *Runner) error
+	// FetchRun loads the run ticket, or returns ErrNotFound
+	FetchRun(uuid string) (*Runner, error)
+	// DeleteRun removes the run ticket once the code has been run
+	DeleteRun(uuid string) error
+
+	// SaveSnippet stores the runner as a snippet under the codeID
+	SaveSnippet(codeID string, runner *Runner) error
+	// FetchSnippet loads the snippet, or returns ErrNotFound
+	FetchSnippet(codeID string) (*Runner, error)
+	// DeleteSnippet removes the snippet with its revisions and tells whether
+	// it existed
+	DeleteSnippet(codeID string) (bool, error)
+	// SaveRevision adds the runner as a revision of the snippet, only the
+	// last max revisions are kept
+	SaveRevision(codeID string, runner *Runner, max int) error
+	// FetchRevisions loads the revisions of the snippet, oldest first. They
+	// are there as long as the snippet is.
+	FetchRevisions(codeID string) ([]*Runner, error)
+
+	// Reap removes the run tickets and the snippets expired by now
+	Reap(now time.Time) (ReapStats, error)
+
+	// SaveAPIKey stores a new API key under its ID, or returns
+	// ErrAPIKeyExists when the ID is taken
+	SaveAPIKey(key *APIKey) error
+	// FetchAPIKey loads the API key, or returns ErrNotFound
+	FetchAPIKey(id string) (*APIKey, error)
+	// ListAPIKeys loads all the API keys
+	ListAPIKeys() ([]*APIKey, error)
+	// DeleteAPIKey removes the API key with its usage and tells whether it
+	// existed
+	DeleteAPIKey(id string) (bool, error)
+	// StartKeyRun counts a run of the key started on the day, unless a quota
+	// of the key is used up and a *QuotaExceededError is returned. A run is
+	// not counted as running anymore after keyRunLease if it's not finished.
+	StartKeyRun(key *APIKey, day string) error
+	// FinishKeyRun counts a run of the key as finished after using cpu
+	FinishKeyRun(key *APIKey, cpu time.Duration) error
+	// FetchKeyUsage loads how much of its quotas the key has used on the day
+	FetchKeyUsage(id, day string) (KeyUsage, error)
+
+	// TakeToken takes a token from the bucket limited by the limit, and
+	// tells whether there was one and how many tokens are left at now
+	TakeToken(bucket string, limit RateLimit, now time.Time) (bool, float64, error)
+
+	// AcquireSlots takes a slot of every semaphore for the holder until the
+	// lease ends, or none of them if one has no free slot. It returns the
+	// index of the semaphore with no free slot, or -1 once they are taken.
+	AcquireSlots(sems []Semaphore, holder string, lease time.Duration) (int, error)
+	// RenewSlots extends the lease of the slots of the holder, and tells
+	// whether the holder still had all of them
+	RenewSlots(sems []Semaphore, holder string, lease time.Duration) (bool, error)
+	// ReleaseSlots gives the slots of the holder back
+	ReleaseSlots(sems []Semaphore, holder string) error
+	// CountSlots tells how many slots of the semaphore are taken
+	CountSlots(name string) (int, error)
+
+	// PushJob adds the job to the end of the job queue
+	PushJob(job []byte) error
+	// PopJob takes the job at the front of the job queue, waiting up to the
+	// timeout for one, or returns ErrNotFound
+	PopJob(timeout time.Duration) ([]byte, error)
+	// CountJobs tells how many jobs are waiting for a worker
+	CountJobs() (int, error)
+
+	// PublishStdin delivers the input to the run if it's subscribed
+	PublishStdin(uuid string, input []byte) error
+	// SubscribeStdin starts receiving the stdin published to the run
+	SubscribeStdin(uuid string) (Subscription, error)
+	// Publish delivers the message to the subscriptions of the channel
+	Publish(channel string, message []byte) error
+	// Subscribe starts receiving the messages published to the channel
+	Subscribe(channel string) (Subscription, error)
+}
+
+// Subscription receives the stdin published to a run
+type Subscription interface {
+	// Receive blocks until the next input, and returns an error once the
+	// subscription is closed
+	Receive() ([]byte, error)
+	// Close stops the subscription, it can be called while Receive blocks
+	Close() error
+}
+
+// ReapStats tells how many keys were reaped
+type ReapStats struct {
+	Runs     int
+	Snippets int
+}
+
+// Kinds of the store
+const (
+	StoreRedis  = "redis"
+	StoreMemory = "memory"
+)
+
+// NewStore creates the store given by the config
+func NewStore(cfg *Config) (Store, error) {
+	switch cfg.GetStore() {
+	case StoreRedis:
+		store := NewRedisStore(cfg.Redis)
+		if err := store.Ping(); err != nil {
+			return nil, fmt.Errorf("cannot connect to Redis at %s - %v", cfg.Redis.describe(), err)
+		}
+		return store, nil
+	case StoreMemory:
+		return NewMemoryStore(), nil
+	}
+
+	return nil, fmt.Errorf("%s is not a store, use redis or memory", cfg.Store)
+}
@@ -0,0 +1,517 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps everything in the memory of a single server, so it can
+// run without Redis. The runners are stored as JSON so they are not shared
+// between the requests.
+type MemoryStore struct {
+	mu          sync.Mutex
+	runs        map[string]memoryEntry
+	snippets    map[string]memoryEntry
+	revisions   map[string][][]byte
+	apiKeys     map[string]APIKey
+	keyUsage    map[string]*memoryKeyUsage
+	buckets     map[string]memoryBucket
+	semaphores  map[string]map[string]time.Time // The holders by the semaphores, with when their leases end
+	jobs        chan []byte
+	subscribers map[string]map[*memorySubscription]bool
+}
+
+type memoryKeyUsage struct {
+	running []time.Time // When the runs not finished yet were started
+	day     string
+	today   int
+	cpu     time.Duration
+}
+
+// maxMemoryJobs is how many jobs can wait for a worker in the memory
+const maxMemoryJobs = 1024
+
+// errJobsFull is returned when no more jobs can wait for a worker
+var errJobsFull = errors.New("too many jobs are waiting for a worker")
+
+type memoryBucket struct {
+	tokens float64
+	at     time.Time
+	full   time.Time // When the bucket can be dropped, as it's full again
+}
+
+type memoryEntry struct {
+	runner    []byte
+	expiresAt *time.Time
+}
+
+// NewMemoryStore creates an empty store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		runs:        map[string]memoryEntry{},
+		snippets:    map[string]memoryEntry{},
+		revisions:   map[string][][]byte{},
+		apiKeys:     map[string]APIKey{},
+		keyUsage:    map[string]*memoryKeyUsage{},
+		buckets:     map[string]memoryBucket{},
+		semaphores:  map[string]map[string]time.Time{},
+		jobs:        make(chan []byte, maxMemoryJobs),
+		subscribers: map[string]map[*memorySubscription]bool{},
+	}
+}
+
+// SaveRun stores the runner as a run ticket under the UUID
+func (s *MemoryStore) SaveRun(uuid string, runner *Runner) error {
+	return s.set(s.runs, uuid, runner)
+}
+
+// FetchRun loads the run ticket
+func (s *MemoryStore) FetchRun(uuid string) (*Runner, error) {
+	return s.get(s.runs, uuid)
+}
+
+// DeleteRun removes the run ticket
+func (s *MemoryStore) DeleteRun(uuid string) error {
+	s.del(s.runs, uuid)
+	return nil
+}
+
+// SaveSnippet stores the runner as a snippet under the codeID
+func (s *MemoryStore) SaveSnippet(codeID string, runner *Runner) error {
+	return s.set(s.snippets, codeID, runner)
+}
+
+// FetchSnippet loads the snippet
+func (s *MemoryStore) FetchSnippet(codeID string) (*Runner, error) {
+	return s.get(s.snippets, codeID)
+}
+
+// DeleteSnippet removes the snippet with its revisions
+func (s *MemoryStore) DeleteSnippet(codeID string) (bool, error) {
+	deleted := s.del(s.snippets, codeID)
+
+	s.mu.Lock()
+	delete(s.revisions, codeID)
+	s.mu.Unlock()
+
+	return deleted, nil
+}
+
+// SaveRevision adds the runner as a revision of the snippet
+func (s *MemoryStore) SaveRevision(codeID string, runner *Runner, max int) error {
+	bts, err := json.Marshal(runner)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	revisions := append(s.revisions[codeID], bts)
+	if len(revisions) > max {
+		revisions = revisions[len(revisions)-max:]
+	}
+	s.revisions[codeID] = revisions
+	return nil
+}
+
+// FetchRevisions loads the revisions of the snippet
+func (s *MemoryStore) FetchRevisions(codeID string) ([]*Runner, error) {
+	s.mu.Lock()
+	revisions := s.revisions[codeID]
+	s.mu.Unlock()
+
+	runners := make([]*Runner, 0, len(revisions))
+	for _, bts := range revisions {
+		runner := &Runner{}
+		if err := json.Unmarshal(bts, runner); err != nil {
+			return nil, err
+		}
+		runners = append(runners, runner)
+	}
+	return runners, nil
+}
+
+// Reap removes the run tickets and the snippets expired by now
+func (s *MemoryStore) Reap(now time.Time) (ReapStats, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// The buckets are not told about, they are as good as full once dropped
+	for key, bucket := range s.buckets {
+		if !now.Before(bucket.full) {
+			delete(s.buckets, key)
+		}
+	}
+
+	return ReapStats{
+		Runs:     reapEntries(s.runs, now, nil),
+		Snippets: reapEntries(s.snippets, now, s.revisions),
+	}, nil
+}
+
+// reapEntries removes the expired entries of m, with their revisions
+func reapEntries(m map[string]memoryEntry, now time.Time, revisions map[string][][]byte) int {
+	reaped := 0
+	for key, entry := range m {
+		if entry.expiresAt != nil && !now.Before(*entry.expiresAt) {
+			delete(m, key)
+			delete(revisions, key)
+			reaped++
+		}
+	}
+	return reaped
+}
+
+// SaveAPIKey stores a new API key under its ID
+func (s *MemoryStore) SaveAPIKey(key *APIKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.apiKeys[key.ID]; ok {
+		return ErrAPIKeyExists
+	}
+	s.apiKeys[key.ID] = *key
+	return nil
+}
+
+// FetchAPIKey loads the API key
+func (s *MemoryStore) FetchAPIKey(id string) (*APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key, ok := s.apiKeys[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &key, nil
+}
+
+// ListAPIKeys loads all the API keys
+func (s *MemoryStore) ListAPIKeys() ([]*APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keys := make([]*APIKey, 0, len(s.apiKeys))
+	for _, key := range s.apiKeys {
+		key := key
+		keys = append(keys, &key)
+	}
+	return keys, nil
+}
+
+// DeleteAPIKey removes the API key with its usage
+func (s *MemoryStore) DeleteAPIKey(id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.apiKeys[id]
+	delete(s.apiKeys, id)
+	delete(s.keyUsage, id)
+	return ok, nil
+}
+
+// StartKeyRun counts a run of the key started on the day
+func (s *MemoryStore) StartKeyRun(key *APIKey, day string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	usage := s.usageOf(key.ID, day)
+	switch {
+	case key.MaxConcurrentRuns > 0 && len(usage.running) >= key.MaxConcurrentRuns:
+		return &QuotaExceededError{Quota: QuotaConcurrentRuns}
+	case key.MaxRunsPerDay > 0 && usage.today >= key.MaxRunsPerDay:
+		return &QuotaExceededError{Quota: QuotaRunsPerDay}
+	case key.MaxCPUSeconds > 0 && usage.cpu >= time.Duration(key.MaxCPUSeconds)*time.Second:
+		return &QuotaExceededError{Quota: QuotaCPUSeconds}
+	}
+
+	usage.running = append(usage.running, time.Now())
+	usage.today++
+	return nil
+}
+
+// FinishKeyRun counts a run of the key as finished
+func (s *MemoryStore) FinishKeyRun(key *APIKey, cpu time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	usage := s.usageOf(key.ID, "")
+	if len(usage.running) > 0 {
+		usage.running = usage.running[1:]
+	}
+	usage.cpu += cpu
+	return nil
+}
+
+// FetchKeyUsage loads how much of its quotas the key has used on the day
+func (s *MemoryStore) FetchKeyUsage(id, day string) (KeyUsage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	usage := s.usageOf(id, day)
+	return KeyUsage{
+		ConcurrentRuns: len(usage.running),
+		RunsToday:      usage.today,
+		CPUTime:        usage.cpu,
+	}, nil
+}
+
+// usageOf returns the usage of the key, whose runs per day start again on
+// another day, and whose runs started before the lease are dropped
+func (s *MemoryStore) usageOf(id, day string) *memoryKeyUsage {
+	usage := s.keyUsage[id]
+	if usage == nil {
+		usage = &memoryKeyUsage{day: day}
+		s.keyUsage[id] = usage
+	}
+
+	if day != "" && usage.day != day {
+		usage.day = day
+		usage.today = 0
+	}
+
+	for len(usage.running) > 0 && time.Since(usage.running[0]) > keyRunLease {
+		usage.running = usage.running[1:]
+	}
+	return usage
+}
+
+// TakeToken takes a token from the bucket limited by the limit
+func (s *MemoryStore) TakeToken(bucket string, limit RateLimit, now time.Time) (bool, float64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tokens := limit.burst()
+	if b, ok := s.buckets[bucket]; ok {
+		tokens = limit.refill(b.tokens, b.at, now)
+	}
+
+	allowed := tokens >= 1
+	if allowed {
+		tokens--
+	}
+
+	s.buckets[bucket] = memoryBucket{tokens: tokens, at: now, full: now.Add(limit.fullIn(tokens))}
+	return allowed, tokens, nil
+}
+
+// AcquireSlots takes a slot of every semaphore for the holder
+func (s *MemoryStore) AcquireSlots(sems []Semaphore, holder string, lease time.Duration) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for i, sem := range sems {
+		if s.holdersOf(sem.Name, now) >= sem.Limit {
+			return i, nil
+		}
+	}
+
+	for _, sem := range sems {
+		s.semaphores[sem.Name][holder] = now.Add(lease)
+	}
+	return -1, nil
+}
+
+// RenewSlots extends the lease of the slots of the holder
+func (s *MemoryStore) RenewSlots(sems []Semaphore, holder string, lease time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	held := true
+	for _, sem := range sems {
+		s.holdersOf(sem.Name, time.Now())
+		if _, ok := s.semaphores[sem.Name][holder]; !ok {
+			held = false
+			continue
+		}
+		s.semaphores[sem.Name][holder] = time.Now().Add(lease)
+	}
+	return held, nil
+}
+
+// ReleaseSlots gives the slots of the holder back
+func (s *MemoryStore) ReleaseSlots(sems []Semaphore, holder string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, sem := range sems {
+		delete(s.semaphores[sem.Name], holder)
+	}
+	return nil
+}
+
+// CountSlots tells how many slots of the semaphore are taken
+func (s *MemoryStore) CountSlots(name string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.holdersOf(name, time.Now()), nil
+}
+
+// holdersOf drops the holders of the semaphore whose leases have ended, and
+// counts the others
+func (s *MemoryStore) holdersOf(name string, now time.Time) int {
+	holders := s.semaphores[name]
+	if holders == nil {
+		holders = map[string]time.Time{}
+		s.semaphores[name] = holders
+	}
+
+	for holder, until := range holders {
+		if !now.Before(until) {
+			delete(holders, holder)
+		}
+	}
+	return len(holders)
+}
+
+// PushJob adds the job to the end of the job queue
+func (s *MemoryStore) PushJob(job []byte) error {
+	select {
+	case s.jobs <- job:
+		return nil
+	default:
+		return errJobsFull
+	}
+}
+
+// PopJob takes the job at the front of the job queue
+func (s *MemoryStore) PopJob(timeout time.Duration) ([]byte, error) {
+	select {
+	case job := <-s.jobs:
+		return job, nil
+	case <-time.After(timeout):
+		return nil, ErrNotFound
+	}
+}
+
+// CountJobs tells how many jobs are waiting for a worker
+func (s *MemoryStore) CountJobs() (int, error) {
+	return len(s.jobs), nil
+}
+
+// PublishStdin delivers the input to the subscriptions of the run
+func (s *MemoryStore) PublishStdin(uuid string, input []byte) error {
+	return s.Publish(uuid+"#stdin", input)
+}
+
+// SubscribeStdin starts receiving the stdin published to the run
+func (s *MemoryStore) SubscribeStdin(uuid string) (Subscription, error) {
+	return s.Subscribe(uuid + "#stdin")
+}
+
+// Publish delivers the message to the subscriptions of the channel
+func (s *MemoryStore) Publish(channel string, message []byte) error {
+	s.mu.Lock()
+	subs := make([]*memorySubscription, 0, len(s.subscribers[channel]))
+	for sub := range s.subscribers[channel] {
+		subs = append(subs, sub)
+	}
+	s.mu.Unlock()
+
+	for _, sub := range subs {
+		select {
+		case sub.messages <- message:
+		case <-sub.done:
+		}
+	}
+	return nil
+}
+
+// Subscribe starts receiving the messages published to the channel
+func (s *MemoryStore) Subscribe(channel string) (Subscription, error) {
+	sub := &memorySubscription{
+		store:    s,
+		channel:  channel,
+		messages: make(chan []byte, 16),
+		done:     make(chan struct{}),
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.subscribers[channel] == nil {
+		s.subscribers[channel] = map[*memorySubscription]bool{}
+	}
+	s.subscribers[channel][sub] = true
+
+	return sub, nil
+}
+
+func (s *MemoryStore) set(m map[string]memoryEntry, key string, runner *Runner) error {
+	bts, err := json.Marshal(runner)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m[key] = memoryEntry{runner: bts, expiresAt: runner.ExpiresAt}
+	return nil
+}
+
+func (s *MemoryStore) get(m map[string]memoryEntry, key string) (*Runner, error) {
+	s.mu.Lock()
+	entry, ok := m[key]
+	s.mu.Unlock()
+
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	runner := &Runner{}
+	if err := json.Unmarshal(entry.runner, runner); err != nil {
+		return nil, err
+	}
+
+	if runner.expired(time.Now()) {
+		return nil, ErrNotFound
+	}
+	return runner, nil
+}
+
+func (s *MemoryStore) del(m map[string]memoryEntry, key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := m[key]
+	delete(m, key)
+	return ok && (entry.expiresAt == nil || time.Now().Before(*entry.expiresAt))
+}
+
+type memorySubscription struct {
+	store    *MemoryStore
+	channel  string
+	messages chan []byte
+	done     chan struct{}
+	once     sync.Once
+}
+
+func (sub *memorySubscription) Receive() ([]byte, error) {
+	select {
+	case input := <-sub.messages:
+		return input, nil
+	case <-sub.done:
+		return nil, io.EOF
+	}
+}
+
+func (sub *memorySubscription) Close() error {
+	sub.once.Do(func() {
+		sub.store.mu.Lock()
+		delete(sub.store.subscribers[sub.channel], sub)
+		if len(sub.store.subscribers[sub.channel]) == 0 {
+			delete(sub.store.subscribers, sub.channel)
+		}
+		sub.store.mu.Unlock()
+
+		close(sub.done)
+	})
+	return nil
+}
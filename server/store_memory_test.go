@@ -0,0 +1,109 @@
+package main
+
+import (
+	"io"
+	"testing"
+	"time"
+)
+
+func TestMemoryStoreRuns(t *testing.T) {
+	store := NewMemoryStore()
+
+	if err := store.SaveRun("abc", &Runner{Lang: "ruby", Source: "puts 1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	runner, err := store.FetchRun("abc")
+	if err != nil || runner.Lang != "ruby" || runner.Source != "puts 1" {
+		t.Fatalf("Unexpected run %+v - %v", runner, err)
+	}
+
+	store.DeleteRun("abc")
+	if _, err := store.FetchRun("abc"); err != ErrNotFound {
+		t.Fatalf("Expected the run to be deleted, got %v", err)
+	}
+}
+
+func TestMemoryStoreAPIKeys(t *testing.T) {
+	store := NewMemoryStore()
+
+	if err := store.SaveAPIKey(&APIKey{ID: "abc", Name: "ci"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.SaveAPIKey(&APIKey{ID: "abc", Name: "other"}); err != ErrAPIKeyExists {
+		t.Fatalf("Expected the ID to be taken, got %v", err)
+	}
+
+	if key, err := store.FetchAPIKey("abc"); err != nil || key.Name != "ci" {
+		t.Fatalf("Expected the first key to be kept, got %+v - %v", key, err)
+	}
+}
+
+func TestMemoryStoreSnippets(t *testing.T) {
+	store := NewMemoryStore()
+	store.SaveSnippet("abc", &Runner{Lang: "go"})
+
+	if deleted, _ := store.DeleteSnippet("abc"); !deleted {
+		t.Fatal("The snippet should be deleted")
+	}
+
+	if deleted, _ := store.DeleteSnippet("abc"); deleted {
+		t.Fatal("The snippet should not exist anymore")
+	}
+}
+
+func TestMemoryStoreStdin(t *testing.T) {
+	store := NewMemoryStore()
+
+	sub, err := store.SubscribeStdin("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store.PublishStdin("abc", []byte("42\n"))
+	store.PublishStdin("other", []byte("nope\n"))
+
+	data, err := sub.Receive()
+	if err != nil || string(data) != "42\n" {
+		t.Fatalf("Expected the published stdin, got %q - %v", data, err)
+	}
+
+	sub.Close()
+	if _, err := sub.Receive(); err != io.EOF {
+		t.Fatalf("Expected the subscription to be closed, got %v", err)
+	}
+
+	// Nobody is subscribed anymore
+	if err := store.PublishStdin("abc", []byte("1\n")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMemoryStoreReap(t *testing.T) {
+	store := NewMemoryStore()
+
+	past := time.Now().Add(-time.Minute)
+	future := time.Now().Add(time.Hour)
+	store.SaveRun("expired", &Runner{ExpiresAt: &past})
+	store.SaveRun("alive", &Runner{ExpiresAt: &future})
+	store.SaveSnippet("expired", &Runner{ExpiresAt: &past})
+	store.SaveSnippet("pinned", &Runner{Pinned: true})
+
+	if _, err := store.FetchRun("expired"); err != ErrNotFound {
+		t.Fatalf("Expected the expired run not to be found, got %v", err)
+	}
+
+	stats, err := store.Reap(time.Now())
+	if err != nil || stats.Runs != 1 || stats.Snippets != 1 {
+		t.Fatalf("Expected a run and a snippet to be reaped, got %+v - %v", stats, err)
+	}
+
+	if _, err := store.FetchRun("alive"); err != nil {
+		t.Fatalf("The run should not be reaped yet - %v", err)
+	}
+
+	if _, err := store.FetchSnippet("pinned"); err != nil {
+		t.Fatalf("The pinned snippet should never be reaped - %v", err)
+	}
+}
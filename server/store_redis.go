@@ -0,0 +1,818 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// RedisStore keeps everything in Redis, so the runs can be registered and run
+// by different servers. The keys are suffixed by their kind (#run, #snippet)
+// and the stdin is published to the uuid#stdin channel. The revisions of a
+// snippet are kept in the codeID#revisions list. The IDs of the keys which
+// expire are kept in the run#expiry and snippet#expiry sorted sets, scored by
+// the Unix time they expire at. The token buckets of the rate limits are
+// kept in the ratelimit#bucket hashes, and the holders of the semaphores in the
+// semaphore#name sorted sets, scored by the Unix time in ms their leases end.
+// The jobs wait for the workers in the run#jobs list, and their output and
+// input go over the uuid#output and uuid#input channels.
+type RedisStore struct {
+	pool *redis.Pool
+	cfg  RedisConfig
+
+	mu     sync.Mutex
+	master string // Told by the sentinels, asked again once it cannot be used
+}
+
+// NewRedisStore creates a store with a pool of connections described by cfg
+func NewRedisStore(cfg RedisConfig) *RedisStore {
+	s := &RedisStore{cfg: cfg}
+	s.pool = &redis.Pool{
+		Dial: func() (redis.Conn, error) {
+			return s.dial(cfg.GetReadTimeout())
+		},
+		TestOnBorrow: s.testOnBorrow,
+		MaxIdle:      cfg.GetMaxIdle(),
+		MaxActive:    cfg.MaxActive,
+		IdleTimeout:  cfg.GetIdleTimeout(),
+		Wait:         cfg.MaxActive > 0,
+	}
+
+	return s
+}
+
+// Ping makes sure Redis can be reached
+func (s *RedisStore) Ping() error {
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	_, err := conn.Do("PING")
+	return err
+}
+
+// dial connects to Redis, or the master told by the sentinels
+func (s *RedisStore) dial(readTimeout time.Duration) (redis.Conn, error) {
+	address := s.cfg.GetAddress()
+	if len(s.cfg.SentinelAddresses) > 0 {
+		var err error
+		if address, err = s.masterOf(); err != nil {
+			return nil, err
+		}
+	}
+
+	options := []redis.DialOption{
+		redis.DialConnectTimeout(s.cfg.GetDialTimeout()),
+		redis.DialReadTimeout(readTimeout),
+		redis.DialWriteTimeout(s.cfg.GetWriteTimeout()),
+		redis.DialPassword(s.cfg.Password),
+		redis.DialDatabase(s.cfg.DB),
+	}
+
+	if s.cfg.TLS {
+		tlsConfig := &tls.Config{InsecureSkipVerify: s.cfg.TLSSkipVerify}
+		if host, _, err := net.SplitHostPort(address); err == nil {
+			tlsConfig.ServerName = host
+		}
+
+		dialer := &net.Dialer{Timeout: s.cfg.GetDialTimeout()}
+		options = append(options, redis.DialNetDial(func(network, addr string) (net.Conn, error) {
+			return tls.DialWithDialer(dialer, network, addr, tlsConfig)
+		}))
+	}
+
+	conn, err := redis.Dial("tcp", address, options...)
+	if err != nil {
+		metrics.redisErrors.Inc()
+		s.forgetMaster(address)
+		return nil, err
+	}
+	return &metricConn{Conn: conn, store: s, address: address}, nil
+}
+
+// errReadOnly keeps a connection to a replica out of the pool
+var errReadOnly = errors.New("the connection is not to the master anymore")
+
+// metricConn counts the commands failed on the connection. A connection
+// which has become one to a replica is not taken back by the pool, and the
+// master is asked from the sentinels again.
+type metricConn struct {
+	redis.Conn
+	store    *RedisStore
+	address  string
+	readOnly bool
+}
+
+func (c *metricConn) Do(command string, args ...interface{}) (interface{}, error) {
+	reply, err := c.Conn.Do(command, args...)
+	// The scripts are loaded by their first use
+	if err != nil && !strings.HasPrefix(err.Error(), "NOSCRIPT") {
+		metrics.redisErrors.Inc()
+	}
+	if err != nil && strings.HasPrefix(err.Error(), "READONLY") {
+		c.readOnly = true
+		c.store.forgetMaster(c.address)
+	}
+	return reply, err
+}
+
+func (c *metricConn) Err() error {
+	if c.readOnly {
+		return errReadOnly
+	}
+	return c.Conn.Err()
+}
+
+// masterOf returns the address of the master, asking the sentinels only when
+// it's not known
+func (s *RedisStore) masterOf() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.master == "" {
+		address, err := s.masterAddress()
+		if err != nil {
+			return "", err
+		}
+		s.master = address
+	}
+	return s.master, nil
+}
+
+// forgetMaster makes the next connection ask the sentinels for the master,
+// unless it has been asked again since address
+func (s *RedisStore) forgetMaster(address string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.master == address {
+		s.master = ""
+	}
+}
+
+// masterAddress asks the sentinels one by one for the address of the master
+func (s *RedisStore) masterAddress() (string, error) {
+	var lastErr error
+
+	for _, sentinel := range s.cfg.SentinelAddresses {
+		conn, err := redis.Dial("tcp", sentinel,
+			redis.DialConnectTimeout(s.cfg.GetDialTimeout()),
+			redis.DialReadTimeout(s.cfg.GetReadTimeout()),
+			redis.DialWriteTimeout(s.cfg.GetWriteTimeout()),
+			redis.DialPassword(s.cfg.SentinelPassword),
+		)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		reply, err := redis.Strings(conn.Do("SENTINEL", "get-master-addr-by-name", s.cfg.SentinelMaster))
+		conn.Close()
+		if err == nil && len(reply) != 2 {
+			err = fmt.Errorf("unexpected reply %v", reply)
+		}
+		if err != nil {
+			lastErr = fmt.Errorf("sentinel %s doesn't know the master %s - %v", sentinel, s.cfg.SentinelMaster, err)
+			continue
+		}
+
+		return net.JoinHostPort(reply[0], reply[1]), nil
+	}
+
+	return "", fmt.Errorf("no sentinel can tell the master - %v", lastErr)
+}
+
+// testOnBorrow checks the connections idle for a while. With sentinels the
+// connection must still be to the master, which changes on failover. The
+// connections in use find out by the READONLY errors.
+func (s *RedisStore) testOnBorrow(conn redis.Conn, idleSince time.Time) error {
+	if time.Since(idleSince) < time.Minute {
+		return nil
+	}
+
+	if len(s.cfg.SentinelAddresses) > 0 {
+		role, err := redis.Values(conn.Do("ROLE"))
+		if err != nil {
+			return err
+		}
+		if len(role) == 0 || fmt.Sprintf("%s", role[0]) != "master" {
+			if c, ok := conn.(*metricConn); ok {
+				s.forgetMaster(c.address)
+			}
+			return errReadOnly
+		}
+		return nil
+	}
+
+	_, err := conn.Do("PING")
+	return err
+}
+
+// SaveRun stores the runner as a run ticket under the UUID
+func (s *RedisStore) SaveRun(uuid string, runner *Runner) error {
+	return s.set("run", uuid, runner)
+}
+
+// FetchRun loads the run ticket
+func (s *RedisStore) FetchRun(uuid string) (*Runner, error) {
+	return s.get("run", uuid)
+}
+
+// DeleteRun removes the run ticket
+func (s *RedisStore) DeleteRun(uuid string) error {
+	_, err := s.del("run", uuid)
+	return err
+}
+
+// SaveSnippet stores the runner as a snippet under the codeID
+func (s *RedisStore) SaveSnippet(codeID string, runner *Runner) error {
+	return s.set("snippet", codeID, runner)
+}
+
+// FetchSnippet loads the snippet
+func (s *RedisStore) FetchSnippet(codeID string) (*Runner, error) {
+	return s.get("snippet", codeID)
+}
+
+// DeleteSnippet removes the snippet with its revisions
+func (s *RedisStore) DeleteSnippet(codeID string) (bool, error) {
+	return s.del("snippet", codeID, codeID+"#revisions")
+}
+
+// SaveRevision pushes the runner to the revisions of the snippet
+func (s *RedisStore) SaveRevision(codeID string, runner *Runner, max int) error {
+	bts, err := json.Marshal(runner)
+	if err != nil {
+		return err
+	}
+
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	conn.Send("MULTI")
+	conn.Send("RPUSH", codeID+"#revisions", string(bts))
+	conn.Send("LTRIM", codeID+"#revisions", -max, -1)
+	_, err = conn.Do("EXEC")
+	return err
+}
+
+// FetchRevisions loads the revisions of the snippet
+func (s *RedisStore) FetchRevisions(codeID string) ([]*Runner, error) {
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	values, err := redis.ByteSlices(conn.Do("LRANGE", codeID+"#revisions", 0, -1))
+	if err != nil {
+		return nil, err
+	}
+
+	runners := make([]*Runner, 0, len(values))
+	for _, value := range values {
+		runner := &Runner{}
+		if err := json.Unmarshal(value, runner); err != nil {
+			return nil, err
+		}
+		runners = append(runners, runner)
+	}
+	return runners, nil
+}
+
+// reapScript deletes the keys of a kind whose IDs expired in the sorted set,
+// with the id#revisions of the snippets, and returns how many were still there
+var reapScript = redis.NewScript(1, `
+local ids = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1])
+local reaped = 0
+for _, id in ipairs(ids) do
+	reaped = reaped + redis.call('DEL', id .. '#' .. ARGV[2])
+	if ARGV[2] == 'snippet' then
+		redis.call('DEL', id .. '#revisions')
+	end
+	redis.call('ZREM', KEYS[1], id)
+end
+return reaped
+`)
+
+// Reap removes the run tickets and the snippets expired by now
+func (s *RedisStore) Reap(now time.Time) (ReapStats, error) {
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	var stats ReapStats
+	var err error
+
+	stats.Runs, err = redis.Int(reapScript.Do(conn, "run#expiry", now.Unix(), "run"))
+	if err != nil {
+		return stats, err
+	}
+
+	stats.Snippets, err = redis.Int(reapScript.Do(conn, "snippet#expiry", now.Unix(), "snippet"))
+	return stats, err
+}
+
+// SaveAPIKey stores a new API key under apikey#ID, and its ID in the apikeys
+// set. The ID is already in the set when the key exists.
+func (s *RedisStore) SaveAPIKey(key *APIKey) error {
+	bts, err := json.Marshal(key)
+	if err != nil {
+		return err
+	}
+
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	conn.Send("MULTI")
+	conn.Send("SET", "apikey#"+key.ID, string(bts), "NX")
+	conn.Send("SADD", "apikeys", key.ID)
+	values, err := redis.Values(conn.Do("EXEC"))
+	if err != nil {
+		return err
+	}
+
+	if len(values) == 0 || values[0] == nil {
+		return ErrAPIKeyExists
+	}
+	return nil
+}
+
+// FetchAPIKey loads the API key
+func (s *RedisStore) FetchAPIKey(id string) (*APIKey, error) {
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	return fetchAPIKey(conn, id)
+}
+
+func fetchAPIKey(conn redis.Conn, id string) (*APIKey, error) {
+	value, err := redis.Bytes(conn.Do("GET", "apikey#"+id))
+	if err == redis.ErrNil {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	key := &APIKey{}
+	err = json.Unmarshal(value, key)
+	return key, err
+}
+
+// ListAPIKeys loads all the API keys
+func (s *RedisStore) ListAPIKeys() ([]*APIKey, error) {
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	ids, err := redis.Strings(conn.Do("SMEMBERS", "apikeys"))
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]*APIKey, 0, len(ids))
+	for _, id := range ids {
+		key, err := fetchAPIKey(conn, id)
+		if err == ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// DeleteAPIKey removes the API key with its usage, the runs per day expire by
+// themselves
+func (s *RedisStore) DeleteAPIKey(id string) (bool, error) {
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	conn.Send("MULTI")
+	conn.Send("DEL", "apikey#"+id)
+	conn.Send("SREM", "apikeys", id)
+	conn.Send("DEL", "apikey#"+id+"#running", "apikey#"+id+"#cpu_ms")
+	replies, err := redis.Values(conn.Do("EXEC"))
+	if err != nil {
+		return false, err
+	}
+
+	n, err := redis.Int(replies[0], nil)
+	return n > 0, err
+}
+
+// startKeyRunScript checks the quotas of the key and counts the run. The runs
+// not finished are kept in a sorted set scored by when they started, so the
+// ones started before the lease can be dropped.
+var startKeyRunScript = redis.NewScript(3, `
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', ARGV[1] - ARGV[2])
+local running = redis.call('ZCARD', KEYS[1])
+local today = tonumber(redis.call('GET', KEYS[2]) or '0')
+local cpu = tonumber(redis.call('GET', KEYS[3]) or '0')
+
+if tonumber(ARGV[3]) > 0 and running >= tonumber(ARGV[3]) then
+	return 'concurrent_runs'
+end
+if tonumber(ARGV[4]) > 0 and today >= tonumber(ARGV[4]) then
+	return 'runs_per_day'
+end
+if tonumber(ARGV[5]) > 0 and cpu >= tonumber(ARGV[5]) then
+	return 'cpu_seconds'
+end
+
+redis.call('ZADD', KEYS[1], ARGV[1], ARGV[6])
+redis.call('PEXPIRE', KEYS[1], ARGV[2])
+redis.call('INCR', KEYS[2])
+redis.call('EXPIRE', KEYS[2], 172800)
+return ''
+`)
+
+// finishKeyRunScript drops the oldest run not finished and adds the CPU time
+var finishKeyRunScript = redis.NewScript(2, `
+local oldest = redis.call('ZRANGE', KEYS[1], 0, 0)
+if oldest[1] then
+	redis.call('ZREM', KEYS[1], oldest[1])
+end
+redis.call('INCRBY', KEYS[2], ARGV[1])
+return 1
+`)
+
+// StartKeyRun counts a run of the key started on the day
+func (s *RedisStore) StartKeyRun(key *APIKey, day string) error {
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	member, err := newRandomHex(8)
+	if err != nil {
+		return err
+	}
+
+	quota, err := redis.String(startKeyRunScript.Do(conn,
+		"apikey#"+key.ID+"#running", "apikey#"+key.ID+"#runs#"+day, "apikey#"+key.ID+"#cpu_ms",
+		unixMillis(time.Now()), int64(keyRunLease/time.Millisecond),
+		key.MaxConcurrentRuns, key.MaxRunsPerDay, key.MaxCPUSeconds*1000, member))
+	if err != nil {
+		return err
+	}
+
+	if quota != "" {
+		return &QuotaExceededError{Quota: quota}
+	}
+	return nil
+}
+
+// FinishKeyRun counts a run of the key as finished
+func (s *RedisStore) FinishKeyRun(key *APIKey, cpu time.Duration) error {
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	_, err := finishKeyRunScript.Do(conn,
+		"apikey#"+key.ID+"#running", "apikey#"+key.ID+"#cpu_ms", int64(cpu/time.Millisecond))
+	return err
+}
+
+// FetchKeyUsage loads how much of its quotas the key has used on the day
+func (s *RedisStore) FetchKeyUsage(id, day string) (KeyUsage, error) {
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	since := unixMillis(time.Now().Add(-keyRunLease))
+	conn.Send("ZCOUNT", "apikey#"+id+"#running", since, "+inf")
+	conn.Send("GET", "apikey#"+id+"#runs#"+day)
+	conn.Send("GET", "apikey#"+id+"#cpu_ms")
+	conn.Flush()
+
+	var usage KeyUsage
+	var err error
+	if usage.ConcurrentRuns, err = redis.Int(conn.Receive()); err != nil {
+		return usage, err
+	}
+	if usage.RunsToday, err = intOrZero(conn.Receive()); err != nil {
+		return usage, err
+	}
+
+	cpu, err := intOrZero(conn.Receive())
+	usage.CPUTime = time.Duration(cpu) * time.Millisecond
+	return usage, err
+}
+
+func intOrZero(reply interface{}, err error) (int, error) {
+	n, err := redis.Int(reply, err)
+	if err == redis.ErrNil {
+		return 0, nil
+	}
+	return n, err
+}
+
+func unixMillis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+// takeTokenScript refills the bucket kept in a hash since it was last taken
+// from, and takes a token if there is one. The bucket expires once it's full
+// again. The tokens are returned as a string, Lua numbers are turned into
+// integers.
+var takeTokenScript = redis.NewScript(1, `
+local burst = tonumber(ARGV[1])
+local per_ms = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+
+local bucket = redis.call('HMGET', KEYS[1], 'tokens', 'at')
+local tokens = burst
+if bucket[1] then
+	tokens = math.min(burst, tonumber(bucket[1]) + math.max(0, now - tonumber(bucket[2])) * per_ms)
+end
+
+local allowed = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+end
+
+redis.call('HMSET', KEYS[1], 'tokens', tostring(tokens), 'at', now)
+redis.call('PEXPIRE', KEYS[1], math.ceil((burst - tokens) / per_ms) + 1)
+return {allowed, tostring(tokens)}
+`)
+
+// TakeToken takes a token from the bucket kept under ratelimit#bucket
+func (s *RedisStore) TakeToken(bucket string, limit RateLimit, now time.Time) (bool, float64, error) {
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	reply, err := redis.Values(takeTokenScript.Do(conn, "ratelimit#"+bucket,
+		limit.burst(), strconv.FormatFloat(limit.perSecond()/1000, 'g', -1, 64), unixMillis(now)))
+	if err != nil {
+		return false, 0, err
+	}
+
+	var allowed int
+	var tokens string
+	if _, err := redis.Scan(reply, &allowed, &tokens); err != nil {
+		return false, 0, err
+	}
+
+	left, err := strconv.ParseFloat(tokens, 64)
+	return allowed == 1, left, err
+}
+
+// acquireSlotsScript drops the holders whose leases have ended, and adds the
+// holder to every semaphore if none of them is full. It returns the index of
+// the full one, or -1.
+var acquireSlotsScript = redis.NewScript(-1, `
+local now, until, holder = ARGV[1], ARGV[2], ARGV[3]
+for i, key in ipairs(KEYS) do
+	redis.call('ZREMRANGEBYSCORE', key, '-inf', now)
+	if redis.call('ZCARD', key) >= tonumber(ARGV[3 + i]) then
+		return i - 1
+	end
+end
+
+for _, key in ipairs(KEYS) do
+	redis.call('ZADD', key, until, holder)
+	redis.call('PEXPIREAT', key, until)
+end
+return -1
+`)
+
+// renewSlotsScript extends the leases the holder still has, and returns how
+// many of them it had
+var renewSlotsScript = redis.NewScript(-1, `
+local held = 0
+for _, key in ipairs(KEYS) do
+	local until = redis.call('ZSCORE', key, ARGV[3])
+	if until and tonumber(until) > tonumber(ARGV[1]) then
+		redis.call('ZADD', key, ARGV[2], ARGV[3])
+		redis.call('PEXPIREAT', key, ARGV[2])
+		held = held + 1
+	end
+end
+return held
+`)
+
+// AcquireSlots takes a slot of every semaphore for the holder, in the
+// semaphore#name sorted sets
+func (s *RedisStore) AcquireSlots(sems []Semaphore, holder string, lease time.Duration) (int, error) {
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	now := time.Now()
+	args := []interface{}{len(sems)}
+	for _, sem := range sems {
+		args = append(args, "semaphore#"+sem.Name)
+	}
+	args = append(args, unixMillis(now), unixMillis(now.Add(lease)), holder)
+	for _, sem := range sems {
+		args = append(args, sem.Limit)
+	}
+
+	return redis.Int(acquireSlotsScript.Do(conn, args...))
+}
+
+// RenewSlots extends the lease of the slots of the holder
+func (s *RedisStore) RenewSlots(sems []Semaphore, holder string, lease time.Duration) (bool, error) {
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	args := []interface{}{len(sems)}
+	for _, sem := range sems {
+		args = append(args, "semaphore#"+sem.Name)
+	}
+	now := time.Now()
+	args = append(args, unixMillis(now), unixMillis(now.Add(lease)), holder)
+
+	held, err := redis.Int(renewSlotsScript.Do(conn, args...))
+	return held == len(sems), err
+}
+
+// ReleaseSlots gives the slots of the holder back
+func (s *RedisStore) ReleaseSlots(sems []Semaphore, holder string) error {
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	for _, sem := range sems {
+		conn.Send("ZREM", "semaphore#"+sem.Name, holder)
+	}
+	_, err := conn.Do("")
+	return err
+}
+
+// CountSlots tells how many slots of the semaphore are taken
+func (s *RedisStore) CountSlots(name string) (int, error) {
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	return redis.Int(conn.Do("ZCOUNT", "semaphore#"+name, unixMillis(time.Now()), "+inf"))
+}
+
+// PushJob adds the job to the end of the run#jobs list
+func (s *RedisStore) PushJob(job []byte) error {
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	_, err := conn.Do("LPUSH", "run#jobs", job)
+	return err
+}
+
+// PopJob takes the job at the front of the run#jobs list. The connection
+// waits for the job without a read timeout.
+func (s *RedisStore) PopJob(timeout time.Duration) ([]byte, error) {
+	conn, err := s.dial(0)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	reply, err := redis.ByteSlices(conn.Do("BRPOP", "run#jobs", ceilSeconds(timeout)))
+	if err == redis.ErrNil {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return reply[1], nil
+}
+
+// CountJobs tells the length of the run#jobs list
+func (s *RedisStore) CountJobs() (int, error) {
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	return redis.Int(conn.Do("LLEN", "run#jobs"))
+}
+
+// PublishStdin publishes the input to the stdin channel of the run
+func (s *RedisStore) PublishStdin(uuid string, input []byte) error {
+	return s.Publish(uuid+"#stdin", input)
+}
+
+// SubscribeStdin subscribes to the stdin channel of the run
+func (s *RedisStore) SubscribeStdin(uuid string) (Subscription, error) {
+	return s.Subscribe(uuid + "#stdin")
+}
+
+// Publish publishes the message to the channel
+func (s *RedisStore) Publish(channel string, message []byte) error {
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	_, err := conn.Do("PUBLISH", channel, message)
+	return err
+}
+
+// Subscribe subscribes to the channel. The subscription has its own
+// connection, which waits for the messages without a read timeout.
+func (s *RedisStore) Subscribe(channel string) (Subscription, error) {
+	conn, err := s.dial(0)
+	if err != nil {
+		return nil, err
+	}
+
+	psc := redis.PubSubConn{Conn: conn}
+	if err := psc.Subscribe(channel); err != nil {
+		psc.Close()
+		return nil, err
+	}
+
+	return &redisSubscription{psc: psc, channel: channel}, nil
+}
+
+// set stores the runner under id#kind, and keeps its ID in kind#expiry
+// if it expires
+func (s *RedisStore) set(kind, id string, runner *Runner) error {
+	bts, err := json.Marshal(runner)
+	if err != nil {
+		return err
+	}
+
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	conn.Send("MULTI")
+	conn.Send("SET", id+"#"+kind, string(bts))
+	if runner.ExpiresAt != nil {
+		conn.Send("ZADD", kind+"#expiry", runner.ExpiresAt.Unix(), id)
+	} else {
+		conn.Send("ZREM", kind+"#expiry", id)
+	}
+	_, err = conn.Do("EXEC")
+	return err
+}
+
+func (s *RedisStore) get(kind, id string) (*Runner, error) {
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	value, err := redis.Bytes(conn.Do("GET", id+"#"+kind))
+	if err == redis.ErrNil {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	runner := &Runner{}
+	if err := json.Unmarshal(value, runner); err != nil {
+		return nil, err
+	}
+
+	// It may not be reaped yet
+	if runner.expired(time.Now()) {
+		return nil, ErrNotFound
+	}
+	return runner, nil
+}
+
+// del removes id#kind, and the other keys that go with it
+func (s *RedisStore) del(kind, id string, others ...interface{}) (bool, error) {
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	conn.Send("MULTI")
+	conn.Send("DEL", id+"#"+kind)
+	conn.Send("ZREM", kind+"#expiry", id)
+	if len(others) > 0 {
+		conn.Send("DEL", others...)
+	}
+	replies, err := redis.Values(conn.Do("EXEC"))
+	if err != nil {
+		return false, err
+	}
+
+	n, err := redis.Int(replies[0], nil)
+	return n > 0, err
+}
+
+// redisSubscription receives the messages of a channel. The connection
+// is closed once Receive sees the subscription is over.
+type redisSubscription struct {
+	psc     redis.PubSubConn
+	channel string
+}
+
+func (sub *redisSubscription) Receive() ([]byte, error) {
+	for {
+		switch n := sub.psc.Receive().(type) {
+		case redis.Message:
+			return n.Data, nil
+		case redis.Subscription:
+			if n.Kind == "unsubscribe" && n.Count == 0 {
+				sub.psc.Close()
+				return nil, io.EOF
+			}
+		case error:
+			sub.psc.Close()
+			return nil, n
+		}
+	}
+}
+
+// Close unsubscribes from the channel, which ends the blocking Receive
+func (sub *redisSubscription) Close() error {
+	return sub.psc.Unsubscribe(sub.channel)
+}
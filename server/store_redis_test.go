@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// fakeSentinel replies the address of the master to whatever it's asked
+func fakeSentinel(t *testing.T) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer conn.Close()
+				// The command is an array of 3 bulk strings, 7 lines in all
+				r := bufio.NewReader(conn)
+				for i := 0; i < 7; i++ {
+					if _, err := r.ReadString('\n'); err != nil {
+						return
+					}
+				}
+				conn.Write([]byte("*2\r\n$8\r\n10.0.0.1\r\n$4\r\n6380\r\n"))
+			}()
+		}
+	}()
+
+	return l
+}
+
+func TestRedisStoreMasterAddress(t *testing.T) {
+	l := fakeSentinel(t)
+	defer l.Close()
+
+	store := NewRedisStore(RedisConfig{
+		SentinelAddresses: []string{"127.0.0.1:1", l.Addr().String()},
+		SentinelMaster:    "mymaster",
+	})
+
+	address, err := store.masterAddress()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if address != "10.0.0.1:6380" {
+		t.Fatalf("Expected the master at 10.0.0.1:6380, got %s", address)
+	}
+}
+
+func TestRedisStoreMasterCached(t *testing.T) {
+	l := fakeSentinel(t)
+
+	store := NewRedisStore(RedisConfig{
+		SentinelAddresses: []string{l.Addr().String()},
+		SentinelMaster:    "mymaster",
+	})
+
+	if address, err := store.masterOf(); err != nil || address != "10.0.0.1:6380" {
+		t.Fatalf("Expected the master at 10.0.0.1:6380, got %s - %v", address, err)
+	}
+
+	// The sentinels are not asked again while the master is known
+	l.Close()
+	if address, err := store.masterOf(); err != nil || address != "10.0.0.1:6380" {
+		t.Fatalf("Expected the master to be kept, got %s - %v", address, err)
+	}
+
+	store.forgetMaster("10.0.0.1:6380")
+	if _, err := store.masterOf(); err == nil {
+		t.Fatal("Expected the sentinels to be asked again once the master is forgotten")
+	}
+}
+
+// replicaConn replies READONLY to every command
+type replicaConn struct {
+	redis.Conn
+	commands []string
+}
+
+func (c *replicaConn) Do(command string, args ...interface{}) (interface{}, error) {
+	c.commands = append(c.commands, command)
+	return nil, redis.Error("READONLY You can't write against a read only replica.")
+}
+
+func (c *replicaConn) Err() error {
+	return nil
+}
+
+func TestRedisStoreReadOnly(t *testing.T) {
+	store := NewRedisStore(RedisConfig{SentinelAddresses: []string{"127.0.0.1:1"}})
+	store.master = "10.0.0.1:6380"
+
+	replica := &replicaConn{}
+	conn := &metricConn{Conn: replica, store: store, address: "10.0.0.1:6380"}
+
+	// A connection used a moment ago is not checked
+	if err := store.testOnBorrow(conn, time.Now()); err != nil || len(replica.commands) != 0 {
+		t.Fatalf("Expected the connection to be taken as it is, got %v after %v", err, replica.commands)
+	}
+
+	if _, err := conn.Do("SET", "abc#run", "{}"); err == nil {
+		t.Fatal("Expected the replica to refuse the write")
+	}
+	if conn.Err() == nil {
+		t.Fatal("Expected the connection not to be taken back by the pool")
+	}
+	if store.master != "" {
+		t.Fatalf("Expected the master to be asked again, got %s", store.master)
+	}
+
+	store.master = "10.0.0.1:6380"
+	if err := store.testOnBorrow(conn, time.Now().Add(-time.Hour)); err == nil {
+		t.Fatal("Expected an idle connection to the replica to be dropped")
+	}
+	if replica.commands[len(replica.commands)-1] != "ROLE" {
+		t.Fatalf("Expected the idle connection to be checked, got %v", replica.commands)
+	}
+}
+
+// execConn replies to EXEC with the replies of a transaction
+type execConn struct {
+	redis.Conn
+	sent    [][]interface{}
+	replies []interface{}
+}
+
+func (c *execConn) Send(command string, args ...interface{}) error {
+	c.sent = append(c.sent, append([]interface{}{command}, args...))
+	return nil
+}
+
+func (c *execConn) Do(command string, args ...interface{}) (interface{}, error) {
+	return c.replies, nil
+}
+
+func (c *execConn) Err() error {
+	return nil
+}
+
+func (c *execConn) Close() error {
+	return nil
+}
+
+func TestRedisStoreSaveAPIKey(t *testing.T) {
+	store := NewRedisStore(RedisConfig{Address: "127.0.0.1:1"})
+	conn := &execConn{replies: []interface{}{"OK", int64(1)}}
+	store.pool = &redis.Pool{Dial: func() (redis.Conn, error) { return conn, nil }}
+
+	if err := store.SaveAPIKey(&APIKey{ID: "abc"}); err != nil {
+		t.Fatal(err)
+	}
+	if set := conn.sent[1]; len(set) != 4 || set[0] != "SET" || set[3] != "NX" {
+		t.Fatalf("Expected the key to be set only if it doesn't exist, got %v", set)
+	}
+
+	// SET NX replies nil when the ID is taken
+	conn.replies = []interface{}{nil, int64(0)}
+	if err := store.SaveAPIKey(&APIKey{ID: "abc"}); err != ErrAPIKeyExists {
+		t.Fatalf("Expected the ID to be taken, got %v", err)
+	}
+}
+
+func TestRedisStoreUnreachable(t *testing.T) {
+	store := NewRedisStore(RedisConfig{Address: "127.0.0.1:1"})
+	if err := store.Ping(); err == nil {
+		t.Fatal("Redis should not be reachable")
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"golang.org/x/net/websocket"
+)
+
+// Types of the messages carried over the WebSocket
+const (
+	WSStdin      = "stdin"
+	WSCloseStdin = "close_stdin"
+	WSSignal     = "signal"
+	WSResize     = "resize"
+	WSError      = "error"
+)
+
+// stdinBacklog is how many stdin messages of a WebSocket wait for the program
+// to read them
+const stdinBacklog = 64
+
+var errStdinBacklog = errors.New("the program is not reading its stdin, the message is dropped")
+
+// WSMessage is a message sent in either direction over the WebSocket.
+// Output messages are typed by their stream (stdout, stderr, queued, started
+// and exit).
+type WSMessage struct {
+	Type   string          `json:"type"`
+	Data   string          `json:"data,omitempty"`
+	Signal string          `json:"signal,omitempty"`
+	Cols   uint            `json:"cols,omitempty"`
+	Rows   uint            `json:"rows,omitempty"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Queue  json.RawMessage `json:"queue,omitempty"` // Of the queued and started messages
+}
+
+// serveRunWebSocket runs the registered code with stdin, output and the
+// control messages carried over a single WebSocket.
+func (s *Server) serveRunWebSocket(w http.ResponseWriter, r *http.Request, uuid string) {
+	runner, err := s.store.FetchRun(uuid)
+	if err != nil {
+		s.logger.Infof("Source code cannot be found - %v", err)
+		writeAPIError(w, http.StatusNotFound, ErrCodeNotFound, "The run doesn't exist")
+		return
+	}
+
+	if err := admit(runner); err != nil {
+		writeQueueFull(w, true)
+		return
+	}
+	// The handler is not called when the handshake fails, the turn is given
+	// back then
+	defer runner.ticket.Done()
+
+	keyRun, err := s.startKeyRun(runner.APIKey)
+	if err != nil {
+		s.writeKeyRunError(w, err)
+		return
+	}
+	defer s.finishKeyRun(keyRun)
+
+	websocket.Handler(func(ws *websocket.Conn) {
+		defer ws.Close()
+
+		closeNotifier := make(chan bool, 1)
+		runner.closeNotifier = closeNotifier
+		runner.logger = s.logger
+		runner.tty = r.FormValue("tty") == "true"
+
+		client := NewClient(runner, uuid)
+
+		go client.ReadWebSocket(ws, closeNotifier)
+		go client.WriteWebSocket(ws)
+		keyRun.charge(runner, client.Run())
+
+		s.purgeRun(uuid)
+	}).ServeHTTP(w, r)
+}
+
+// ReadWebSocket consumes stdin and control messages from the WebSocket.
+// The run is cancelled once the WebSocket is gone.
+func (cli *Client) ReadWebSocket(ws *websocket.Conn, closeNotifier chan<- bool) {
+	// Stdin is written on its own, so the signals are not held up by a
+	// program that is queued or not reading its stdin
+	stdin := make(chan WSMessage, stdinBacklog)
+	go cli.writeWebSocketStdin(ws, stdin)
+
+	defer func() {
+		close(stdin)
+		closeNotifier <- true
+	}()
+
+	for {
+		var msg WSMessage
+		if err := websocket.JSON.Receive(ws, &msg); err != nil {
+			cli.logger().Infof("WebSocket of %s closed - %v", cli.uuid, err)
+			return
+		}
+
+		var err error
+		switch msg.Type {
+		case WSStdin, WSCloseStdin:
+			if msg.Type == WSStdin {
+				metrics.stdinMessages.Inc("websocket")
+			}
+
+			select {
+			case stdin <- msg:
+			default:
+				err = errStdinBacklog
+			}
+		case WSSignal:
+			err = cli.runner.Signal(msg.Signal)
+		case WSResize:
+			err = cli.runner.Resize(msg.Rows, msg.Cols)
+		default:
+			cli.logger().Infof("Unknown WebSocket message %s from %s", msg.Type, cli.uuid)
+		}
+
+		if err != nil {
+			websocket.JSON.Send(ws, WSMessage{Type: WSError, Data: err.Error()})
+		}
+	}
+}
+
+// writeWebSocketStdin writes the stdin messages into the program in order, and
+// closes its stdin once the WebSocket is gone
+func (cli *Client) writeWebSocketStdin(ws *websocket.Conn, stdin <-chan WSMessage) {
+	defer cli.stdinWriter.Close()
+
+	for msg := range stdin {
+		var err error
+		if msg.Type == WSCloseStdin {
+			err = cli.stdinWriter.Close()
+		} else {
+			_, err = cli.stdinWriter.Write([]byte(msg.Data))
+		}
+
+		if err != nil {
+			websocket.JSON.Send(ws, WSMessage{Type: WSError, Data: err.Error()})
+		}
+	}
+}
+
+// WriteWebSocket sends the output to the WebSocket as typed messages
+func (cli *Client) WriteWebSocket(ws *websocket.Conn) {
+	defer close(cli.writeDone)
+
+	cli.consume(func(fr frame) bool {
+		msg := WSMessage{Type: fr.stream}
+		switch {
+		case fr.stream == StreamExit:
+			msg.Result = json.RawMessage(fr.data)
+		case isQueueStream(fr.stream):
+			msg.Queue = json.RawMessage(fr.data)
+		default:
+			msg.Data = string(fr.data)
+		}
+
+		if err := websocket.JSON.Send(ws, msg); err != nil {
+			cli.logger().Errorf("WebSocket is not writable for %s - %v", cli.uuid, err)
+			return false
+		}
+		return true
+	})
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+func TestRunWebSocket(t *testing.T) {
+	s := newTestServer()
+	defer func() { Workers, appConfig = nil, nil }()
+	defer func(q *RunQueue) { Runqueue = q }(Runqueue)
+
+	// The run is handed to a fake worker, which echoes the stdin and tells
+	// the signals it gets
+	Workers = s.store
+	Runqueue = NewRunQueue(1, 10)
+	go fakeWorker(t, s.store, func(job *Job, in jobInput, publish func(string, []byte)) bool {
+		switch in.Type {
+		case jobStdin:
+			publish(StreamStdout, in.Data)
+		case jobSignal:
+			publish(StreamStderr, []byte(in.Signal))
+		case jobCloseStdin:
+			publish(StreamExit, []byte(`{"exit_code": 0, "reason": "exited"}`))
+			return false
+		}
+		return true
+	})
+
+	s.store.SaveRun("abc", &Runner{Lang: "ruby", Source: "puts gets"})
+	server := httptest.NewServer(http.HandlerFunc(s.HandleRunsV2))
+	defer server.Close()
+
+	ws, err := websocket.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/api/v2/runs/abc/ws", "", server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	ws.SetDeadline(time.Now().Add(5 * time.Second))
+
+	receive := func(expected string) WSMessage {
+		for {
+			var msg WSMessage
+			if err := websocket.JSON.Receive(ws, &msg); err != nil {
+				t.Fatalf("Expected a %s message, got %v", expected, err)
+			}
+			if msg.Type == expected {
+				return msg
+			}
+			if msg.Type != "started" {
+				t.Fatalf("Expected a %s message, got %+v", expected, msg)
+			}
+		}
+	}
+
+	websocket.JSON.Send(ws, WSMessage{Type: WSStdin, Data: "hello\n"})
+	if msg := receive(StreamStdout); msg.Data != "hello\n" {
+		t.Fatalf("Expected the stdin to be echoed, got %q", msg.Data)
+	}
+
+	websocket.JSON.Send(ws, WSMessage{Type: WSSignal, Signal: "SIGSEGV"})
+	if msg := receive(WSError); msg.Data != "signal SIGSEGV is not allowed" {
+		t.Fatalf("Expected the signal to be refused, got %q", msg.Data)
+	}
+
+	websocket.JSON.Send(ws, WSMessage{Type: WSSignal, Signal: "SIGINT"})
+	if msg := receive(StreamStderr); msg.Data != "SIGINT" {
+		t.Fatalf("Expected the signal to be sent, got %q", msg.Data)
+	}
+
+	websocket.JSON.Send(ws, WSMessage{Type: WSCloseStdin})
+	var result RunResult
+	if err := json.Unmarshal(receive(StreamExit).Result, &result); err != nil || result.Reason != ReasonExited {
+		t.Fatalf("Expected the result of the run, got %+v - %v", result, err)
+	}
+}
+
+func TestRunWebSocketQueued(t *testing.T) {
+	s := newTestServer()
+	defer func() { appConfig = nil }()
+	defer func(q *RunQueue) { Runqueue = q }(Runqueue)
+
+	// The only slot is taken, so nothing reads the stdin of the queued run
+	Runqueue = NewRunQueue(1, 10)
+	busy, _ := Runqueue.Join("ruby", false)
+	defer busy.Done()
+
+	s.store.SaveRun("abc", &Runner{Lang: "ruby", Source: "puts gets"})
+	server := httptest.NewServer(http.HandlerFunc(s.HandleRunsV2))
+	defer server.Close()
+
+	ws, err := websocket.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/api/v2/runs/abc/ws", "", server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	ws.SetDeadline(time.Now().Add(5 * time.Second))
+
+	websocket.JSON.Send(ws, WSMessage{Type: WSStdin, Data: "hello\n"})
+	websocket.JSON.Send(ws, WSMessage{Type: WSSignal, Signal: "SIGINT"})
+
+	for {
+		var msg WSMessage
+		if err := websocket.JSON.Receive(ws, &msg); err != nil {
+			t.Fatalf("Expected the signal to be handled behind the stdin, got %v", err)
+		}
+		if msg.Type == WSError {
+			if msg.Data != errNotStarted.Error() {
+				t.Fatalf("Expected the run not to be started, got %q", msg.Data)
+			}
+			break
+		}
+		if msg.Type != StreamQueued {
+			t.Fatalf("Expected the run to be queued, got %+v", msg)
+		}
+	}
+
+	// The run leaves the queue once the WebSocket is gone
+	ws.Close()
+	for i := 0; Runqueue.Status().Queued != 0; i++ {
+		if i == 100 {
+			t.Fatal("Expected the run to leave the queue")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRunWebSocketHandshakeFailed(t *testing.T) {
+	s := newTestServer()
+	defer func() { appConfig = nil }()
+	defer func(q *RunQueue) { Runqueue = q }(Runqueue)
+	Runqueue = NewRunQueue(1, 0)
+
+	s.store.SaveRun("abc", &Runner{Lang: "ruby", Source: "puts 1"})
+	server := httptest.NewServer(http.HandlerFunc(s.HandleRunsV2))
+	defer server.Close()
+
+	for i := 0; i < 3; i++ {
+		resp, err := http.Get(server.URL + "/api/v2/runs/abc/ws")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Fatalf("Expected the plain GET to be refused, got %d", resp.StatusCode)
+		}
+	}
+
+	if status := Runqueue.Status(); status.Running != 0 || status.Queued != 0 {
+		t.Fatalf("Expected the turns to be given back, got %+v", status)
+	}
+}
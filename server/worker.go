@@ -0,0 +1,377 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+)
+
+// Modes of the server
+const (
+	ModeStandalone = "standalone" // Serves the API and runs the code
+	ModeAPI        = "api"        // Serves the API, the workers run the code
+	ModeWorker     = "worker"     // Runs the code of the API servers
+)
+
+// Workers is the store the runs are handed to the workers through in the api
+// mode. The runs are run by the server itself when it's nil.
+var Workers Store
+
+// jobAcceptTimeout is how long a job waits for a worker to take it, the job
+// is dropped by the workers after it
+var jobAcceptTimeout = 60 * time.Second
+
+// jobExitGrace is how long a taken job may go on after its timeout before
+// its worker is taken as gone
+var jobExitGrace = 60 * time.Second
+
+// jobPollTimeout is how long a worker waits for a job at a time
+const jobPollTimeout = 5 * time.Second
+
+// jobPositionInterval is how often a relayed run waiting for a worker checks
+// where it is in the job queue
+var jobPositionInterval = time.Second
+
+// Job is a run handed to a worker
+type Job struct {
+	UUID     string    `json:"uuid"`
+	Runner   *Runner   `json:"runner"`
+	TTY      bool      `json:"tty,omitempty"`
+	Check    bool      `json:"check,omitempty"`
+	Deadline time.Time `json:"deadline"` // The job is dropped when no worker has taken it before
+}
+
+// Messages of a job other than the output streams
+const (
+	jobAccepted   = "accepted" // The worker has taken the job and listens to its input
+	jobStdin      = "stdin"
+	jobCloseStdin = "close_stdin"
+	jobSignal     = "signal"
+	jobResize     = "resize"
+	jobCancel     = "cancel"
+)
+
+// jobOutput is published by the worker to the uuid#output channel of the job
+type jobOutput struct {
+	Stream string `json:"stream"` // An output stream, or accepted
+	Data   []byte `json:"data,omitempty"`
+}
+
+// jobInput is published to the uuid#input channel of the job for its worker
+type jobInput struct {
+	Type   string `json:"type"`
+	Data   []byte `json:"data,omitempty"`
+	Signal string `json:"signal,omitempty"`
+	Height uint   `json:"height,omitempty"`
+	Width  uint   `json:"width,omitempty"`
+}
+
+// relay hands the run over to a worker through Workers. The run is told where
+// it is in the job queue until a worker takes it, then the stdin is relayed to
+// the worker and its output back to stdout and stderr until it tells the
+// result.
+func (rnr *Runner) relay(r io.Reader, stdout, stderr io.Writer, uuid string, requestedAt time.Time) *RunResult {
+	result := &RunResult{ExitCode: -1, Reason: ReasonInternalError}
+
+	sub, err := Workers.Subscribe(uuid + "#output")
+	if err != nil {
+		rnr.logger.Errorf("Output of job %s cannot be subscribed - %v", uuid, err)
+		return result
+	}
+	done := make(chan struct{})
+	defer sub.Close()
+	defer close(done)
+
+	outputs := make(chan jobOutput)
+	go receiveOutputs(sub, outputs, done)
+
+	ahead, _ := Workers.CountJobs()
+	job, err := json.Marshal(Job{UUID: uuid, Runner: rnr, TTY: rnr.tty, Check: rnr.check, Deadline: time.Now().Add(jobAcceptTimeout)})
+	if err == nil {
+		err = Workers.PushJob(job)
+	}
+	if err != nil {
+		rnr.logger.Errorf("Job %s cannot be handed to the workers - %v", uuid, err)
+		return result
+	}
+
+	// The jobs pushed after the run are counted too, so the position only
+	// goes down
+	position := ahead + 1
+	if ahead > 0 && rnr.onQueued != nil {
+		rnr.onQueued(QueuePosition{Position: position})
+	}
+	ticker := time.NewTicker(jobPositionInterval)
+	defer ticker.Stop()
+	positions := ticker.C
+
+	send := func(in jobInput) error {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		return Workers.Publish(uuid+"#input", data)
+	}
+
+	deadline := time.NewTimer(jobAcceptTimeout)
+	defer deadline.Stop()
+
+	closeNotifier := rnr.closeNotifier
+	accepted, cancelled := false, false
+
+	for {
+		select {
+		case out, ok := <-outputs:
+			if !ok {
+				rnr.logger.Errorf("Output subscription of job %s is lost", uuid)
+				return result
+			}
+
+			switch out.Stream {
+			case jobAccepted:
+				accepted, positions = true, nil
+				metrics.queueWaitSeconds.Since(requestedAt)
+				if rnr.onStarted != nil {
+					rnr.onStarted(msSince(requestedAt))
+				}
+				deadline.Reset(time.Duration(rnr.Timeout)*time.Second + jobExitGrace)
+				rnr.setRelay(send)
+				if cancelled {
+					send(jobInput{Type: jobCancel})
+				} else if rnr.Stdin == nil && r != nil {
+					go relayStdin(r, send)
+				}
+			case StreamStdout:
+				stdout.Write(out.Data)
+			case StreamStderr:
+				stderr.Write(out.Data)
+			case StreamExit:
+				if err := json.Unmarshal(out.Data, result); err != nil {
+					rnr.logger.Errorf("Result of job %s cannot be decoded - %v", uuid, err)
+				}
+				return result
+			}
+		case <-positions:
+			jobs, err := Workers.CountJobs()
+			if err == nil && jobs < position {
+				position = jobs
+				if position > 0 && rnr.onQueued != nil {
+					rnr.onQueued(QueuePosition{Position: position})
+				}
+			}
+		case <-closeNotifier:
+			// The worker is told once it has taken the job
+			closeNotifier, cancelled = nil, true
+			if accepted {
+				send(jobInput{Type: jobCancel})
+			}
+		case <-deadline.C:
+			if accepted {
+				rnr.logger.Errorf("Worker of job %s is gone", uuid)
+			} else {
+				rnr.logger.Errorf("No worker has taken job %s in time", uuid)
+				if cancelled {
+					result.Reason = ReasonCancelled
+				}
+			}
+			return result
+		}
+	}
+}
+
+// receiveOutputs decodes the messages of the worker until the subscription is
+// closed, or the relay is done
+func receiveOutputs(sub Subscription, outputs chan<- jobOutput, done <-chan struct{}) {
+	defer close(outputs)
+
+	for {
+		data, err := sub.Receive()
+		if err != nil {
+			return
+		}
+
+		var out jobOutput
+		if err := json.Unmarshal(data, &out); err != nil {
+			continue
+		}
+
+		select {
+		case outputs <- out:
+		case <-done:
+			return
+		}
+	}
+}
+
+// relayStdin sends the stdin to the worker as it comes, and closes the stdin
+// of the program once r reaches EOF
+func relayStdin(r io.Reader, send func(jobInput) error) {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			if send(jobInput{Type: jobStdin, Data: buf[:n]}) != nil {
+				return
+			}
+		}
+		if err != nil {
+			send(jobInput{Type: jobCloseStdin})
+			return
+		}
+	}
+}
+
+func (rnr *Runner) setRelay(send func(jobInput) error) {
+	rnr.mu.Lock()
+	defer rnr.mu.Unlock()
+	rnr.relayInput = send
+}
+
+// relayed returns how the input is sent to the worker of the run, nil when
+// the run is not relayed or not taken by a worker yet
+func (rnr *Runner) relayed() func(jobInput) error {
+	rnr.mu.Lock()
+	defer rnr.mu.Unlock()
+	return rnr.relayInput
+}
+
+// Work takes the jobs of the API servers from the store and runs them, up to
+// slots at the same time
+func (s *Server) Work(slots int) {
+	if slots < 1 {
+		slots = 1
+	}
+	s.logger.Infof("KodeRunr worker taking up to %d runs", slots)
+
+	free := make(chan struct{}, slots)
+	for {
+		free <- struct{}{}
+
+		data, err := s.store.PopJob(jobPollTimeout)
+		if err != nil {
+			<-free
+			if err != ErrNotFound {
+				s.logger.Errorf("Jobs cannot be taken - %v", err)
+				time.Sleep(time.Second)
+			}
+			continue
+		}
+
+		job := &Job{}
+		if err := json.Unmarshal(data, job); err != nil || job.Runner == nil {
+			<-free
+			s.logger.Errorf("Job %q cannot be decoded - %v", data, err)
+			continue
+		}
+		if time.Now().After(job.Deadline) {
+			<-free
+			s.logger.Infof("Job %s is dropped as it has waited too long", job.UUID)
+			continue
+		}
+
+		go func() {
+			defer func() { <-free }()
+			s.work(job)
+		}()
+	}
+}
+
+// work runs the job, with the input sent to its uuid#input channel and the
+// output published to its uuid#output channel
+func (s *Server) work(job *Job) {
+	runner := job.Runner
+	runner.logger = s.logger
+	runner.tty, runner.check = job.TTY, job.Check
+
+	publish := func(stream string, data []byte) error {
+		msg, err := json.Marshal(jobOutput{Stream: stream, Data: data})
+		if err != nil {
+			return err
+		}
+		return s.store.Publish(job.UUID+"#output", msg)
+	}
+
+	sub, err := s.store.Subscribe(job.UUID + "#input")
+	if err != nil {
+		s.logger.Errorf("Input of job %s cannot be subscribed - %v", job.UUID, err)
+		return
+	}
+	defer sub.Close()
+
+	cancel := make(chan bool)
+	runner.closeNotifier = cancel
+	stdinReader, stdinWriter := io.Pipe()
+	go s.receiveInput(sub, runner, stdinWriter, cancel)
+
+	s.logger.Infof("Running job %s", job.UUID)
+	if err := publish(jobAccepted, nil); err != nil {
+		s.logger.Errorf("Job %s cannot be accepted - %v", job.UUID, err)
+		return
+	}
+
+	stdout := &jobWriter{stream: StreamStdout, publish: publish}
+	stderr := &jobWriter{stream: StreamStderr, publish: publish}
+	result := runner.Run(stdinReader, stdout, stderr, job.UUID)
+	stdinReader.Close()
+
+	data, err := json.Marshal(result)
+	if err == nil {
+		err = publish(StreamExit, data)
+	}
+	if err != nil {
+		s.logger.Errorf("Result of job %s cannot be published - %v", job.UUID, err)
+	}
+}
+
+// receiveInput hands the input of the job over to the runner until the
+// subscription is closed
+func (s *Server) receiveInput(sub Subscription, runner *Runner, stdin *io.PipeWriter, cancel chan<- bool) {
+	defer stdin.Close()
+	cancelled := false
+
+	for {
+		data, err := sub.Receive()
+		if err != nil {
+			return
+		}
+
+		var in jobInput
+		if err := json.Unmarshal(data, &in); err != nil {
+			s.logger.Errorf("Input %q cannot be decoded - %v", data, err)
+			continue
+		}
+
+		switch in.Type {
+		case jobStdin:
+			_, err = stdin.Write(in.Data)
+		case jobCloseStdin:
+			err = stdin.Close()
+		case jobSignal:
+			err = runner.Signal(in.Signal)
+		case jobResize:
+			err = runner.Resize(in.Height, in.Width)
+		case jobCancel:
+			if !cancelled {
+				cancelled = true
+				close(cancel)
+			}
+		}
+
+		if err != nil {
+			s.logger.Errorf("Input %s cannot be handed over - %v", in.Type, err)
+		}
+	}
+}
+
+// jobWriter publishes whatever is written to it as the output of the stream
+type jobWriter struct {
+	stream  string
+	publish func(stream string, data []byte) error
+}
+
+func (jw *jobWriter) Write(p []byte) (int, error) {
+	if err := jw.publish(jw.stream, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
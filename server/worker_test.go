@@ -0,0 +1,247 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeWorker takes the next job like a worker, and hands its input messages
+// to handle until handle returns false
+func fakeWorker(t *testing.T, store Store, handle func(job *Job, in jobInput, publish func(string, []byte)) bool) {
+	data, err := store.PopJob(time.Second)
+	if err != nil {
+		t.Errorf("Expected a job, got %v", err)
+		return
+	}
+
+	job := &Job{}
+	if err := json.Unmarshal(data, job); err != nil {
+		t.Errorf("Expected the job to be decoded, got %v", err)
+		return
+	}
+
+	sub, _ := store.Subscribe(job.UUID + "#input")
+	defer sub.Close()
+
+	publish := func(stream string, data []byte) {
+		msg, _ := json.Marshal(jobOutput{Stream: stream, Data: data})
+		store.Publish(job.UUID+"#output", msg)
+	}
+	publish(jobAccepted, nil)
+
+	for {
+		data, err := sub.Receive()
+		if err != nil {
+			return
+		}
+
+		var in jobInput
+		json.Unmarshal(data, &in)
+		if !handle(job, in, publish) {
+			return
+		}
+	}
+}
+
+// runJob takes the next job like a worker, accepts it and hands it to run,
+// which publishes its output
+func runJob(t *testing.T, store Store, run func(job *Job, publish func(string, []byte))) {
+	data, err := store.PopJob(time.Second)
+	if err != nil {
+		t.Errorf("Expected a job, got %v", err)
+		return
+	}
+
+	job := &Job{}
+	json.Unmarshal(data, job)
+	publish := func(stream string, data []byte) {
+		msg, _ := json.Marshal(jobOutput{Stream: stream, Data: data})
+		store.Publish(job.UUID+"#output", msg)
+	}
+
+	publish(jobAccepted, nil)
+	run(job, publish)
+}
+
+func newRelayRunner() *Runner {
+	s := newTestServer()
+	Workers = s.store
+	return &Runner{Lang: "ruby", Source: "puts gets", Timeout: 5, tty: true, logger: s.logger}
+}
+
+func TestRelay(t *testing.T) {
+	runner := newRelayRunner()
+	defer func() { Workers, appConfig = nil, nil }()
+
+	go fakeWorker(t, Workers, func(job *Job, in jobInput, publish func(string, []byte)) bool {
+		if job.Runner.Source != "puts gets" || !job.TTY {
+			t.Errorf("Expected the runner to be handed over, got %+v", job)
+		}
+
+		switch in.Type {
+		case jobStdin:
+			publish(StreamStdout, in.Data)
+		case jobCloseStdin:
+			publish(StreamStderr, []byte("bye"))
+			publish(StreamExit, []byte(`{"exit_code": 3, "reason": "exited", "run_time_ms": 7}`))
+			return false
+		}
+		return true
+	})
+
+	var stdout, stderr syncBuffer
+	result := runner.relay(strings.NewReader("hello\n"), &stdout, &stderr, "abc", time.Now())
+
+	if result.Reason != ReasonExited || result.ExitCode != 3 || result.RunTime != 7 {
+		t.Fatalf("Expected the result of the worker, got %+v", result)
+	}
+	if stdout.String() != "hello\n" || stderr.String() != "bye" {
+		t.Fatalf("Expected the output of the worker, got %q and %q", stdout.String(), stderr.String())
+	}
+}
+
+func TestRelayCancel(t *testing.T) {
+	runner := newRelayRunner()
+	defer func() { Workers, appConfig = nil, nil }()
+
+	closeNotifier := make(chan bool, 1)
+	closeNotifier <- true
+	runner.closeNotifier = closeNotifier
+	runner.Stdin = new(string)
+
+	go fakeWorker(t, Workers, func(job *Job, in jobInput, publish func(string, []byte)) bool {
+		if in.Type != jobCancel {
+			t.Errorf("Expected the job to be cancelled, got %+v", in)
+		}
+		publish(StreamExit, []byte(`{"exit_code": -1, "reason": "cancelled"}`))
+		return false
+	})
+
+	if result := runner.relay(nil, ioutil.Discard, ioutil.Discard, "abc", time.Now()); result.Reason != ReasonCancelled {
+		t.Fatalf("Expected the run to be cancelled, got %+v", result)
+	}
+}
+
+func TestRelayNotTaken(t *testing.T) {
+	runner := newRelayRunner()
+	jobAcceptTimeout = 20 * time.Millisecond
+	defer func() { Workers, appConfig, jobAcceptTimeout = nil, nil, 60*time.Second }()
+
+	if result := runner.relay(nil, ioutil.Discard, ioutil.Discard, "abc", time.Now()); result.Reason != ReasonInternalError {
+		t.Fatalf("Expected the run to fail without a worker, got %+v", result)
+	}
+
+	data, err := Workers.PopJob(time.Millisecond)
+	if err != nil {
+		t.Fatalf("Expected the job to be left behind, got %v", err)
+	}
+	var job Job
+	json.Unmarshal(data, &job)
+	if !time.Now().After(job.Deadline) {
+		t.Fatalf("Expected the job to be past its deadline, got %v", job.Deadline)
+	}
+}
+
+func TestReceiveInput(t *testing.T) {
+	s := newTestServer()
+	defer func() { appConfig = nil }()
+
+	sub, _ := s.store.Subscribe("abc#input")
+	cancel := make(chan bool)
+	stdinReader, stdinWriter := io.Pipe()
+	go s.receiveInput(sub, &Runner{logger: s.logger}, stdinWriter, cancel)
+
+	send := func(in jobInput) {
+		data, _ := json.Marshal(in)
+		s.store.Publish("abc#input", data)
+	}
+
+	go func() {
+		send(jobInput{Type: jobStdin, Data: []byte("abc")})
+		send(jobInput{Type: jobCloseStdin})
+		send(jobInput{Type: jobCancel})
+		send(jobInput{Type: jobCancel})
+	}()
+
+	stdin, _ := ioutil.ReadAll(stdinReader)
+	if string(stdin) != "abc" {
+		t.Fatalf("Expected the stdin to be handed over, got %q", stdin)
+	}
+
+	select {
+	case <-cancel:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the run to be cancelled")
+	}
+	sub.Close()
+}
+
+func TestRunQueueWorkers(t *testing.T) {
+	store := NewMemoryStore()
+	q := NewRunQueue(1, 2)
+	q.EnableWorkers(store)
+
+	// The runs don't hold the slots of the API server
+	for i := 0; i < 3; i++ {
+		if _, err := q.Join("ruby", false); err != nil {
+			t.Fatalf("Expected the run to go right away, got %v", err)
+		}
+	}
+
+	store.PushJob([]byte("{}"))
+	store.PushJob([]byte("{}"))
+	if _, err := q.Join("ruby", false); err != ErrQueueFull {
+		t.Fatalf("Expected the job queue to be full, got %v", err)
+	}
+	if _, err := q.Join("ruby", true); err != nil {
+		t.Fatalf("Expected a forced run to go, got %v", err)
+	}
+
+	if status := q.Status(); status.Slots != 0 || status.Running != 4 || status.Queued != 2 || status.MaxQueued != 2 {
+		t.Fatalf("Expected the status of the job queue, got %+v", status)
+	}
+}
+
+func TestRelayQueued(t *testing.T) {
+	runner := newRelayRunner()
+	jobPositionInterval = time.Millisecond
+	defer func() { Workers, appConfig, jobPositionInterval = nil, nil, time.Second }()
+
+	Workers.PushJob([]byte(`{"uuid": "first", "runner": {}}`))
+
+	var positions []int
+	queued := make(chan struct{}, 2)
+	runner.onQueued = func(p QueuePosition) {
+		positions = append(positions, p.Position)
+		queued <- struct{}{}
+	}
+	started := false
+	runner.onStarted = func(waited int64) { started = true }
+	runner.Stdin = new(string)
+
+	go func() {
+		<-queued
+		// The job ahead is taken first
+		Workers.PopJob(time.Second)
+		<-queued
+
+		Workers.PopJob(time.Second)
+		publish := func(out jobOutput) {
+			data, _ := json.Marshal(out)
+			Workers.Publish("abc#output", data)
+		}
+		publish(jobOutput{Stream: jobAccepted})
+		publish(jobOutput{Stream: StreamExit, Data: []byte(`{"reason": "exited"}`)})
+	}()
+
+	if result := runner.relay(nil, ioutil.Discard, ioutil.Discard, "abc", time.Now()); result.Reason != ReasonExited {
+		t.Fatalf("Expected the run to be relayed, got %+v", result)
+	}
+	if !started || len(positions) != 2 || positions[0] != 2 || positions[1] != 1 {
+		t.Fatalf("Expected the run to move from 2 to 1, got %v", positions)
+	}
+}